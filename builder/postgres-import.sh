@@ -0,0 +1,15 @@
+#!/bin/bash
+
+# the entrypoint decompresses the dump with zstd, which isn't installed in every builder image
+if compgen -G "/docker-entrypoint-initdb.d/*.zst" > /dev/null && ! command -v zstd > /dev/null; then
+    echo "zstd isn't installed in the builder image, set BUILDER_DUMP_COMPRESSION to gzip or none"
+    exit 1
+fi
+
+/usr/local/bin/docker-entrypoint.sh postgres > /tmp/output.log 2>&1
+
+if [ "$?" != "0" ]; then
+    # print the last 3 lines of the log that shows the error
+    tail -n 3 /tmp/output.log
+    exit 1
+fi
@@ -19,6 +19,7 @@ limitations under the License.
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/uselagoon/database-image-task/internal/builder"
@@ -28,6 +29,9 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "database-image-task",
 	Short: "A tool to help with generating database images via a task in Lagoon.",
+	// errors are printed by Execute, and the usage isn't helpful when a command fails because of its configuration
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -60,7 +64,59 @@ var dumpCmd = &cobra.Command{
 	Use:   "dump",
 	Short: "Return the build and mtk values",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return builder.Run()
+		redact, err := cmd.Flags().GetBool("redact")
+		if err != nil {
+			return err
+		}
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+		return builder.Run(builder.DumpOptions{Redact: redact, Format: format})
+	},
+}
+
+var explainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Show each of the build and mtk values and where they came from",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return builder.Explain(os.Stdout)
+	},
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check that the build and mtk values are valid before running a build",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := builder.Validate(); err != nil {
+			return err
+		}
+		fmt.Println("configuration is valid")
+		return nil
+	},
+}
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Dump the database and build and push the resulting image",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workDir, err := cmd.Flags().GetString("work-dir")
+		if err != nil {
+			return err
+		}
+		return builder.RunBuild(cmd.Context(), workDir)
+	},
+}
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check the database can be connected to and there is enough space to dump it, without dumping it",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workDir, err := cmd.Flags().GetString("work-dir")
+		if err != nil {
+			return err
+		}
+		return builder.RunPreflight(cmd.Context(), workDir)
 	},
 }
 
@@ -72,4 +128,12 @@ func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(dumpCmd)
+	rootCmd.AddCommand(buildCmd)
+	dumpCmd.Flags().Bool("redact", false, "Replace passwords and other secrets in the output with a placeholder")
+	dumpCmd.Flags().String("format", builder.FormatJSON, fmt.Sprintf("The output format, one of %s", strings.Join(builder.Formats, ", ")))
+	rootCmd.AddCommand(explainCmd)
+	rootCmd.AddCommand(validateCmd)
+	buildCmd.Flags().String("work-dir", ".", "The directory containing the builder dockerfiles and templates, the dump is written here")
+	rootCmd.AddCommand(preflightCmd)
+	preflightCmd.Flags().String("work-dir", ".", "The directory the dump would be written to, its free space is checked")
 }
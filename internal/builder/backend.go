@@ -0,0 +1,157 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/database-image-task/internal/docker"
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+// BuildRequest is the image that a BuildBackend is asked to build
+type BuildRequest struct {
+	// ContextDir is the build context, it holds the dockerfiles, the sanitised dump and the rendered templates
+	ContextDir string
+	// Dockerfile is the path of the database type's dockerfile in the build context
+	Dockerfile string
+	// ContextFiles are the files in ContextDir that the dockerfile copies, along with the dockerfile, backends that
+	// pack the build context themselves only include these
+	ContextFiles []string
+	// BuildArgs are the values of the ARGs in the dockerfile
+	BuildArgs map[string]string
+	// Tags are the full image names the result is tagged with
+	Tags []string
+}
+
+// BuildBackend builds the resulting image and pushes it to the registry, the backend is selected with
+// BUILDER_BUILD_BACKEND so that clusters without a docker host can still run the task
+type BuildBackend interface {
+	// Build builds the image, this is the "Make container with sanitised DB" stage
+	Build(ctx context.Context, req BuildRequest) error
+	// Push pushes each of the images built by Build, this is the "Save new container to registry" stage
+	Push(ctx context.Context, images []string) error
+}
+
+// backend returns the build backend for BUILDER_BUILD_BACKEND, it is kept for the push as some backends hold on to
+// what they built
+func (p *Pipeline) backend() BuildBackend {
+	if p.builder == nil {
+		switch p.Build.BuildBackend {
+		case "podman", "buildah":
+			p.builder = &cliBackend{p: p, command: p.Build.BuildBackend}
+		case "kaniko":
+			p.builder = &kanikoBackend{p: p}
+		case "oci":
+			p.builder = &ociBackend{p: p}
+		default:
+			p.builder = &dockerBackend{p: p}
+		}
+	}
+	return p.builder
+}
+
+// removeImages is true unless BUILDER_REMOVE_IMAGE is set to skip, the backends that keep images locally remove them
+// once they have been pushed
+func removeImages() bool {
+	return !strings.EqualFold(variables.GetEnv("BUILDER_REMOVE_IMAGE", ""), "skip")
+}
+
+// dockerBackend builds and pushes through the Engine API of the docker host
+type dockerBackend struct {
+	p      *Pipeline
+	client *docker.Client
+}
+
+// dockerClient returns the client for the configured docker host
+func (b *dockerBackend) dockerClient() (*docker.Client, error) {
+	if b.client == nil {
+		client, err := docker.NewClient(b.p.Build.DockerHost)
+		if err != nil {
+			return nil, err
+		}
+		b.client = client
+	}
+	return b.client, nil
+}
+
+// backoff returns how many times to check the docker host and how long to wait between each check
+func (r DockerReady) backoff() docker.Backoff {
+	attempts, _ := strconv.Atoi(r.Attempts)
+	delay, _ := strconv.Atoi(r.Delay)
+	maxDelay, _ := strconv.Atoi(r.MaxDelay)
+	return docker.Backoff{
+		Attempts:   attempts,
+		Initial:    time.Duration(delay) * time.Second,
+		Max:        time.Duration(maxDelay) * time.Second,
+		Multiplier: 2,
+	}
+}
+
+// waitForDockerHost checks that the docker host is available, backing off between each attempt before giving up
+func (b *dockerBackend) waitForDockerHost(ctx context.Context) error {
+	client, err := b.dockerClient()
+	if err != nil {
+		return err
+	}
+	// the backoff is only set on the pipeline in tests, otherwise it comes from the build values
+	backoff := b.p.DockerReadiness
+	if backoff == (docker.Backoff{}) {
+		backoff = b.p.Build.DockerReady.backoff()
+	}
+	return client.WaitReady(ctx, backoff, func(err error, wait time.Duration) {
+		fmt.Fprintf(b.p.Stdout, "%s not available yet, waiting for %s\n", b.p.Build.DockerHost, wait)
+	})
+}
+
+func (b *dockerBackend) Build(ctx context.Context, req BuildRequest) error {
+	if err := b.waitForDockerHost(ctx); err != nil {
+		return err
+	}
+	client, err := b.dockerClient()
+	if err != nil {
+		return err
+	}
+	err = client.Build(ctx, req.ContextDir, docker.BuildOptions{
+		Dockerfile:  req.Dockerfile,
+		Tags:        req.Tags,
+		BuildArgs:   req.BuildArgs,
+		NetworkMode: "host",
+	}, b.p.Stdout)
+	if err != nil {
+		return fmt.Errorf("docker build failed: %w", err)
+	}
+	return nil
+}
+
+// Push logs in to the registry and pushes the images, removing them from the docker host afterwards unless
+// BUILDER_REMOVE_IMAGE is set to skip
+func (b *dockerBackend) Push(ctx context.Context, images []string) error {
+	client, err := b.dockerClient()
+	if err != nil {
+		return err
+	}
+	auth := docker.AuthConfig{
+		Username:      b.p.Build.RegistryUsername,
+		Password:      b.p.Build.RegistryPassword,
+		ServerAddress: b.p.Build.RegistryHost,
+	}
+	if err := client.Login(ctx, auth); err != nil {
+		return fmt.Errorf("docker login failed: %w", err)
+	}
+	remove := removeImages()
+	for _, image := range images {
+		if err := client.Push(ctx, image, auth, b.p.Stdout); err != nil {
+			return fmt.Errorf("docker push of %s failed: %w", image, err)
+		}
+		if remove {
+			// failing to remove the image from the docker host shouldn't fail the build
+			if err := client.RemoveImage(ctx, image, true); err != nil {
+				fmt.Fprintf(b.p.Stdout, "unable to remove %s from the docker host: %v\n", image, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// cliBackend builds and pushes with the podman or buildah cli, these run without a daemon so the image is built
+// in the same container as the task, the flags used are the same for both
+type cliBackend struct {
+	p       *Pipeline
+	command string
+}
+
+func (b *cliBackend) run(ctx context.Context, c Command) error {
+	c.Name = b.command
+	c.Dir = b.p.WorkDir
+	c.Stdout = b.p.Stdout
+	c.Stderr = b.p.Stderr
+	return b.p.Executor.Run(ctx, c)
+}
+
+func (b *cliBackend) Build(ctx context.Context, req BuildRequest) error {
+	args := []string{"build", "--file", req.Dockerfile, "--network", "host"}
+	keys := []string{}
+	for key := range req.BuildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// only the names are given as flags, the values are read from the environment of the command, as they include the
+	// passwords of the resulting image which would otherwise show up in the process list
+	env := []string{}
+	for _, key := range keys {
+		args = append(args, "--build-arg", key)
+		env = append(env, fmt.Sprintf("%s=%s", key, req.BuildArgs[key]))
+	}
+	for _, tag := range req.Tags {
+		args = append(args, "--tag", tag)
+	}
+	args = append(args, req.ContextDir)
+	if err := b.run(ctx, Command{Args: args, Env: env}); err != nil {
+		return fmt.Errorf("%s build failed: %v", b.command, err)
+	}
+	return nil
+}
+
+// Push logs in to the registry and pushes the images, removing them from local storage afterwards unless
+// BUILDER_REMOVE_IMAGE is set to skip
+func (b *cliBackend) Push(ctx context.Context, images []string) error {
+	registry := b.p.Build.RegistryHost
+	if registry == "" {
+		registry = "docker.io"
+	}
+	// the password is given on stdin so that it doesn't show up in the process list
+	err := b.run(ctx, Command{
+		Args:  []string{"login", "--username", b.p.Build.RegistryUsername, "--password-stdin", registry},
+		Stdin: strings.NewReader(b.p.Build.RegistryPassword),
+	})
+	if err != nil {
+		return fmt.Errorf("%s login failed: %v", b.command, err)
+	}
+	remove := removeImages()
+	for _, image := range images {
+		if err := b.run(ctx, Command{Args: []string{"push", image}}); err != nil {
+			return fmt.Errorf("%s push of %s failed: %v", b.command, image, err)
+		}
+		if remove {
+			// failing to remove the image from local storage shouldn't fail the build
+			if err := b.run(ctx, Command{Args: []string{"rmi", "--force", image}}); err != nil {
+				fmt.Fprintf(b.p.Stdout, "unable to remove %s: %v\n", image, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uselagoon/database-image-task/internal/docker"
+)
+
+const (
+	kanikoContextFilename = "context.tar.gz"
+	kanikoArgsFilename    = "kaniko.args"
+	kanikoAuthFilename    = "config.json"
+)
+
+// kanikoBackend doesn't build or push anything itself, it writes the build context as a tarball along with the
+// dockerfile and the arguments for kaniko into BUILDER_CONTEXT_OUTPUT_DIR, so that kaniko can build and push the image
+// in a container that runs after the task, the build context only has the files that the dockerfile copies
+type kanikoBackend struct {
+	p   *Pipeline
+	req BuildRequest
+}
+
+func (b *kanikoBackend) Build(ctx context.Context, req BuildRequest) error {
+	dir := b.p.Build.ContextOutputDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, kanikoContextFilename))
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	if err := docker.WriteContextFiles(gz, req.ContextDir, req.ContextFiles); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write the build context: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(req.ContextDir, req.Dockerfile), filepath.Join(dir, "Dockerfile")); err != nil {
+		return err
+	}
+	b.req = req
+	fmt.Fprintf(b.p.Stdout, "wrote the build context for %s to %s, kaniko builds the image after the task\n", req.Dockerfile, dir)
+	return nil
+}
+
+// Push writes the arguments that kaniko needs to push the images, and the registry credentials in the docker config
+// format that kaniko reads them from, nothing is pushed until kaniko is run with them
+func (b *kanikoBackend) Push(ctx context.Context, images []string) error {
+	dir := b.p.Build.ContextOutputDir
+	args := []string{
+		fmt.Sprintf("--context=tar://%s", filepath.Join(dir, kanikoContextFilename)),
+		fmt.Sprintf("--dockerfile=%s", b.req.Dockerfile),
+	}
+	keys := []string{}
+	for key := range b.req.BuildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("--build-arg=%s=%s", key, b.req.BuildArgs[key]))
+	}
+	for _, image := range images {
+		args = append(args, fmt.Sprintf("--destination=%s", image))
+	}
+	// the arguments include the passwords of the resulting image as build args
+	if err := writePrivateFile(filepath.Join(dir, kanikoArgsFilename), []byte(strings.Join(args, "\n")+"\n")); err != nil {
+		return err
+	}
+	registry := b.p.Build.RegistryHost
+	if registry == "" || registry == "docker.io" {
+		registry = "https://index.docker.io/v1/"
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", b.p.Build.RegistryUsername, b.p.Build.RegistryPassword)))
+	config, err := json.MarshalIndent(map[string]any{
+		"auths": map[string]any{registry: map[string]string{"auth": auth}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writePrivateFile(filepath.Join(dir, kanikoAuthFilename), config); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.p.Stdout, "kaniko job prepared; not pushed, kaniko pushes %s after the task using the arguments in %s\n",
+		strings.Join(images, ", "), filepath.Join(dir, kanikoArgsFilename))
+	return nil
+}
+
+// writePrivateFile writes a file that is only readable by the user running the task, the output directory is usually
+// a volume shared with the kaniko container, so a file left there by an earlier task has its mode changed as well
+func writePrivateFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,366 @@
+package builder
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/database-image-task/internal/docker"
+	"github.com/uselagoon/database-image-task/internal/oci"
+)
+
+const (
+	sanitisedDumpFilename = "sanitised-dump.sql"
+	stepDivider           = "##############################################"
+)
+
+// Pipeline runs the stages of the image build (variable setup, preflight checks, database dump, image build, registry
+// push and removing old tags)
+// mariadb and mysql databases are dumped by the task itself, the postgres dump commands are run through the Executor,
+// and the image is built and pushed through the docker host's Engine API, so that each stage can be tested
+type Pipeline struct {
+	Build    Builder
+	WorkDir  string
+	Executor Executor
+	Stdout   io.Writer
+	Stderr   io.Writer
+	// Now is swapped out in tests
+	Now func() time.Time
+	// DockerReadiness replaces the backoff from BUILDER_DOCKER_READY_* while waiting for the docker host, it is set in
+	// tests
+	DockerReadiness docker.Backoff
+	// Entrypoint and InitDBDir are the builder image entrypoint and the directory it imports dumps from, these are
+	// only used by the oci build backend
+	Entrypoint string
+	InitDBDir  string
+	// InspectImage reads the config and files of the clean image so that its layout can be detected, it is swapped out
+	// in tests
+	InspectImage func(ctx context.Context, image string) (*oci.ImageInfo, error)
+	// ProbeReplica checks that a read replica can be connected to and how far behind it is, it is swapped out in tests
+	ProbeReplica func(ctx context.Context, host string) replicaStatus
+	// InspectDatabase reads the size of the database and the privileges of the user for the preflight checks, it is
+	// swapped out in tests
+	InspectDatabase func(ctx context.Context) (*databaseInfo, error)
+	// OpenDatabase connects to the mariadb or mysql database that is dumped, it is swapped out in tests
+	OpenDatabase func(ctx context.Context) (*sql.DB, error)
+	// LookPath finds the commands that the oci build backend runs to import the dump, it is swapped out in tests
+	LookPath func(file string) (string, error)
+
+	builder    BuildBackend
+	cleanImage imageLayout
+	// tlsDir is the private directory that any tls certificates and key given as PEM are written to
+	tlsDir     string
+	buildStart time.Time
+	stepStart  time.Time
+}
+
+// NewPipeline returns a pipeline that runs commands on the host in the provided working directory
+func NewPipeline(workDir string) *Pipeline {
+	p := &Pipeline{
+		WorkDir:    workDir,
+		Executor:   osExecutor{},
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		Now:        time.Now,
+		Entrypoint: "/usr/local/bin/docker-entrypoint.sh",
+		InitDBDir:  "/docker-entrypoint-initdb.d",
+		LookPath:   exec.LookPath,
+	}
+	p.InspectImage = p.inspectCleanImage
+	p.ProbeReplica = p.probeReplica
+	p.InspectDatabase = p.inspectDatabase
+	p.OpenDatabase = p.openDatabase
+	return p
+}
+
+// RunBuild will run all of the build stages in the provided working directory
+func RunBuild(ctx context.Context, workDir string) error {
+	return NewPipeline(workDir).Run(ctx)
+}
+
+// RunPreflight will only run the variable setup and preflight stages, so that the database can be checked without
+// dumping it
+func RunPreflight(ctx context.Context, workDir string) error {
+	return NewPipeline(workDir).Preflight(ctx)
+}
+
+// step is one of the stages of the build
+type step struct {
+	name string
+	run  func(context.Context) error
+}
+
+// Run executes each of the stages in order, stopping at the first one that fails
+func (p *Pipeline) Run(ctx context.Context) error {
+	return p.runSteps(ctx, []step{
+		{name: "Variable setup", run: p.variableSetup},
+		{name: "Preflight checks", run: p.preflight},
+		{name: "Database dump", run: p.databaseDump},
+		{name: "Make container with sanitised DB", run: p.imageBuild},
+		{name: "Save new container to registry", run: p.registryPush},
+		{name: "Remove old tags from registry", run: p.pruneTags},
+	})
+}
+
+// Preflight executes the variable setup and preflight stages
+func (p *Pipeline) Preflight(ctx context.Context) error {
+	return p.runSteps(ctx, []step{
+		{name: "Variable setup", run: p.variableSetup},
+		{name: "Preflight checks", run: p.preflight},
+	})
+}
+
+func (p *Pipeline) runSteps(ctx context.Context, steps []step) error {
+	p.buildStart = p.Now()
+	p.stepStart = p.buildStart
+	defer p.removeTLSFiles()
+	fmt.Fprintln(p.Stdout, "=======================")
+	fmt.Fprintln(p.Stdout, "Starting image-builder")
+	fmt.Fprintln(p.Stdout, "=======================")
+	for idx, step := range steps {
+		p.beginStep(step.name)
+		if err := step.run(ctx); err != nil {
+			return err
+		}
+		if idx == len(steps)-1 {
+			fmt.Fprintln(p.Stdout)
+			fmt.Fprintln(p.Stdout, "========================")
+			fmt.Fprintln(p.Stdout, "Finishing image-builder")
+			fmt.Fprintln(p.Stdout, "========================")
+		}
+		p.finalizeStep(step.name)
+	}
+	return nil
+}
+
+func (p *Pipeline) beginStep(name string) {
+	fmt.Fprintf(p.Stdout, "\n%s\nBEGIN %s\n%s\n", stepDivider, name, stepDivider)
+	fmt.Fprintf(p.Stdout, "Current time: %s\n\n", p.Now().Format(time.DateTime))
+}
+
+// finalizeStep prints the duration of the step that has just completed, and the total elapsed time of the build
+func (p *Pipeline) finalizeStep(name string) {
+	end := p.Now()
+	fmt.Fprintf(p.Stdout, "%s\nSTEP %s: Completed at %s (%s) Duration %s Elapsed %s\n%s\n",
+		stepDivider,
+		name,
+		end.Format(time.DateTime),
+		end.Format("MST"),
+		formatStepDuration(end.Sub(p.stepStart)),
+		formatStepDuration(end.Sub(p.buildStart)),
+		stepDivider,
+	)
+	p.stepStart = end
+}
+
+func formatStepDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, (s/60)%60, s%60)
+}
+
+// variableSetup generates the build values and works out the tags that the resulting image will be given
+func (p *Pipeline) variableSetup(ctx context.Context) error {
+	build, err := generateValues(p.Now())
+	if err != nil {
+		return err
+	}
+	p.Build = build
+	if p.Build.Debug {
+		// only ever display the redacted values, task logs are visible to everyone with access to the environment
+		debug, _ := json.MarshalIndent(p.Build.Redacted(), "", "  ")
+		fmt.Fprintln(p.Stdout, string(debug))
+	}
+	fmt.Fprintln(p.Stdout, p.Build.ResultImageName)
+	// error out before anything is dumped if any of the values are missing or invalid
+	if err := p.Build.Validate(); err != nil {
+		return err
+	}
+	if p.Build.ResultImageRandomRootPassword && p.Build.DatabaseType != "postgres" {
+		// the password is only printed here, it is redacted from the rest of the output and left out of the my.cnf of
+		// the resulting image, so that it can't be read by anyone who pulls the image
+		p.Build.ResultImageRootPassword = rand.Text()
+		fmt.Fprintf(p.Stdout, "generated root password for the resulting image: %s\n", p.Build.ResultImageRootPassword)
+	}
+	// the dump tools only take paths to the tls certificates and key, so any given as PEM are written out
+	if err := p.writeTLSFiles(); err != nil {
+		return err
+	}
+	// a read replica that is down or lagging would fail the dump or dump stale data, so they are checked first
+	if err := p.selectReplica(ctx); err != nil {
+		return err
+	}
+	// the data directory, owner and my.cnf differ between images, so they are read from the clean image before
+	// anything is dumped, instead of finding out from an image that won't start
+	info, err := p.InspectImage(ctx, p.Build.CleanImageName)
+	if err != nil {
+		return err
+	}
+	p.cleanImage, err = detectImageLayout(p.Build.DatabaseType, info)
+	if err != nil {
+		return fmt.Errorf("unable to use %s as the clean image: %w", p.Build.CleanImageName, err)
+	}
+	fmt.Fprintf(p.Stdout, "clean image data directory %s owned by %d\n", p.cleanImage.DataDir, p.cleanImage.UID)
+	if p.cleanImage.MyCnf != "" {
+		fmt.Fprintf(p.Stdout, "clean image my.cnf %s\n", p.cleanImage.MyCnf)
+	}
+	fmt.Fprintf(p.Stdout, "database_host=%s\n", p.Build.MTK.Host)
+	fmt.Fprintf(p.Stdout, "backup_image_full=%s:%s\n", p.Build.ResultImageName, p.Build.ResultImageTag)
+	fmt.Fprintf(p.Stdout, "BUILDER_BACKUP_IMAGE_NAME=%s\n", p.Build.ResultImageName)
+	fmt.Fprintf(p.Stdout, "backup_image_tag=%s\n", p.Build.ResultImageTag)
+	fmt.Fprintf(p.Stdout, "tags=%s\n", strings.Join(p.Build.Tags, ","))
+	return nil
+}
+
+// databaseDump dumps the database into the working directory, compressing it as it is written, mysql and mariadb
+// databases are dumped by the task itself and postgres databases are dumped with pg_dump
+func (p *Pipeline) databaseDump(ctx context.Context) error {
+	if err := p.removeStaleDumps(); err != nil {
+		return err
+	}
+	dumpFile := filepath.Join(p.WorkDir, p.Build.Compression.filename())
+	f, err := os.Create(dumpFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	compressed, err := p.Build.Compression.writer(f)
+	if err != nil {
+		return err
+	}
+	w := &countingWriter{Writer: compressed}
+	if p.Build.DatabaseType == "postgres" {
+		err = p.postgresDump(ctx, w)
+	} else {
+		err = p.mysqlDump(ctx, w)
+	}
+	if err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %v", dumpFile, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if filepath.Base(dumpFile) == sanitisedDumpFilename {
+		fmt.Fprintf(p.Stdout, "wrote %s of %s\n", formatSize(w.n), filepath.Base(dumpFile))
+	} else {
+		fmt.Fprintf(p.Stdout, "wrote %s of %s, compressed with %s to %s\n",
+			formatSize(w.n), sanitisedDumpFilename, p.Build.Compression.Codec, formatSize(uint64(info.Size())))
+	}
+	return f.Close()
+}
+
+// mysqlDump dumps the database with the mtk config applied, writing the sanitised dump to the provided writer and the
+// progress of each table to the output
+func (p *Pipeline) mysqlDump(ctx context.Context, w io.Writer) error {
+	config, err := p.Build.mtkConfig()
+	if err != nil {
+		return err
+	}
+	if p.Build.Debug {
+		// the config is printed as it is the result of layering the preset and each of the configs
+		if mtkYAML, err := config.marshal(); err == nil {
+			fmt.Fprintf(p.Stdout, "\n%s\n", mtkYAML)
+		}
+	}
+	// the value has already been validated
+	rows, _ := strconv.Atoi(p.Build.ExtendedInsertRows)
+	db, err := p.OpenDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the database: %w", err)
+	}
+	defer db.Close()
+	dump := mysqlDump{
+		DB:                 db,
+		Config:             config,
+		ExtendedInsertRows: rows,
+		Progress: func(e dumpEvent) {
+			fmt.Fprintln(p.Stdout, e)
+		},
+	}
+	return dump.Dump(ctx, w)
+}
+
+// postgresDump runs pg_dump against the database, applying the mtk config, and writes the sanitised dump to the provided writer
+func (p *Pipeline) postgresDump(ctx context.Context, w io.Writer) error {
+	config, err := p.Build.mtkConfig()
+	if err != nil {
+		return err
+	}
+	dump := postgresDump{
+		Executor: p.Executor,
+		MTK:      p.Build.MTK,
+		Config:   config,
+		Stderr:   p.Stderr,
+	}
+	return dump.Dump(ctx, w)
+}
+
+// imageBuild builds the resulting image from the sanitised dump using the database type specific dockerfile and the
+// configured build backend
+//
+// the source image is the upstream mariadb/mysql image as it has support for importing in a particular way
+// the clean image is the lagoon database image used to copy the imported database into
+// these have to be the same base mariadb/mysql version to work (ie mariadb:10.6 as the builder, and uselagoon/mariadb-10.6-drupal:latest as the clean resulting image)
+func (p *Pipeline) imageBuild(ctx context.Context) error {
+	// template out the my.cnf files and import script for the images
+	if err := p.renderTemplates(); err != nil {
+		return err
+	}
+	dockerfile := fmt.Sprintf("%s.Dockerfile", p.Build.DatabaseType)
+	return p.backend().Build(ctx, BuildRequest{
+		ContextDir:   p.WorkDir,
+		Dockerfile:   dockerfile,
+		ContextFiles: p.contextFiles(dockerfile),
+		BuildArgs:    p.buildArgs(),
+		Tags:         p.Build.images(),
+	})
+}
+
+// contextFiles are the dockerfile and the files that it copies from the working directory, the dump, the import
+// script and the rendered templates
+func (p *Pipeline) contextFiles(dockerfile string) []string {
+	files := []string{dockerfile, p.Build.Compression.filename(), fmt.Sprintf("%s-import.sh", p.Build.DatabaseType)}
+	for _, f := range renderedFiles(p.Build.DatabaseType) {
+		if !slices.Contains(files, f.File) {
+			files = append(files, f.File)
+		}
+	}
+	return files
+}
+
+// buildArgs are the ARGs given to the database type's dockerfile, the images, the credentials of the resulting image
+// and the layout detected from the clean image
+func (p *Pipeline) buildArgs() map[string]string {
+	args := map[string]string{
+		"BUILDER_IMAGE":   p.Build.SourceImageName,
+		"CLEAN_IMAGE":     p.Build.CleanImageName,
+		"RESULT_DATABASE": p.Build.ResultImageDatabaseName,
+		"RESULT_USER":     p.Build.ResultImageUser,
+		"RESULT_PASSWORD": p.Build.ResultImagePassword,
+		"DATA_DIR":        p.cleanImage.DataDir,
+		"DATA_UID":        strconv.Itoa(p.cleanImage.UID),
+	}
+	if p.Build.DatabaseType != "postgres" {
+		args["RESULT_ROOT_PASSWORD"] = p.Build.ResultImageRootPassword
+		args["MYCNF_PATH"] = p.cleanImage.MyCnf
+	}
+	return args
+}
+
+// registryPush pushes the resulting images to the registry using the configured build backend
+func (p *Pipeline) registryPush(ctx context.Context) error {
+	return p.backend().Push(ctx, p.Build.images())
+}
@@ -0,0 +1,453 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/uselagoon/database-image-task/internal/docker"
+	"github.com/uselagoon/database-image-task/internal/docker/dockertest"
+	"github.com/uselagoon/database-image-task/internal/oci"
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+// fakeExecutor records the commands it is asked to run, writing any output and failing any command that contains
+// one of the keys in output or failures
+// the output is written before stdin is read, so that a command can be held open on its stdin after it has written
+// its output, and it is safe to run commands from more than one goroutine
+type fakeExecutor struct {
+	mu       sync.Mutex
+	commands []string
+	env      [][]string
+	stdin    []string
+	failures map[string]int
+	output   map[string]string
+}
+
+func (f *fakeExecutor) Run(ctx context.Context, c Command) error {
+	cmd := strings.TrimSpace(fmt.Sprintf("%s %s", c.Name, strings.Join(c.Args, " ")))
+	f.mu.Lock()
+	f.commands = append(f.commands, cmd)
+	f.env = append(f.env, c.Env)
+	f.mu.Unlock()
+	for key, out := range f.output {
+		if strings.Contains(cmd, key) && c.Stdout != nil {
+			c.Stdout.Write([]byte(out))
+		}
+	}
+	if c.Stdin != nil {
+		b, _ := io.ReadAll(c.Stdin)
+		f.mu.Lock()
+		f.stdin = append(f.stdin, string(b))
+		f.mu.Unlock()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, count := range f.failures {
+		if strings.Contains(cmd, key) && count > 0 {
+			f.failures[key]--
+			return fmt.Errorf("%s failed", key)
+		}
+	}
+	return nil
+}
+
+func repeatCommand(cmd string, count int) []string {
+	cmds := []string{}
+	for i := 0; i < count; i++ {
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// newTestPipeline returns a pipeline with a temporary working directory, that builds and pushes against a fake
+// docker engine
+func newTestPipeline(t *testing.T, exec *fakeExecutor) (*Pipeline, *dockertest.Engine, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	p := NewPipeline(t.TempDir())
+	p.Executor = exec
+	p.Stdout = out
+	p.Stderr = out
+	p.Now = func() time.Time { return time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC) }
+	p.DockerReadiness = docker.Backoff{Attempts: 10, Initial: time.Millisecond}
+	p.InspectImage = func(ctx context.Context, image string) (*oci.ImageInfo, error) {
+		return lagoonImageInfo(p.Build.DatabaseType), nil
+	}
+	p.ProbeReplica = func(ctx context.Context, host string) replicaStatus {
+		return replicaStatus{Host: host}
+	}
+	p.InspectDatabase = func(ctx context.Context) (*databaseInfo, error) {
+		return &databaseInfo{Exists: true, Size: 1 << 20, Privileges: []string{"SELECT", "LOCK TABLES"}}, nil
+	}
+	p.OpenDatabase = (&fakeDatabase{}).open
+	p.LookPath = func(file string) (string, error) {
+		return filepath.Join("/usr/bin", file), nil
+	}
+	engine := dockertest.NewEngine(t)
+	t.Setenv("BUILDER_DOCKER_HOST", engine.URL)
+	return p, engine, out
+}
+
+// lagoonImageInfo returns the config and files of the lagoon clean image for the database type
+func lagoonImageInfo(dbType string) *oci.ImageInfo {
+	switch dbType {
+	case "mysql":
+		return &oci.ImageInfo{
+			Config: v1.Config{User: "mysql", Volumes: map[string]struct{}{"/var/lib/mysql": {}}},
+			Files: map[string][]byte{
+				"/etc/passwd":       []byte("root:x:0:0:root:/root:/bin/bash\nmysql:x:999:999::/var/lib/mysql:/bin/bash\n"),
+				"/etc/mysql/my.cnf": []byte("[mysqld]\n"),
+			},
+		}
+	case "postgres":
+		return &oci.ImageInfo{
+			Config: v1.Config{User: "postgres", Env: []string{"PGDATA=/var/lib/postgresql/data"}},
+			Files: map[string][]byte{
+				"/etc/passwd": []byte("root:x:0:0:root:/root:/bin/sh\npostgres:x:70:70::/var/lib/postgresql:/bin/sh\n"),
+			},
+		}
+	}
+	return &oci.ImageInfo{
+		Config: v1.Config{User: "mysql", Volumes: map[string]struct{}{"/var/lib/mysql": {}}},
+		Files: map[string][]byte{
+			"/etc/passwd":       []byte("root:x:0:0:root:/root:/bin/sh\nmysql:x:100:101::/var/lib/mysql:/sbin/nologin\n"),
+			"/etc/mysql/my.cnf": []byte("[mysqld]\n"),
+		},
+	}
+}
+
+func Test_Pipeline_Run(t *testing.T) {
+	type args struct {
+		envVars      []variables.LagoonEnvironmentVariable
+		setVars      []EnvironmentVariable
+		failures     map[string]int
+		output       map[string]string
+		database     *fakeDatabase
+		pingFailures int
+		engineErrors map[string]string
+	}
+	tests := []struct {
+		name        string
+		description string
+		args        args
+		wantErr     string
+		want        []string
+		wantDocker  []string
+		wantDump    string
+		wantOutput  string
+	}{
+		{
+			name:        "test1",
+			description: "check all the commands for a mariadb build are run in order",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_HOST", Value: "reghost", Scope: "global"},
+					{Name: "BUILDER_BACKUP_IMAGE_NAME", Value: "${registry}/${project}/${service}-data", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_PASSWORD", Value: "dbpass", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+					{Name: "BUILDER_MTK_YAML_BASE64", Value: base64.StdEncoding.EncodeToString([]byte("nodata:\n  - cache*\n")), Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+				},
+				database: fakeMySQLDatabase(),
+			},
+			want: nil,
+			wantDocker: []string{
+				"GET /info",
+				"GET /_ping",
+				`POST /build buildargs={"BUILDER_IMAGE":"mariadb:10.6","CLEAN_IMAGE":"uselagoon/mariadb-10.6-drupal:latest","DATA_DIR":"/var/lib/mysql","DATA_UID":"100","MYCNF_PATH":"/etc/mysql/my.cnf","RESULT_DATABASE":"drupal","RESULT_PASSWORD":"drupal","RESULT_ROOT_PASSWORD":"Lag00n","RESULT_USER":"drupal"} dockerfile=mariadb.Dockerfile networkmode=host t=reghost/lagpro/mariadb-data:latest t=reghost/lagpro/mariadb-data:backup-2026-10-18`,
+				"POST /auth",
+				"POST /images/reghost/lagpro/mariadb-data/push tag=latest",
+				"DELETE /images/reghost/lagpro/mariadb-data:latest force=1",
+				"POST /images/reghost/lagpro/mariadb-data/push tag=backup-2026-10-18",
+				"DELETE /images/reghost/lagpro/mariadb-data:backup-2026-10-18 force=1",
+			},
+			wantDump:   "DROP TABLE IF EXISTS `cache_data`;\nCREATE TABLE `cache_data` (\n  `cid` varchar(255) NOT NULL\n);\n\n--\n-- Table structure for `watchdog`",
+			wantOutput: "users: 3 rows, 1 triggers\nnode: 1 rows\ncache_data: structure only\nwatchdog: 0 rows\nactive_users: view\nrecent_active_users: view\nrebuild_cache: procedure\nuser_count: function not dumped, the user isn't allowed to read it\npurge_sessions: event\nwrote 2.7 kB of sanitised-dump.sql, compressed with gzip to",
+		},
+		{
+			name:        "test2",
+			description: "check a mysql build pushing only the default tag, waiting for the docker host and skipping image removal",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_BACKUP_IMAGE_TYPE", Value: "mysql", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_BACKUP_IMAGE_TAG", Value: "${environment}", Scope: "global"},
+					{Name: "BUILDER_PUSH_TAGS", Value: "default", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+					{Name: "BUILDER_REMOVE_IMAGE", Value: "skip"},
+				},
+				pingFailures: 2,
+			},
+			want: nil,
+			wantDocker: []string{
+				"GET /info",
+				"GET /_ping",
+				"GET /_ping",
+				"GET /_ping",
+				`POST /build buildargs={"BUILDER_IMAGE":"mysql:8.0.41-oracle","CLEAN_IMAGE":"uselagoon/mysql-8.0:latest","DATA_DIR":"/var/lib/mysql","DATA_UID":"999","MYCNF_PATH":"/etc/mysql/my.cnf","RESULT_DATABASE":"lagoon","RESULT_PASSWORD":"lagoon","RESULT_ROOT_PASSWORD":"Lag00n","RESULT_USER":"lagoon"} dockerfile=mysql.Dockerfile networkmode=host t=lagpro/lagenv:lagenv`,
+				"POST /auth",
+				"POST /images/lagpro/lagenv/push tag=lagenv",
+			},
+		},
+		{
+			name:        "test3",
+			description: "check that the build stops before the dump if the registry password is missing",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+				},
+			},
+			wantErr: "registryPassword (BUILDER_REGISTRY_PASSWORD): a value is required",
+			want:    nil,
+		},
+		{
+			name:        "test4",
+			description: "check that a failing dump stops the build",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+				},
+				database: &fakeDatabase{
+					failures: map[string]string{"SHOW FULL TABLES": "Error 1044 (42000): Access denied for user 'dbuser'@'%' to database 'dbname'"},
+				},
+			},
+			wantErr: "unable to list the tables: Error 1044 (42000): Access denied",
+			want:    nil,
+			wantDocker: []string{
+				"GET /info",
+			},
+		},
+		{
+			name:        "test5",
+			description: "check that the build fails if the docker host never becomes available",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+				},
+				pingFailures: 10,
+			},
+			wantErr:    "after 10 attempts",
+			want:       nil,
+			wantDocker: append([]string{"GET /info"}, repeatCommand("GET /_ping", 10)...),
+		},
+		{
+			name:        "test6",
+			description: "check that an error in the build output stops the build before anything is pushed",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+				},
+				engineErrors: map[string]string{
+					"build": "failed to copy sanitised-dump.sql",
+				},
+			},
+			wantErr: "docker build failed: failed to copy sanitised-dump.sql",
+			want:    nil,
+			wantDocker: []string{
+				"GET /info",
+				"GET /_ping",
+				`POST /build buildargs={"BUILDER_IMAGE":"mariadb:10.6","CLEAN_IMAGE":"uselagoon/mariadb-10.6-drupal:latest","DATA_DIR":"/var/lib/mysql","DATA_UID":"100","MYCNF_PATH":"/etc/mysql/my.cnf","RESULT_DATABASE":"drupal","RESULT_PASSWORD":"drupal","RESULT_ROOT_PASSWORD":"Lag00n","RESULT_USER":"drupal"} dockerfile=mariadb.Dockerfile networkmode=host t=lagpro/lagenv:latest t=lagpro/lagenv:backup-2026-10-18`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envvars, _ := json.Marshal(tt.args.envVars)
+			t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+			for _, envVar := range tt.args.setVars {
+				t.Setenv(envVar.Name, envVar.Value)
+			}
+			exec := &fakeExecutor{failures: tt.args.failures, output: tt.args.output}
+			p, engine, out := newTestPipeline(t, exec)
+			if tt.args.database != nil {
+				p.OpenDatabase = tt.args.database.open
+			}
+			engine.PingFailures = tt.args.pingFailures
+			for operation, msg := range tt.args.engineErrors {
+				engine.Errors[operation] = msg
+			}
+			err := p.Run(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			if !reflect.DeepEqual(exec.commands, tt.want) {
+				t.Errorf("Run() commands = \n%v\nwant\n%v", strings.Join(exec.commands, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if !reflect.DeepEqual(engine.Requests(), tt.wantDocker) {
+				t.Errorf("Run() docker requests = \n%v\nwant\n%v", strings.Join(engine.Requests(), "\n"), strings.Join(tt.wantDocker, "\n"))
+			}
+			if tt.wantDump != "" {
+				b, _ := readDump(filepath.Join(p.WorkDir, p.Build.Compression.filename()))
+				if !strings.Contains(string(b), tt.wantDump) {
+					t.Errorf("Run() dump = %v, want %v", string(b), tt.wantDump)
+				}
+			}
+			if !strings.Contains(out.String(), tt.wantOutput) {
+				t.Errorf("Run() output = %v, want %v", out.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+func Test_Pipeline_variableSetup_randomRootPassword(t *testing.T) {
+	envvars, _ := json.Marshal([]variables.LagoonEnvironmentVariable{
+		{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+		{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+		{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+		{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+		{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+		{Name: "BUILDER_RESULT_IMAGE_ROOT_PASSWORD", Value: "Lag00n", Scope: "global"},
+		{Name: "BUILDER_RESULT_IMAGE_RANDOM_ROOT_PASSWORD", Value: "true", Scope: "global"},
+	})
+	t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+	t.Setenv("LAGOON_PROJECT", "lagpro")
+	t.Setenv("LAGOON_ENVIRONMENT", "lagenv")
+	p, _, out := newTestPipeline(t, &fakeExecutor{})
+	if err := p.variableSetup(context.Background()); err != nil {
+		t.Fatalf("variableSetup() error = %v", err)
+	}
+	password := p.Build.ResultImageRootPassword
+	if password == "" || password == "Lag00n" {
+		t.Fatalf("variableSetup() root password = %q, want a generated password", password)
+	}
+	if !strings.Contains(out.String(), "generated root password for the resulting image: "+password+"\n") {
+		t.Errorf("variableSetup() output = %v, want the generated password", out.String())
+	}
+	if got := p.buildArgs()["RESULT_ROOT_PASSWORD"]; got != password {
+		t.Errorf("buildArgs() RESULT_ROOT_PASSWORD = %v, want %v", got, password)
+	}
+}
+
+func Test_Pipeline_registryPush(t *testing.T) {
+	tests := []struct {
+		name         string
+		pushTags     string
+		engineErrors map[string]string
+		want         []string
+		wantErr      string
+	}{
+		{
+			name:     "test1",
+			pushTags: "latest",
+			want: []string{
+				"POST /auth",
+				"POST /images/quay.io/org/image/push tag=latest",
+				"DELETE /images/quay.io/org/image:latest force=1",
+			},
+		},
+		{
+			name:     "test2",
+			pushTags: "none",
+			want: []string{
+				"POST /auth",
+			},
+		},
+		{
+			name:         "test3",
+			pushTags:     "both",
+			engineErrors: map[string]string{"remove": "No such image", "push": "denied: requested access to the resource is denied"},
+			want: []string{
+				"POST /auth",
+				"POST /images/quay.io/org/image/push tag=latest",
+			},
+			wantErr: "docker push of quay.io/org/image:latest failed: denied: requested access to the resource is denied",
+		},
+		{
+			name:         "test4",
+			pushTags:     "latest",
+			engineErrors: map[string]string{"auth": "incorrect username or password"},
+			want: []string{
+				"POST /auth",
+			},
+			wantErr: "docker login failed: docker engine returned 401 Unauthorized: incorrect username or password",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &fakeExecutor{}
+			p, engine, _ := newTestPipeline(t, exec)
+			for operation, msg := range tt.engineErrors {
+				engine.Errors[operation] = msg
+			}
+			p.Build = Builder{
+				ResultImageName:  "quay.io/org/image",
+				RegistryHost:     "quay.io",
+				RegistryUsername: "user",
+				RegistryPassword: "pass",
+				DockerHost:       engine.URL,
+				PushTags:         tt.pushTags,
+				ResultImageTag:   "backup",
+			}
+			p.Build.Tags = p.Build.pushTags()
+			err := p.registryPush(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("registryPush() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("registryPush() error = %v", err)
+			}
+			if !reflect.DeepEqual(engine.Requests(), tt.want) {
+				t.Errorf("registryPush() requests = %v, want %v", engine.Requests(), tt.want)
+			}
+			wantAuth := map[string]string{"username": "user", "password": "pass", "serveraddress": "quay.io"}
+			for _, auth := range engine.Auths() {
+				if !reflect.DeepEqual(auth, wantAuth) {
+					t.Errorf("registryPush() auth = %v, want %v", auth, wantAuth)
+				}
+			}
+		})
+	}
+}
@@ -1,12 +1,11 @@
 package builder
 
 import (
-	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
-
-	"github.com/uselagoon/machinery/utils/variables"
+	"time"
 )
 
 func fixServiceName(str string) string {
@@ -15,160 +14,380 @@ func fixServiceName(str string) string {
 }
 
 type Builder struct {
-	DockerComposeServiceName      string `json:"serviceName"`
-	FixedDockerComposeServiceName string `json:"fixedServiceName"`
-	SourceImageName               string `json:"sourceImage"`
-	CleanImageName                string `json:"cleanImage"`
-	ResultImageName               string `json:"resultImageName"`
-	ResultImageTag                string `json:"resultImageTag"`
-	ResultImageDatabaseName       string `json:"resultImageDatabaseName"`
-	RegistryUsername              string `json:"registryUsername"`
-	RegistryPassword              string `json:"registryPassword"`
-	RegistryHost                  string `json:"registryHost"`
-	RegistryOrganization          string `json:"registryOrganization"`
-	DockerHost                    string `json:"dockerHost"`
-	PushTags                      string `json:"pushTags"`
-	MTKYAML                       string `json:"mtkYAML"`
-	ExtendedInsertRows            string `json:"extendedInsertRows,omitempty"`
-	DatabaseType                  string `json:"databaseType"`
-	Debug                         bool   `json:"debug,omitempty"`
-	MTK                           MTK    `json:"mtk"`
+	DockerComposeServiceName      string      `json:"serviceName"`
+	FixedDockerComposeServiceName string      `json:"fixedServiceName"`
+	SourceImageName               string      `json:"sourceImage"`
+	CleanImageName                string      `json:"cleanImage"`
+	ResultImageName               string      `json:"resultImageName"`
+	ResultImageTag                string      `json:"resultImageTag"`
+	ExtraTags                     []string    `json:"extraTags,omitempty"`
+	Tags                          []string    `json:"tags"`
+	ResultImageDatabaseName       string      `json:"resultImageDatabaseName"`
+	ResultImageRootPassword       string      `json:"resultImageRootPassword" secret:"true"`
+	ResultImageRandomRootPassword bool        `json:"resultImageRandomRootPassword,omitempty"`
+	ResultImageUser               string      `json:"resultImageUser"`
+	ResultImagePassword           string      `json:"resultImagePassword" secret:"true"`
+	RegistryUsername              string      `json:"registryUsername"`
+	RegistryPassword              string      `json:"registryPassword" secret:"true"`
+	RegistryHost                  string      `json:"registryHost"`
+	RegistryOrganization          string      `json:"registryOrganization"`
+	RegistryType                  string      `json:"registryType"`
+	DockerHost                    string      `json:"dockerHost"`
+	DockerReady                   DockerReady `json:"dockerReady"`
+	PushTags                      string      `json:"pushTags"`
+	BuildBackend                  string      `json:"buildBackend"`
+	DataDir                       string      `json:"dataDir"`
+	ContextOutputDir              string      `json:"contextOutputDir"`
+	MTKYAML                       string      `json:"mtkYAML"`
+	MTKYAMLExtra                  []string    `json:"mtkYAMLExtra,omitempty"`
+	MTKPreset                     string      `json:"mtkPreset,omitempty"`
+	ExtendedInsertRows            string      `json:"extendedInsertRows,omitempty"`
+	DatabaseType                  string      `json:"databaseType"`
+	Debug                         bool        `json:"debug,omitempty"`
+	MTK                           MTK         `json:"mtk"`
+	Import                        Import      `json:"import"`
+	MyCnf                         MyCnf       `json:"myCnf"`
+	Retention                     Retention   `json:"retention"`
+	Replicas                      Replicas    `json:"replicas"`
+	Compression                   Compression `json:"compression"`
+
+	// debugValue is the raw value of BUILDER_IMAGE_DEBUG, kept so that it can be validated
+	debugValue string
+	// randomRootPasswordValue is the raw value of BUILDER_RESULT_IMAGE_RANDOM_ROOT_PASSWORD, kept so that it can be validated
+	randomRootPasswordValue string
 }
 
 type MTK struct {
 	Host     string `json:"host"`
 	Database string `json:"database"`
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Password string `json:"password" secret:"true"`
+	// Port is left blank to use the default port of the database type
+	Port string `json:"port,omitempty"`
+	// Socket is the path to a unix socket to connect to instead of the host, for postgres it is the socket directory
+	Socket string `json:"socket,omitempty"`
+	// TLSMode is one of the supportedTLSModes, the tls certificates and key are either paths or PEM
+	TLSMode string `json:"tlsMode,omitempty"`
+	TLSCA   string `json:"tlsCA,omitempty"`
+	TLSCert string `json:"tlsCert,omitempty"`
+	TLSKey  string `json:"tlsKey,omitempty" secret:"true"`
+}
+
+// DockerReady controls how long the build waits for the docker host to become available, the wait starts at Delay
+// seconds and doubles after each attempt, up to MaxDelay seconds
+type DockerReady struct {
+	Attempts string `json:"attempts"`
+	Delay    string `json:"delay"`
+	MaxDelay string `json:"maxDelay"`
+}
+
+// Import tunes mysqld while the dump is imported into the builder image, the sizes are mysqld sizes eg 512M or 2G
+type Import struct {
+	BufferPoolSize   string `json:"bufferPoolSize"`
+	MaxAllowedPacket string `json:"maxAllowedPacket"`
+	SortBufferSize   string `json:"sortBufferSize"`
+	IOThreads        string `json:"ioThreads"`
+}
+
+// MyCnf are the settings written to the my.cnf of the resulting image
+type MyCnf struct {
+	MaxAllowedPacket string `json:"maxAllowedPacket"`
+	// BufferPoolSize is left out of the my.cnf if it isn't set, so the clean image default is used
+	BufferPoolSize string `json:"bufferPoolSize,omitempty"`
 }
 
-func generateBuildValues(vars []variables.LagoonEnvironmentVariable) Builder {
-	debugStr := checkVariable("BUILDER_IMAGE_DEBUG", variables.GetEnv("BUILDER_IMAGE_DEBUG", ""), vars)
-	dbType := checkVariable("BUILDER_BACKUP_IMAGE_TYPE", variables.GetEnv("BUILDER_BACKUP_IMAGE_TYPE", "mariadb"), vars)
+func generateBuildValues(r *resolver) Builder {
+	debugStr := r.variable("debug", "BUILDER_IMAGE_DEBUG", "")
+	dbType := r.variable("databaseType", "BUILDER_BACKUP_IMAGE_TYPE", "mariadb")
 	debug, _ := strconv.ParseBool(debugStr)
+	defaultServiceName := "mariadb"
+	if dbType == "postgres" {
+		defaultServiceName = "postgres"
+	}
 	build := Builder{
-		DockerComposeServiceName:      checkVariable("BUILDER_DOCKER_COMPOSE_SERVICE_NAME", variables.GetEnv("BUILDER_DOCKER_COMPOSE_SERVICE_NAME", "mariadb"), vars),
-		FixedDockerComposeServiceName: fixServiceName(checkVariable("BUILDER_DOCKER_COMPOSE_SERVICE_NAME", variables.GetEnv("BUILDER_DOCKER_COMPOSE_SERVICE_NAME", "mariadb"), vars)),
-		ResultImageName:               checkVariable("BUILDER_BACKUP_IMAGE_NAME", variables.GetEnv("BUILDER_BACKUP_IMAGE_NAME", "${project}/${environment}"), vars),
-		ResultImageTag:                checkVariable("BUILDER_BACKUP_IMAGE_TAG", variables.GetEnv("BUILDER_BACKUP_IMAGE_TAG", ""), vars),
-		RegistryUsername:              checkVariable("BUILDER_REGISTRY_USERNAME", variables.GetEnv("BUILDER_REGISTRY_USERNAME", ""), vars),
-		RegistryPassword:              checkVariable("BUILDER_REGISTRY_PASSWORD", variables.GetEnv("BUILDER_REGISTRY_PASSWORD", ""), vars),
-		RegistryHost:                  checkVariable("BUILDER_REGISTRY_HOST", variables.GetEnv("BUILDER_REGISTRY_HOST", ""), vars),
-		RegistryOrganization:          checkVariable("BUILDER_REGISTRY_ORGANIZATION", variables.GetEnv("BUILDER_REGISTRY_ORGANIZATION", ""), vars),
-		DockerHost:                    checkVariable("BUILDER_DOCKER_HOST", variables.GetEnv("BUILDER_DOCKER_HOST", "docker-host.lagoon-image-builder.svc"), vars),
-		PushTags:                      checkVariable("BUILDER_PUSH_TAGS", variables.GetEnv("BUILDER_PUSH_TAGS", "both"), vars),
-		MTKYAML:                       checkVariable("BUILDER_MTK_YAML_BASE64", variables.GetEnv("BUILDER_MTK_YAML_BASE64", ""), vars),
-		ExtendedInsertRows:            checkVariable("BUILDER_MTK_EXTENDED_INSERT_ROWS", variables.GetEnv("BUILDER_MTK_EXTENDED_INSERT_ROWS", ""), vars),
-		DatabaseType:                  dbType,
-		Debug:                         debug,
+		DockerComposeServiceName: r.variable("serviceName", "BUILDER_DOCKER_COMPOSE_SERVICE_NAME", defaultServiceName),
+		ResultImageName:          r.variable("resultImageName", "BUILDER_BACKUP_IMAGE_NAME", "${project}/${environment}"),
+		ResultImageTag:           r.variable("resultImageTag", "BUILDER_BACKUP_IMAGE_TAG", defaultImageTag),
+		ExtraTags:                splitTags(r.variable("extraTags", "BUILDER_BACKUP_IMAGE_EXTRA_TAGS", "")),
+		RegistryUsername:         r.variable("registryUsername", "BUILDER_REGISTRY_USERNAME", ""),
+		RegistryPassword:         r.variable("registryPassword", "BUILDER_REGISTRY_PASSWORD", ""),
+		RegistryHost:             r.variable("registryHost", "BUILDER_REGISTRY_HOST", ""),
+		RegistryOrganization:     r.variable("registryOrganization", "BUILDER_REGISTRY_ORGANIZATION", ""),
+		RegistryType:             r.variable("registryType", "BUILDER_REGISTRY_TYPE", "auto"),
+		DockerHost:               r.variable("dockerHost", "BUILDER_DOCKER_HOST", "docker-host.lagoon-image-builder.svc"),
+		PushTags:                 r.variable("pushTags", "BUILDER_PUSH_TAGS", "both"),
+		BuildBackend:             r.variable("buildBackend", "BUILDER_BUILD_BACKEND", "docker"),
+		DataDir:                  r.variable("dataDir", "BUILDER_DATA_DIR", "/initialized-db"),
+		ContextOutputDir:         r.variable("contextOutputDir", "BUILDER_CONTEXT_OUTPUT_DIR", "/workspace"),
+		MTKYAML:                  r.variable("mtkYAML", "BUILDER_MTK_YAML_BASE64", ""),
+		MTKYAMLExtra:             r.layered("mtkYAMLExtra", "BUILDER_MTK_YAML_EXTRA_BASE64"),
+		MTKPreset:                r.variable("mtkPreset", "BUILDER_MTK_PRESET", ""),
+		ExtendedInsertRows:       r.variable("extendedInsertRows", "BUILDER_MTK_EXTENDED_INSERT_ROWS", ""),
+		DatabaseType:             dbType,
+		Debug:                    debug,
+		debugValue:               debugStr,
+		Import: Import{
+			BufferPoolSize:   r.variable("import.bufferPoolSize", "BUILDER_IMPORT_BUFFER_POOL_SIZE", "2G"),
+			MaxAllowedPacket: r.variable("import.maxAllowedPacket", "BUILDER_IMPORT_MAX_ALLOWED_PACKET", "1G"),
+			SortBufferSize:   r.variable("import.sortBufferSize", "BUILDER_IMPORT_SORT_BUFFER_SIZE", "128M"),
+			IOThreads:        r.variable("import.ioThreads", "BUILDER_IMPORT_IO_THREADS", "4"),
+		},
+		MyCnf: MyCnf{
+			MaxAllowedPacket: r.variable("myCnf.maxAllowedPacket", "BUILDER_MYCNF_MAX_ALLOWED_PACKET", "1G"),
+			BufferPoolSize:   r.variable("myCnf.bufferPoolSize", "BUILDER_MYCNF_BUFFER_POOL_SIZE", ""),
+		},
+		DockerReady: DockerReady{
+			Attempts: r.variable("dockerReady.attempts", "BUILDER_DOCKER_READY_ATTEMPTS", "10"),
+			Delay:    r.variable("dockerReady.delay", "BUILDER_DOCKER_READY_DELAY", "5"),
+			MaxDelay: r.variable("dockerReady.maxDelay", "BUILDER_DOCKER_READY_MAX_DELAY", "5"),
+		},
+		Compression: Compression{
+			Codec: r.variable("compression.codec", "BUILDER_DUMP_COMPRESSION", "gzip"),
+			Level: r.variable("compression.level", "BUILDER_DUMP_COMPRESSION_LEVEL", ""),
+		},
+		Retention: Retention{
+			KeepLast:    r.variable("retention.keepLast", "BUILDER_RETENTION_KEEP_LAST", ""),
+			KeepDaily:   r.variable("retention.keepDaily", "BUILDER_RETENTION_KEEP_DAILY", ""),
+			KeepWeekly:  r.variable("retention.keepWeekly", "BUILDER_RETENTION_KEEP_WEEKLY", ""),
+			KeepMonthly: r.variable("retention.keepMonthly", "BUILDER_RETENTION_KEEP_MONTHLY", ""),
+			MaxAge:      r.variable("retention.maxAge", "BUILDER_RETENTION_MAX_AGE", ""),
+			TagPattern:  r.variable("retention.tagPattern", "BUILDER_RETENTION_TAG_PATTERN", defaultRetentionTagPattern),
+			dryRunValue: r.variable("retention.dryRun", "BUILDER_RETENTION_DRY_RUN", ""),
+		},
+	}
+	build.Replicas = Replicas{
+		Strategy: r.variable("replicas.strategy", "BUILDER_READREPLICA_STRATEGY", "first-healthy"),
+		MaxLag:   r.variable("replicas.maxLag", "BUILDER_READREPLICA_MAX_LAG", ""),
 	}
+	build.Retention.DryRun, _ = strconv.ParseBool(build.Retention.dryRunValue)
+	// an empty tag is treated as not being set, rather than pushing the image without a tag
+	if strings.TrimSpace(build.ResultImageTag) == "" {
+		r.record("resultImageTag", Source{Layer: layerDefault, Detail: "BUILDER_BACKUP_IMAGE_TAG is empty"})
+		build.ResultImageTag = defaultImageTag
+	}
+	build.FixedDockerComposeServiceName = fixServiceName(build.DockerComposeServiceName)
+	r.record("fixedServiceName", Source{Layer: layerDerived, Detail: "upper cased serviceName with hyphens replaced"})
+	// the lagoon mysql images use lagoon as the default user, the others use drupal
+	defaultUser := "drupal"
 	switch dbType {
 	case "mariadb":
-		build.SourceImageName = checkVariable("BUILDER_IMAGE_NAME", variables.GetEnv("BUILDER_IMAGE_NAME", "mariadb:10.6"), vars)
-		build.CleanImageName = checkVariable("BUILDER_CLEAN_IMAGE_NAME", variables.GetEnv("BUILDER_CLEAN_IMAGE_NAME", "uselagoon/mariadb-10.6-drupal:latest"), vars)
-		build.ResultImageDatabaseName = checkVariable("BUILDER_BACKUP_IMAGE_DATABASE_NAME", variables.GetEnv("BUILDER_BACKUP_IMAGE_DATABASE_NAME", "drupal"), vars)
+		build.SourceImageName = r.variable("sourceImage", "BUILDER_IMAGE_NAME", "mariadb:10.6")
+		build.CleanImageName = r.variable("cleanImage", "BUILDER_CLEAN_IMAGE_NAME", "uselagoon/mariadb-10.6-drupal:latest")
+		build.ResultImageDatabaseName = r.variable("resultImageDatabaseName", "BUILDER_BACKUP_IMAGE_DATABASE_NAME", "drupal")
 	case "mysql":
-		build.SourceImageName = checkVariable("BUILDER_IMAGE_NAME", variables.GetEnv("BUILDER_IMAGE_NAME", "mysql:8.0.41-oracle"), vars)
-		build.CleanImageName = checkVariable("BUILDER_CLEAN_IMAGE_NAME", variables.GetEnv("BUILDER_CLEAN_IMAGE_NAME", "uselagoon/mysql-8.0:latest"), vars)
-		build.ResultImageDatabaseName = checkVariable("BUILDER_BACKUP_IMAGE_DATABASE_NAME", variables.GetEnv("BUILDER_BACKUP_IMAGE_DATABASE_NAME", "lagoon"), vars)
+		build.SourceImageName = r.variable("sourceImage", "BUILDER_IMAGE_NAME", "mysql:8.0.41-oracle")
+		build.CleanImageName = r.variable("cleanImage", "BUILDER_CLEAN_IMAGE_NAME", "uselagoon/mysql-8.0:latest")
+		build.ResultImageDatabaseName = r.variable("resultImageDatabaseName", "BUILDER_BACKUP_IMAGE_DATABASE_NAME", "lagoon")
+		defaultUser = "lagoon"
+	case "postgres":
+		build.SourceImageName = r.variable("sourceImage", "BUILDER_IMAGE_NAME", "postgres:14-alpine")
+		build.CleanImageName = r.variable("cleanImage", "BUILDER_CLEAN_IMAGE_NAME", "uselagoon/postgres-14-drupal:latest")
+		build.ResultImageDatabaseName = r.variable("resultImageDatabaseName", "BUILDER_BACKUP_IMAGE_DATABASE_NAME", "drupal")
+	}
+	build.ResultImageUser = r.variable("resultImageUser", "BUILDER_RESULT_IMAGE_USER", defaultUser)
+	build.ResultImagePassword = r.variable("resultImagePassword", "BUILDER_RESULT_IMAGE_PASSWORD", defaultUser)
+	build.randomRootPasswordValue = r.variable("resultImageRandomRootPassword", "BUILDER_RESULT_IMAGE_RANDOM_ROOT_PASSWORD", "")
+	build.ResultImageRandomRootPassword, _ = strconv.ParseBool(build.randomRootPasswordValue)
+	if build.ResultImageRandomRootPassword {
+		// the password is only generated when the build runs, so that it is only ever seen in the task output
+		r.record("resultImageRootPassword", Source{Layer: layerDerived, Detail: "generated when the build runs"})
+	} else {
+		build.ResultImageRootPassword = r.variable("resultImageRootPassword", "BUILDER_RESULT_IMAGE_ROOT_PASSWORD", "Lag00n")
 	}
+	pairImages(&build, r)
 	return build
 }
 
-// Run will generateValues then output the resulting payload as JSON for the builder script to use
-func Run() error {
-	vals, err := generateValues()
+// DumpOptions changes how the values are output by Run
+type DumpOptions struct {
+	// Redact replaces any secret values with a placeholder
+	Redact bool
+	// Format is one of the Formats, json is used if it isn't set
+	Format string
+}
+
+// Run will generateValues then output the resulting payload in the requested format for other tools to use
+func Run(opts DumpOptions) error {
+	vals, err := generateValues(time.Now())
 	if err != nil {
 		return err
 	}
-	b, err := json.Marshal(vals)
-	if err != nil {
+	// check the mtk config here, otherwise the problems aren't found until the build dumps the database
+	if _, err := vals.mtkConfig(); err != nil {
 		return err
 	}
-	fmt.Println(string(b))
-	return nil
+	if opts.Redact {
+		vals = vals.Redacted()
+	}
+	return writeValues(os.Stdout, vals, opts.Format)
 }
 
 // generateValues will get the build values, and then generate the values for MTK
 // it also handles scanning for readreplicas if available and parsing the image pattern
-func generateValues() (Builder, error) {
-	vars := readVariables()
-	build := generateBuildValues(vars)
+func generateValues(now time.Time) (Builder, error) {
+	build, _, err := resolveValues(now)
+	return build, err
+}
+
+// resolveValues is generateValues, but it also returns the source of each of the values keyed by the json path of the field
+func resolveValues(now time.Time) (Builder, map[string]Source, error) {
+	r := newResolver(readVariableLayers())
+	build := generateBuildValues(r)
 	mtk := MTK{}
 	var err error
-	mtk.Host, err = calculateMTKVariable("HOSTNAME", build, vars)
+	mtk.Host, err = calculateMTKVariable("HOSTNAME", build, r)
 	if err != nil {
-		return build, err
+		return build, r.sources, err
 	}
-	mtk.Username, err = calculateMTKVariable("USERNAME", build, vars)
+	mtk.Username, err = calculateMTKVariable("USERNAME", build, r)
 	if err != nil {
-		return build, err
+		return build, r.sources, err
 	}
-	mtk.Password, err = calculateMTKVariable("PASSWORD", build, vars)
+	mtk.Password, err = calculateMTKVariable("PASSWORD", build, r)
 	if err != nil {
-		return build, err
+		return build, r.sources, err
 	}
-	mtk.Database, err = calculateMTKVariable("DATABASE", build, vars)
+	mtk.Database, err = calculateMTKVariable("DATABASE", build, r)
 	if err != nil {
-		return build, err
+		return build, r.sources, err
 	}
-	// use a readreplica if one exists
-	readReplicas := variables.GetEnv(fmt.Sprintf("%s_READREPLICA_HOSTS", build.FixedDockerComposeServiceName), mtk.Host)
-	rr := strings.Split(readReplicas, ",")
-	if rr != nil {
-		mtk.Host = rr[0]
+	// the rest of the connection settings are optional
+	for _, name := range mtkConnectionNames {
+		*mtk.connectionField(name), err = calculateMTKVariable(name, build, r)
+		if err != nil {
+			return build, r.sources, err
+		}
+	}
+	// the port can also be given with the host, but a port that is set on its own is used over it
+	host, port := splitHostPort(mtk.Host)
+	if port != "" {
+		mtk.Host = host
+		if mtk.Port == "" {
+			mtk.Port = port
+			r.record("mtk.port", Source{Layer: layerDerived, Detail: "from mtk.host"})
+		}
+	}
+	build.Replicas.port = mtk.Port
+	// use a readreplica if one exists, the first one is used until the build probes them and chooses one with the strategy
+	readReplicasVar := fmt.Sprintf("%s_READREPLICA_HOSTS", build.FixedDockerComposeServiceName)
+	if readReplicas, ok := os.LookupEnv(readReplicasVar); ok {
+		build.Replicas.Hosts = splitTags(readReplicas)
+		r.record("replicas.hosts", Source{Layer: layerProcess, Variable: readReplicasVar})
+	}
+	if len(build.Replicas.Hosts) > 0 {
+		build.Replicas.Primary = mtk.Host
+		r.record("replicas.primary", r.sources["mtk.host"])
+		mtk.Host, mtk.Port = build.Replicas.address(build.Replicas.Hosts[0])
+		r.record("mtk.host", Source{Layer: layerProcess, Variable: readReplicasVar, Detail: "first read replica"})
 	}
 	build.MTK = mtk
-	build.ResultImageName = imagePatternParser(build.ResultImageName, build)
-	build.ResultImageTag = imagePatternParser(build.ResultImageTag, build)
-	return build, nil
+	name, err := imagePatternParser(build.ResultImageName, build, now)
+	if err != nil {
+		return build, r.sources, fmt.Errorf("BUILDER_BACKUP_IMAGE_NAME: %w", err)
+	}
+	if name != build.ResultImageName {
+		source := r.sources["resultImageName"]
+		source.Detail = fmt.Sprintf("parsed from pattern %s", build.ResultImageName)
+		r.record("resultImageName", source)
+		build.ResultImageName = name
+	}
+	tag, err := imagePatternParser(build.ResultImageTag, build, now)
+	if err != nil {
+		return build, r.sources, fmt.Errorf("BUILDER_BACKUP_IMAGE_TAG: %w", err)
+	}
+	if tag != build.ResultImageTag {
+		source := r.sources["resultImageTag"]
+		source.Detail = fmt.Sprintf("parsed from pattern %s", build.ResultImageTag)
+		r.record("resultImageTag", source)
+		build.ResultImageTag = tag
+	}
+	// the final name and tag are normalised so that a project or branch name with uppercase letters or other characters
+	// that aren't allowed in a reference still gives a name that can be pushed
+	if name := normaliseRepository(build.ResultImageName, build.RegistryHost); name != build.ResultImageName {
+		r.record("resultImageName", normalisedSource(r.sources["resultImageName"], build.ResultImageName))
+		build.ResultImageName = name
+	}
+	if tag := normaliseTag(build.ResultImageTag); tag != build.ResultImageTag {
+		r.record("resultImageTag", normalisedSource(r.sources["resultImageTag"], build.ResultImageTag))
+		build.ResultImageTag = tag
+	}
+	build.ExtraTags, err = expandExtraTags(build.ExtraTags, build, now)
+	if err != nil {
+		return build, r.sources, fmt.Errorf("BUILDER_BACKUP_IMAGE_EXTRA_TAGS: %w", err)
+	}
+	build.Tags = build.pushTags()
+	r.record("tags", Source{Layer: layerDerived, Detail: "resultImageTag and extraTags for pushTags"})
+	return build, r.sources, nil
+}
+
+// normalisedSource adds the value before it was normalised to the detail of its source
+func normalisedSource(source Source, value string) Source {
+	if source.Detail != "" {
+		source.Detail = fmt.Sprintf("%s, normalised from %s", source.Detail, value)
+	} else {
+		source.Detail = fmt.Sprintf("normalised from %s", value)
+	}
+	return source
 }
 
 // calculateMTKVariable takes the build vars and environment variables and scans for the necessary variables
-func calculateMTKVariable(name string, build Builder, vars []variables.LagoonEnvironmentVariable) (string, error) {
+func calculateMTKVariable(name string, build Builder, r *resolver) (string, error) {
+	field := fmt.Sprintf("mtk.%s", mtkFieldNames[name])
 	// support new raw basic `MTK_*` variable
 	fVar := fmt.Sprintf("MTK_%s", name)
 	sVar := fmt.Sprintf("BUILDER_%s", fVar)
-	sVarVal := checkVariable(sVar, "", vars)
+	sVarVal, source, _ := r.lookup(sVar)
 	if sVarVal != "" {
+		r.record(field, source)
 		return sVarVal, nil
 	}
 
 	// fall back to support pre-existing MTK_DUMP_*
 	fVar = fmt.Sprintf("MTK_DUMP_%s", name)
 	sVar = fmt.Sprintf("BUILDER_%s", fVar)
-	sVarVal = checkVariable(sVar, "", vars)
+	sVarVal, source, _ = r.lookup(sVar)
 	if sVarVal != "" {
+		r.record(field, source)
 		return sVarVal, nil
 	}
 
 	// support new MTK_*_NAME
 	// get the name of the lookup variable
-	sVar = checkVariable(fmt.Sprintf("MTK_%s_NAME", name), "", vars)
+	nameVar := fmt.Sprintf("MTK_%s_NAME", name)
+	sVar, _, _ = r.lookup(nameVar)
 	if sVar != "" {
 		// check that this variable exists with a value
-		sVarVal = checkVariable(sVar, "", vars)
+		sVarVal, source, _ = r.lookup(sVar)
 		if sVarVal != "" {
+			source.Detail = fmt.Sprintf("named by %s", nameVar)
+			r.record(field, source)
 			return sVarVal, nil
 		}
 		return "", fmt.Errorf("no variable found for %s", sVar)
 	}
 
 	// fall back to the default servicename variable
-	sVarVal = variables.GetEnv(fmt.Sprintf("%s_%s", build.FixedDockerComposeServiceName, name), sVarVal)
-	return sVarVal, nil
-}
-
-// imagePatternParser parses the image pattern
-func imagePatternParser(pattern string, build Builder) string {
-	cleanDatabase := replaceDoubleSpecial(build.MTK.Database)
-	pattern = strings.Replace(pattern, "${database}", cleanDatabase, 1)
-	pattern = strings.Replace(pattern, "${service}", build.DockerComposeServiceName, 1)
-	pattern = strings.Replace(pattern, "${registry}", build.RegistryHost, 1)
-	pattern = strings.Replace(pattern, "${organization}", build.RegistryOrganization, 1)
-	pattern = strings.Replace(pattern, "${project}", variables.GetEnv("LAGOON_PROJECT", ""), 1)
-	pattern = strings.Replace(pattern, "${environment}", variables.GetEnv("LAGOON_ENVIRONMENT", ""), 1)
-	return pattern
+	serviceVar := fmt.Sprintf("%s_%s", build.FixedDockerComposeServiceName, name)
+	if value, ok := os.LookupEnv(serviceVar); ok {
+		r.record(field, Source{Layer: layerProcess, Variable: serviceVar})
+		return value, nil
+	}
+	r.record(field, Source{Layer: layerDefault})
+	return "", nil
+}
+
+// mtkFieldNames maps the name used in the MTK variables to the json name of the field in the MTK struct
+var mtkFieldNames = map[string]string{
+	"HOSTNAME": "host",
+	"USERNAME": "username",
+	"PASSWORD": "password",
+	"DATABASE": "database",
+	"PORT":     "port",
+	"SOCKET":   "socket",
+	"TLS_MODE": "tlsMode",
+	"TLS_CA":   "tlsCA",
+	"TLS_CERT": "tlsCert",
+	"TLS_KEY":  "tlsKey",
 }
 
 // Replaces two of the same special character in a row with a single instance, because otherwise DockerHub will reject it
@@ -2,8 +2,10 @@ package builder
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/andreyvit/diff"
 	"github.com/uselagoon/machinery/utils/variables"
@@ -46,12 +48,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "lagpro/lagenv",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
 					Host:     "dbhost",
@@ -59,6 +71,24 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpass",
 					Database: "dbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -87,12 +117,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "reghost/mariadb-data",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
 					Host:     "dbhost",
@@ -100,6 +140,24 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpass",
 					Database: "dbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -129,12 +187,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "reghost/mariadb-data",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
 					Host:     "dbrrhost1",
@@ -142,6 +210,26 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpass",
 					Database: "dbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Hosts:    []string{"dbrrhost1", "dbrrhost2"},
+					Primary:  "dbhost",
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -174,12 +262,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "reghost/mariadb-data",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
 					Host:     "dbhostcentral",
@@ -187,6 +285,24 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpasscentral",
 					Database: "dbnamecentral",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -216,12 +332,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "reghost/mariadb-data",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
 					Host:     "dbrrhost1",
@@ -229,6 +355,26 @@ func Test_generateValues(t *testing.T) {
 					Password: "mariadbpass",
 					Database: "mariadbdbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Hosts:    []string{"dbrrhost1", "dbrrhost2"},
+					Primary:  "mariadbhost",
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -257,12 +403,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "lagpro/lagenv",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				Debug:                         true,
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
@@ -271,6 +427,24 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpass",
 					Database: "dbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -299,12 +473,22 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mysql:8.0.41-oracle",
 				CleanImageName:                "uselagoon/mysql-8.0:latest",
 				ResultImageDatabaseName:       "lagoon",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "lagoon",
+				ResultImagePassword:           "lagoon",
 				ResultImageName:               "lagpro/lagenv",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				ResultImageTag:                "backup-2026-10-18",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mysql",
 				MTK: MTK{
 					Host:     "dbhost",
@@ -312,6 +496,24 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpass",
 					Database: "dbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
 			},
 		},
 		{
@@ -341,13 +543,168 @@ func Test_generateValues(t *testing.T) {
 				SourceImageName:               "mariadb:10.6",
 				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
 				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
 				ResultImageName:               "reghost/mariadb-data",
 				ResultImageTag:                "lagenv",
 				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
+				PushTags:                      "both",
+				Tags:                          []string{"latest", "lagenv"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
+				RegistryUsername:              "reguser",
+				RegistryPassword:              "regpass",
+				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
+				DatabaseType:                  "mariadb",
+				MTK: MTK{
+					Host:     "dbhost",
+					Username: "dbuser",
+					Password: "dbpass",
+					Database: "dbname",
+				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
+			},
+		},
+		{
+			name:        "test9",
+			description: "check the port and tls settings are resolved, and the port can be given with the host",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_HOST", Value: "reghost", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost:3307", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_PASSWORD", Value: "dbpass", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+					{Name: "BUILDER_MTK_TLS_MODE", Value: "verify-full", Scope: "global"},
+					{Name: "MTK_TLS_CA_NAME", Value: "DB_CA_BUNDLE", Scope: "global"},
+					{Name: "DB_CA_BUNDLE", Value: "/etc/ssl/certs/rds.pem", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+					{Name: "MARIADB_READREPLICA_HOSTS", Value: "dbrrhost1,dbrrhost2:3309"},
+				},
+			},
+			want: Builder{
+				DockerComposeServiceName:      "mariadb",
+				FixedDockerComposeServiceName: "MARIADB",
+				SourceImageName:               "mariadb:10.6",
+				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
+				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
+				ResultImageName:               "lagpro/lagenv",
+				ResultImageTag:                "backup-2026-10-18",
+				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
+				PushTags:                      "both",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
+				RegistryUsername:              "reguser",
+				RegistryPassword:              "regpass",
+				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
+				DatabaseType:                  "mariadb",
+				MTK: MTK{
+					Host:     "dbrrhost1",
+					Port:     "3307",
+					Username: "dbuser",
+					Password: "dbpass",
+					Database: "dbname",
+					TLSMode:  "verify-full",
+					TLSCA:    "/etc/ssl/certs/rds.pem",
+				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Hosts:    []string{"dbrrhost1", "dbrrhost2:3309"},
+					Primary:  "dbhost",
+					Strategy: "first-healthy",
+				},
+			},
+		},
+		{
+			name:        "test10",
+			description: "check an empty image tag falls back to the default tag",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_DOCKER_COMPOSE_SERVICE_NAME", Value: "mariadb", Scope: "global"},
+					{Name: "BUILDER_BACKUP_IMAGE_NAME", Value: "${registry}/${service}-data", Scope: "global"},
+					{Name: "BUILDER_BACKUP_IMAGE_TAG", Value: "", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_HOST", Value: "reghost", Scope: "global"},
+					{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+					{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+					{Name: "BUILDER_MTK_PASSWORD", Value: "dbpass", Scope: "global"},
+					{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+				},
+			},
+			want: Builder{
+				DockerComposeServiceName:      "mariadb",
+				FixedDockerComposeServiceName: "MARIADB",
+				SourceImageName:               "mariadb:10.6",
+				CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
+				ResultImageDatabaseName:       "drupal",
+				ResultImageRootPassword:       "Lag00n",
+				ResultImageUser:               "drupal",
+				ResultImagePassword:           "drupal",
+				ResultImageName:               "reghost/mariadb-data",
+				ResultImageTag:                "backup-2026-10-18",
+				DockerHost:                    "docker-host.lagoon-image-builder.svc",
+				DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
 				PushTags:                      "both",
+				Tags:                          []string{"latest", "backup-2026-10-18"},
+				BuildBackend:                  "docker",
+				DataDir:                       "/initialized-db",
+				ContextOutputDir:              "/workspace",
 				RegistryUsername:              "reguser",
 				RegistryPassword:              "regpass",
 				RegistryHost:                  "reghost",
+				RegistryType:                  "auto",
 				DatabaseType:                  "mariadb",
 				MTK: MTK{
 					Host:     "dbhost",
@@ -355,6 +712,24 @@ func Test_generateValues(t *testing.T) {
 					Password: "dbpass",
 					Database: "dbname",
 				},
+				Import: Import{
+					BufferPoolSize:   "2G",
+					MaxAllowedPacket: "1G",
+					SortBufferSize:   "128M",
+					IOThreads:        "4",
+				},
+				MyCnf: MyCnf{
+					MaxAllowedPacket: "1G",
+				},
+				Retention: Retention{
+					TagPattern: defaultRetentionTagPattern,
+				},
+				Compression: Compression{
+					Codec: "gzip",
+				},
+				Replicas: Replicas{
+					Strategy: "first-healthy",
+				},
 			},
 		},
 	}
@@ -368,7 +743,7 @@ func Test_generateValues(t *testing.T) {
 			}
 		}
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := generateValues()
+			got, err := generateValues(time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC))
 			if err != nil {
 				t.Errorf("generateValues() = %v", err)
 			}
@@ -399,6 +774,7 @@ func Test_imagePatternParser(t *testing.T) {
 		description string
 		args        args
 		want        string
+		wantErr     string
 	}{
 		{
 			name: "test1",
@@ -412,6 +788,9 @@ func Test_imagePatternParser(t *testing.T) {
 					ResultImageName:               "backup/image",
 					DockerHost:                    "docker-host.lagoon-image-builder.svc",
 					PushTags:                      "both",
+					BuildBackend:                  "docker",
+					DataDir:                       "/initialized-db",
+					ContextOutputDir:              "/workspace",
 					RegistryUsername:              "reguser",
 					RegistryPassword:              "regpass",
 					RegistryHost:                  "reghost",
@@ -436,6 +815,9 @@ func Test_imagePatternParser(t *testing.T) {
 					ResultImageName:               "backup/image",
 					DockerHost:                    "docker-host.lagoon-image-builder.svc",
 					PushTags:                      "both",
+					BuildBackend:                  "docker",
+					DataDir:                       "/initialized-db",
+					ContextOutputDir:              "/workspace",
 					RegistryUsername:              "reguser",
 					RegistryPassword:              "regpass",
 					RegistryHost:                  "reghost",
@@ -460,6 +842,9 @@ func Test_imagePatternParser(t *testing.T) {
 					ResultImageName:               "backup/image",
 					DockerHost:                    "docker-host.lagoon-image-builder.svc",
 					PushTags:                      "both",
+					BuildBackend:                  "docker",
+					DataDir:                       "/initialized-db",
+					ContextOutputDir:              "/workspace",
 					RegistryUsername:              "reguser",
 					RegistryPassword:              "regpass",
 					RegistryHost:                  "reghost",
@@ -484,6 +869,9 @@ func Test_imagePatternParser(t *testing.T) {
 					ResultImageName:               "backup/image",
 					DockerHost:                    "docker-host.lagoon-image-builder.svc",
 					PushTags:                      "both",
+					BuildBackend:                  "docker",
+					DataDir:                       "/initialized-db",
+					ContextOutputDir:              "/workspace",
 					RegistryUsername:              "reguser",
 					RegistryPassword:              "regpass",
 					RegistryHost:                  "reghost",
@@ -512,6 +900,9 @@ func Test_imagePatternParser(t *testing.T) {
 					ResultImageName:               "backup/image",
 					DockerHost:                    "docker-host.lagoon-image-builder.svc",
 					PushTags:                      "both",
+					BuildBackend:                  "docker",
+					DataDir:                       "/initialized-db",
+					ContextOutputDir:              "/workspace",
 					RegistryUsername:              "reguser",
 					RegistryPassword:              "regpass",
 					RegistryHost:                  "reghost",
@@ -527,7 +918,79 @@ func Test_imagePatternParser(t *testing.T) {
 			},
 			want: "regorg/database-mysql-lagpro-lagenv-test_database_name",
 		},
+		{
+			name:        "test6",
+			description: "Check a placeholder can be used more than once",
+			args: args{
+				pattern: "${registry}/${project}/${project}-${environment}:${environment}",
+				build:   Builder{RegistryHost: "reghost"},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_ENVIRONMENT", Value: "lagenv"},
+				},
+			},
+			want: "reghost/lagpro/lagpro-lagenv:lagenv",
+		},
+		{
+			name:        "test7",
+			description: "Check the git, task and time placeholders",
+			args: args{
+				pattern: "${branch}-${git_sha}-${task_id}-${date}-${timestamp}-${month}-${week}",
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_GIT_BRANCH", Value: "main"},
+					{Name: "LAGOON_GIT_SHA", Value: "0123456789abcdef"},
+					{Name: "LAGOON_TASK_ID", Value: "42"},
+				},
+			},
+			want: "main-0123456789abcdef-42-2026-10-18-20261018010203-2026-10-2026-w42",
+		},
+		{
+			name:        "test8",
+			description: "Check filters are applied in order",
+			args: args{
+				pattern: "${project|upper}/${branch | sanitize | lower | truncate:12}-${git_sha|truncate:7}-${task_id|default:manual}",
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+					{Name: "LAGOON_GIT_BRANCH", Value: "Feature//Some_Long!!Name"},
+					{Name: "LAGOON_GIT_SHA", Value: "0123456789abcdef"},
+				},
+			},
+			want: "LAGPRO/feature/some-0123456-manual",
+		},
+		{
+			name:        "test9",
+			description: "Check an unknown placeholder is an error",
+			args: args{
+				pattern: "${registry}/${branchname}",
+			},
+			wantErr: "invalid pattern ${registry}/${branchname}: unknown placeholder ${branchname}, use one of branch, database, date, environment, git_sha, month, organization, project, registry, service, task_id, timestamp, week",
+		},
+		{
+			name:        "test10",
+			description: "Check an unknown filter is an error",
+			args: args{
+				pattern: "${project|title}",
+			},
+			wantErr: "invalid pattern ${project|title}: unknown filter title in ${project|title}",
+		},
+		{
+			name:        "test11",
+			description: "Check a truncate without a length is an error",
+			args: args{
+				pattern: "${project|truncate}",
+			},
+			wantErr: `invalid pattern ${project|truncate}: truncate needs a length greater than 0, not "" in ${project|truncate}`,
+		},
+		{
+			name:        "test12",
+			description: "Check an unterminated placeholder is an error",
+			args: args{
+				pattern: "${registry}/${project",
+			},
+			wantErr: "invalid pattern ${registry}/${project: ${project is missing a closing }",
+		},
 	}
+	now := time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC)
 	for _, tt := range tests {
 		for _, envVar := range tt.args.setVars {
 			err := os.Setenv(envVar.Name, envVar.Value)
@@ -536,7 +999,17 @@ func Test_imagePatternParser(t *testing.T) {
 			}
 		}
 		t.Run(tt.name, func(t *testing.T) {
-			if got := imagePatternParser(tt.args.pattern, tt.args.build); got != tt.want {
+			got, err := imagePatternParser(tt.args.pattern, tt.args.build, now)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr || !errors.Is(err, ErrInvalidPattern) {
+					t.Errorf("imagePatternParser() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("imagePatternParser() error = %v", err)
+			}
+			if got != tt.want {
 				t.Errorf("imagePatternParser() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/uselagoon/database-image-task/internal/oci"
+)
+
+// passwdPath is used to find the uid of the database user in the clean image
+const passwdPath = "/etc/passwd"
+
+// myCnfPaths are where the clean image can keep its my.cnf, the first one that exists is used
+var myCnfPaths = []string{"/etc/mysql/my.cnf", "/etc/my.cnf"}
+
+// imageLayout is where the clean image keeps its database, and who it runs as, these are detected from the clean image
+// instead of being hard-coded so that images other than the lagoon ones can be used
+type imageLayout struct {
+	// DataDir is the data directory of the database server in the clean image
+	DataDir string
+	// UID is the user that the database server runs as, and that has to own the data directory
+	UID int
+	// MyCnf is the my.cnf that the server reads, postgres images don't have one
+	MyCnf string
+}
+
+// imageDefaults are the data directory and user that the official and lagoon images of each database type use
+var imageDefaults = map[string]struct {
+	DataDir string
+	User    string
+}{
+	"mariadb":  {DataDir: "/var/lib/mysql", User: "mysql"},
+	"mysql":    {DataDir: "/var/lib/mysql", User: "mysql"},
+	"postgres": {DataDir: "/var/lib/postgresql/data", User: "postgres"},
+}
+
+// inspectCleanImage reads the config of the clean image and the files needed to work out its layout from the registry
+func (p *Pipeline) inspectCleanImage(ctx context.Context, image string) (*oci.ImageInfo, error) {
+	platform := v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+	return oci.Inspect(ctx, image, append([]string{passwdPath}, myCnfPaths...), p.ociKeychain(), platform)
+}
+
+// detectImageLayout works out the layout of the clean image for the database type
+// an image that doesn't look like a mariadb, mysql or postgres image is an error, rather than a build that produces
+// an image that won't start
+func detectImageLayout(dbType string, info *oci.ImageInfo) (imageLayout, error) {
+	defaults := imageDefaults[dbType]
+	layout := imageLayout{}
+	// postgres images give the data directory in PGDATA, otherwise it is the volume that the image declares
+	for _, env := range info.Config.Env {
+		if value, ok := strings.CutPrefix(env, "PGDATA="); ok && dbType == "postgres" && value != "" {
+			layout.DataDir = value
+		}
+	}
+	if layout.DataDir == "" {
+		volumes := []string{}
+		for volume := range info.Config.Volumes {
+			volumes = append(volumes, strings.TrimSuffix(volume, "/"))
+		}
+		slices.Sort(volumes)
+		switch {
+		case slices.Contains(volumes, defaults.DataDir):
+			layout.DataDir = defaults.DataDir
+		case len(volumes) == 1:
+			layout.DataDir = volumes[0]
+		default:
+			return layout, fmt.Errorf("%w: unable to find the %s data directory in the volumes %v", ErrUnrecognisedImage, dbType, volumes)
+		}
+	}
+
+	// the image user is used if it isn't root, otherwise the entrypoint drops to the database user
+	user, _, _ := strings.Cut(info.Config.User, ":")
+	if user == "" || user == "root" || user == "0" {
+		user = defaults.User
+	}
+	uid, err := strconv.Atoi(user)
+	if err != nil {
+		uid, err = lookupUID(info.Files[passwdPath], user)
+		if err != nil {
+			return layout, err
+		}
+	}
+	layout.UID = uid
+
+	if dbType != "postgres" {
+		for _, myCnf := range myCnfPaths {
+			if _, ok := info.Files[myCnf]; ok {
+				layout.MyCnf = myCnf
+				break
+			}
+		}
+		if layout.MyCnf == "" {
+			return layout, fmt.Errorf("%w: unable to find a my.cnf in %s", ErrUnrecognisedImage, strings.Join(myCnfPaths, " or "))
+		}
+	}
+	return layout, nil
+}
+
+// lookupUID finds the uid of a user in the passwd file of the image
+func lookupUID(passwd []byte, user string) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(passwd))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || fields[0] != user {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, fmt.Errorf("%w: the uid of %s in %s is invalid", ErrUnrecognisedImage, user, passwdPath)
+		}
+		return uid, nil
+	}
+	return 0, fmt.Errorf("%w: unable to find the %s user in %s", ErrUnrecognisedImage, user, passwdPath)
+}
@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/uselagoon/database-image-task/internal/oci"
+)
+
+func Test_detectImageLayout(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		dbType      string
+		info        *oci.ImageInfo
+		want        imageLayout
+		wantErr     string
+	}{
+		{
+			name:        "test1",
+			description: "check the lagoon mariadb image layout is detected",
+			dbType:      "mariadb",
+			info:        lagoonImageInfo("mariadb"),
+			want:        imageLayout{DataDir: "/var/lib/mysql", UID: 100, MyCnf: "/etc/mysql/my.cnf"},
+		},
+		{
+			name:        "test2",
+			description: "check the lagoon mysql image layout is detected",
+			dbType:      "mysql",
+			info:        lagoonImageInfo("mysql"),
+			want:        imageLayout{DataDir: "/var/lib/mysql", UID: 999, MyCnf: "/etc/mysql/my.cnf"},
+		},
+		{
+			name:        "test3",
+			description: "check the postgres data directory comes from PGDATA",
+			dbType:      "postgres",
+			info:        lagoonImageInfo("postgres"),
+			want:        imageLayout{DataDir: "/var/lib/postgresql/data", UID: 70},
+		},
+		{
+			name:        "test4",
+			description: "check an image that runs as root uses the mysql user, a numeric user and a single custom volume",
+			dbType:      "mysql",
+			info: &oci.ImageInfo{
+				Config: v1.Config{User: "0:0", Volumes: map[string]struct{}{"/data/": {}}},
+				Files: map[string][]byte{
+					"/etc/passwd": []byte("root:x:0:0:root:/root:/bin/bash\nmysql:x:27:27::/data:/sbin/nologin\n"),
+					"/etc/my.cnf": []byte("[mysqld]\n"),
+				},
+			},
+			want: imageLayout{DataDir: "/data", UID: 27, MyCnf: "/etc/my.cnf"},
+		},
+		{
+			name:        "test5",
+			description: "check a numeric user doesn't need a passwd entry",
+			dbType:      "mariadb",
+			info: &oci.ImageInfo{
+				Config: v1.Config{User: "1001", Volumes: map[string]struct{}{"/var/lib/mysql": {}, "/var/log/mysql": {}}},
+				Files:  map[string][]byte{"/etc/mysql/my.cnf": []byte("[mysqld]\n")},
+			},
+			want: imageLayout{DataDir: "/var/lib/mysql", UID: 1001, MyCnf: "/etc/mysql/my.cnf"},
+		},
+		{
+			name:        "test6",
+			description: "check an image without a data directory volume is rejected",
+			dbType:      "mariadb",
+			info: &oci.ImageInfo{
+				Config: v1.Config{User: "mysql"},
+				Files:  map[string][]byte{"/etc/passwd": []byte("mysql:x:100:101::/var/lib/mysql:/sbin/nologin\n")},
+			},
+			wantErr: "unrecognised clean image: unable to find the mariadb data directory in the volumes []",
+		},
+		{
+			name:        "test7",
+			description: "check an image without the database user is rejected",
+			dbType:      "mariadb",
+			info: &oci.ImageInfo{
+				Config: v1.Config{Volumes: map[string]struct{}{"/var/lib/mysql": {}}},
+				Files:  map[string][]byte{"/etc/passwd": []byte("root:x:0:0:root:/root:/bin/sh\n")},
+			},
+			wantErr: "unrecognised clean image: unable to find the mysql user in /etc/passwd",
+		},
+		{
+			name:        "test8",
+			description: "check a mariadb image without a my.cnf is rejected",
+			dbType:      "mariadb",
+			info: &oci.ImageInfo{
+				Config: v1.Config{User: "100", Volumes: map[string]struct{}{"/var/lib/mysql": {}}},
+			},
+			wantErr: "unrecognised clean image: unable to find a my.cnf in /etc/mysql/my.cnf or /etc/my.cnf",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectImageLayout(tt.dbType, tt.info)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr || !errors.Is(err, ErrUnrecognisedImage) {
+					t.Errorf("detectImageLayout() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("detectImageLayout() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("detectImageLayout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
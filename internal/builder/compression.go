@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// supportedCompressionCodecs are the codecs the dump can be compressed with, the entrypoints of the mariadb, mysql and
+// postgres builder images import each of these by their extension
+var supportedCompressionCodecs = []string{"none", "gzip", "zstd"}
+
+// compressionExtensions are the extensions added to the dump file for each codec
+var compressionExtensions = map[string]string{
+	"none": "",
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// Compression is how the dump is compressed as it is written, so that a large database isn't written to the working
+// directory uncompressed and then copied into the build context
+type Compression struct {
+	// Codec is one of the supportedCompressionCodecs
+	Codec string `json:"codec"`
+	// Level is left blank to use the default level of the codec
+	Level string `json:"level,omitempty"`
+}
+
+// levels returns the range of levels that the codec accepts
+func (c Compression) levels() (int, int) {
+	switch c.Codec {
+	case "gzip":
+		return gzip.BestSpeed, gzip.BestCompression
+	case "zstd":
+		return 1, 22
+	}
+	return 0, 0
+}
+
+// filename is the name of the dump file in the working directory, and in the directory the builder image imports from
+func (c Compression) filename() string {
+	return sanitisedDumpFilename + compressionExtensions[c.Codec]
+}
+
+// writer wraps a writer so that everything written to it is compressed, the returned writer must be closed to write
+// the end of the compressed stream, this doesn't close the underlying writer
+func (c Compression) writer(w io.Writer) (io.WriteCloser, error) {
+	level := 0
+	if c.Level != "" {
+		var err error
+		if level, err = strconv.Atoi(c.Level); err != nil {
+			return nil, fmt.Errorf("invalid compression level %q: %v", c.Level, err)
+		}
+	}
+	switch c.Codec {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		options := []zstd.EOption{}
+		if level != 0 {
+			// the zstd levels are mapped to the closest of the speeds that the encoder has
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, options...)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// countingWriter counts the bytes written through it, so the size of the dump is known before it is compressed
+type countingWriter struct {
+	io.Writer
+	n uint64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.n += uint64(n)
+	return n, err
+}
+
+// removeStaleDumps removes the dumps written with any of the other codecs, the dockerfiles copy whichever dump is in
+// the working directory, so only the one that is about to be written can be there
+func (p *Pipeline) removeStaleDumps() error {
+	for codec := range compressionExtensions {
+		if codec == p.Build.Compression.Codec {
+			continue
+		}
+		path := filepath.Join(p.WorkDir, (Compression{Codec: codec}).filename())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// readDump reads a dump file, decompressing it by its extension
+func readDump(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	case ".zst":
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return io.ReadAll(r)
+}
+
+func Test_Compression_writer(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		compression  Compression
+		wantFilename string
+		wantErr      string
+	}{
+		{
+			name:         "test1",
+			description:  "check an uncompressed dump is written as is",
+			compression:  Compression{Codec: "none"},
+			wantFilename: "sanitised-dump.sql",
+		},
+		{
+			name:         "test2",
+			description:  "check a gzip dump with the default level",
+			compression:  Compression{Codec: "gzip"},
+			wantFilename: "sanitised-dump.sql.gz",
+		},
+		{
+			name:         "test3",
+			description:  "check a gzip dump with the fastest level",
+			compression:  Compression{Codec: "gzip", Level: "1"},
+			wantFilename: "sanitised-dump.sql.gz",
+		},
+		{
+			name:         "test4",
+			description:  "check a zstd dump with a level that is mapped to the closest encoder speed",
+			compression:  Compression{Codec: "zstd", Level: "19"},
+			wantFilename: "sanitised-dump.sql.zst",
+		},
+		{
+			name:        "test5",
+			description: "check a level that isn't a number fails",
+			compression: Compression{Codec: "zstd", Level: "max"},
+			wantErr:     `invalid compression level "max"`,
+		},
+	}
+	dump := strings.Repeat("INSERT INTO `users` (`uid`, `name`) VALUES (1,'admin'),(2,'editor');\n", 1000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.compression.filename())
+			f, _ := os.Create(path)
+			defer f.Close()
+			w, err := tt.compression.writer(f)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("writer() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("writer() error = %v", err)
+			}
+			if filepath.Base(path) != tt.wantFilename {
+				t.Errorf("filename() = %v, want %v", filepath.Base(path), tt.wantFilename)
+			}
+			io.WriteString(w, dump)
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			f.Close()
+			got, err := readDump(path)
+			if err != nil {
+				t.Fatalf("readDump() error = %v", err)
+			}
+			if string(got) != dump {
+				t.Errorf("writer() wrote %d bytes, want %d", len(got), len(dump))
+			}
+			info, _ := os.Stat(path)
+			if tt.compression.Codec != "none" && info.Size() >= int64(len(dump)) {
+				t.Errorf("writer() wrote %d bytes, want fewer than %d", info.Size(), len(dump))
+			}
+		})
+	}
+}
+
+func Test_Pipeline_databaseDump_compression(t *testing.T) {
+	p, _, out := newTestPipeline(t, &fakeExecutor{})
+	p.Build = validBuilder()
+	p.Build.Compression = Compression{Codec: "zstd"}
+	p.OpenDatabase = fakeMySQLDatabase().open
+	// a dump left behind with another codec would also be copied by the dockerfile
+	stale := filepath.Join(p.WorkDir, "sanitised-dump.sql.gz")
+	os.WriteFile(stale, []byte("stale"), 0644)
+	if err := p.databaseDump(context.Background()); err != nil {
+		t.Fatalf("databaseDump() error = %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("databaseDump() left the stale dump %s", stale)
+	}
+	got, err := readDump(filepath.Join(p.WorkDir, "sanitised-dump.sql.zst"))
+	if err != nil {
+		t.Fatalf("readDump() error = %v", err)
+	}
+	if !bytes.HasPrefix(got, []byte("SET NAMES utf8mb4;\n")) || !bytes.HasSuffix(got, []byte("SET UNIQUE_CHECKS = 1;\n")) {
+		t.Errorf("databaseDump() = %s", got)
+	}
+	if !strings.Contains(out.String(), "of sanitised-dump.sql, compressed with zstd to") {
+		t.Errorf("databaseDump() output = %v", out.String())
+	}
+}
@@ -0,0 +1,244 @@
+package builder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// connectTimeout is how long connecting to the database for the dump can take
+const connectTimeout = 30 * time.Second
+
+// supportedTLSModes are the values that the MTK TLS mode can be set to, they are the libpq sslmode values
+var supportedTLSModes = []string{"disable", "prefer", "require", "verify-ca", "verify-full"}
+
+// mtkConnectionNames are the optional MTK variables that change how the database is connected to, they are resolved
+// with the same lookup chain as the host, username, password and database
+var mtkConnectionNames = []string{"PORT", "SOCKET", "TLS_MODE", "TLS_CA", "TLS_CERT", "TLS_KEY"}
+
+// connectionField returns a pointer to the field of the MTK struct for one of the mtkConnectionNames
+func (m *MTK) connectionField(name string) *string {
+	switch name {
+	case "PORT":
+		return &m.Port
+	case "SOCKET":
+		return &m.Socket
+	case "TLS_MODE":
+		return &m.TLSMode
+	case "TLS_CA":
+		return &m.TLSCA
+	case "TLS_CERT":
+		return &m.TLSCert
+	case "TLS_KEY":
+		return &m.TLSKey
+	}
+	return nil
+}
+
+// usesTLS is true if any of the tls settings would make the connection use tls
+func (m MTK) usesTLS() bool {
+	return (m.TLSMode != "" && m.TLSMode != "disable") || m.TLSCA != "" || m.TLSCert != "" || m.TLSKey != ""
+}
+
+// defaultPort is the port used for the database type when one isn't set
+func defaultPort(dbType string) string {
+	if dbType == "postgres" {
+		return "5432"
+	}
+	return "3306"
+}
+
+// address is the host and port to connect to, using the default port of the database type if one isn't set
+func (m MTK) address(dbType string) string {
+	port := m.Port
+	if port == "" {
+		port = defaultPort(dbType)
+	}
+	return net.JoinHostPort(m.Host, port)
+}
+
+// mysqlConfig is the config used to connect to a mariadb or mysql database, for the dump, the preflight checks and the
+// read replica checks
+func (m MTK) mysqlConfig(timeout time.Duration) (*mysql.Config, error) {
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = m.address("mysql")
+	if m.Socket != "" {
+		cfg.Net = "unix"
+		cfg.Addr = m.Socket
+	}
+	cfg.User = m.Username
+	cfg.Passwd = m.Password
+	cfg.Timeout = timeout
+	cfg.ReadTimeout = timeout
+	if err := m.applyTLS(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyTLS sets up tls on the mariadb or mysql config, the modes mean the same as they do for postgres:
+//   - disable doesn't use tls
+//   - prefer uses tls if the server supports it, without checking the server certificate, this is used if only the
+//     certificates are set
+//   - require always uses tls without checking the server certificate, unless a CA is set, which makes it verify-ca
+//   - verify-ca checks that the server certificate is signed by the CA, or by the system CAs if one isn't set
+//   - verify-full also checks that the server certificate matches the host
+func (m MTK) applyTLS(cfg *mysql.Config) error {
+	mode := m.TLSMode
+	if mode == "" && m.usesTLS() {
+		mode = "prefer"
+	}
+	if mode == "" || mode == "disable" {
+		return nil
+	}
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	if m.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(m.TLSCert, m.TLSKey)
+		if err != nil {
+			return fmt.Errorf("unable to load the tls client certificate %s: %v", m.TLSCert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if m.TLSCA != "" {
+		ca, err := os.ReadFile(m.TLSCA)
+		if err != nil {
+			return fmt.Errorf("unable to read the tls CA %s: %v", m.TLSCA, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("the tls CA %s doesn't contain any certificates", m.TLSCA)
+		}
+		if mode == "require" {
+			mode = "verify-ca"
+		}
+	}
+	switch mode {
+	case "prefer":
+		tlsConfig.InsecureSkipVerify = true
+		cfg.AllowFallbackToPlaintext = true
+	case "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// the host name isn't checked, so the chain is verified separately from the usual verification
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyChain(tlsConfig.RootCAs)
+	}
+	cfg.TLS = tlsConfig
+	return nil
+}
+
+// verifyChain checks that the certificate sent by the server is signed by one of the roots, without checking the host
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("the server didn't send a tls certificate")
+		}
+		certs := []*x509.Certificate{}
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
+
+// openDatabase connects to the mariadb or mysql database that is dumped, the read timeout isn't used as selecting the
+// rows of a large table can take longer than it to start returning them
+func (p *Pipeline) openDatabase(ctx context.Context) (*sql.DB, error) {
+	cfg, err := p.Build.MTK.mysqlConfig(connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DBName = p.Build.MTK.Database
+	cfg.ReadTimeout = 0
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	// the dump is read in a single transaction, so only one connection is needed
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// splitHostPort splits a host given as host:port or [ipv6]:port, the port is blank if the host doesn't have one
+// a bare ipv6 address is returned as is
+func splitHostPort(address string) (string, string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, ""
+	}
+	return host, port
+}
+
+// isPEM is true if a tls setting is the certificate or key itself rather than the path to it
+func isPEM(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
+
+// writeTLSFiles writes any of the tls certificates and key that were given as PEM into a private temporary directory,
+// and replaces them with the path they were written to, as the dump tools only take paths
+// the working directory isn't used as it is the build context, which is sent to the docker host or packed for kaniko
+func (p *Pipeline) writeTLSFiles() error {
+	files := []struct {
+		name  string
+		value *string
+	}{
+		{name: "ca.pem", value: &p.Build.MTK.TLSCA},
+		{name: "cert.pem", value: &p.Build.MTK.TLSCert},
+		{name: "key.pem", value: &p.Build.MTK.TLSKey},
+	}
+	for _, file := range files {
+		if !isPEM(*file.value) {
+			continue
+		}
+		if p.tlsDir == "" {
+			// the directory is only readable by the user running the task
+			dir, err := os.MkdirTemp("", "database-image-task-tls-")
+			if err != nil {
+				return err
+			}
+			p.tlsDir = dir
+		}
+		// libpq refuses to use a key that can be read by anyone else
+		path := filepath.Join(p.tlsDir, file.name)
+		if err := os.WriteFile(path, []byte(*file.value), 0600); err != nil {
+			return fmt.Errorf("unable to write %s: %v", path, err)
+		}
+		*file.value = path
+	}
+	return nil
+}
+
+// removeTLSFiles removes the directory that the tls certificates and key were written to, once the build has finished
+func (p *Pipeline) removeTLSFiles() error {
+	if p.tlsDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(p.tlsDir)
+	p.tlsDir = ""
+	return err
+}
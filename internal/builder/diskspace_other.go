@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package builder
+
+import "errors"
+
+// freeSpace isn't supported on this platform, the task only runs on linux
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Command is an external command that one of the build stages needs to run
+type Command struct {
+	Name   string
+	Args   []string
+	Env    []string
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Executor runs external commands, the build stages only talk to the outside world through this
+// so that they can be tested without the real binaries being available
+type Executor interface {
+	Run(ctx context.Context, c Command) error
+}
+
+// osExecutor runs commands on the host, the environment of the current process is passed through
+// with any additional variables from the command appended
+type osExecutor struct{}
+
+func (osExecutor) Run(ctx context.Context, c Command) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,44 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"text/tabwriter"
+	"time"
+)
+
+// explainedField is a single resolved field and the source it came from
+type explainedField struct {
+	Field  string
+	Value  string
+	Source Source
+}
+
+// Explain generates the values and writes each of them along with where it came from, secrets are redacted
+func Explain(w io.Writer) error {
+	build, sources, err := resolveValues(time.Now())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FIELD\tVALUE\tSOURCE")
+	for _, f := range explainFields(build, sources) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Field, f.Value, f.Source)
+	}
+	return tw.Flush()
+}
+
+// explainFields walks the Builder in field order, pairing each field with its source
+func explainFields(build Builder, sources map[string]Source) []explainedField {
+	fields := []explainedField{}
+	walkFields(reflect.ValueOf(build.Redacted()), "", func(path string, field reflect.StructField, value reflect.Value) {
+		str := fieldString(value)
+		source, ok := sources[path]
+		if !ok {
+			source = Source{Layer: layerDefault}
+		}
+		fields = append(fields, explainedField{Field: path, Value: str, Source: source})
+	})
+	return fields
+}
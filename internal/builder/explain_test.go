@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+func Test_resolveValues_sources(t *testing.T) {
+	type args struct {
+		projectVars []variables.LagoonEnvironmentVariable
+		envVars     []variables.LagoonEnvironmentVariable
+		setVars     []EnvironmentVariable
+	}
+	tests := []struct {
+		name        string
+		description string
+		args        args
+		want        map[string]Source
+	}{
+		{
+			name:        "test1",
+			description: "check that values are attributed to the project, environment, process environment and defaults",
+			args: args{
+				projectVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "projectuser", Scope: "global"},
+					{Name: "BUILDER_REGISTRY_HOST", Value: "projecthost", Scope: "build"},
+				},
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_REGISTRY_USERNAME", Value: "envuser", Scope: "global"},
+					{Name: "BUILDER_MTK_DUMP_DATABASE", Value: "dbname", Scope: "runtime"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "BUILDER_DOCKER_HOST", Value: "otherdockerhost"},
+					{Name: "MARIADB_HOSTNAME", Value: "mariadbhost"},
+				},
+			},
+			want: map[string]Source{
+				"registryUsername": {Layer: layerEnvironment, Variable: "BUILDER_REGISTRY_USERNAME", Scope: "global"},
+				"registryHost":     {Layer: layerProject, Variable: "BUILDER_REGISTRY_HOST", Scope: "build"},
+				"dockerHost":       {Layer: layerProcess, Variable: "BUILDER_DOCKER_HOST"},
+				"pushTags":         {Layer: layerDefault},
+				"mtk.database":     {Layer: layerEnvironment, Variable: "BUILDER_MTK_DUMP_DATABASE", Scope: "runtime"},
+				"mtk.host":         {Layer: layerProcess, Variable: "MARIADB_HOSTNAME"},
+				"fixedServiceName": {Layer: layerDerived, Detail: "upper cased serviceName with hyphens replaced"},
+			},
+		},
+		{
+			name:        "test2",
+			description: "check feature flags, the json payload, named variables and read replicas",
+			args: args{
+				envVars: []variables.LagoonEnvironmentVariable{
+					{Name: "LAGOON_FEATURE_FLAG_BUILDER_PUSH_TAGS", Value: "latest", Scope: "global"},
+					{Name: "BUILDER_BACKUP_IMAGE_NAME", Value: "${project}/${service}", Scope: "global"},
+					{Name: "MTK_USERNAME_NAME", Value: "DB_USER_CENTRAL", Scope: "global"},
+					{Name: "DB_USER_CENTRAL", Value: "central", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "LAGOON_FEATURE_FLAG_FORCE_BUILDER_IMAGE_DEBUG", Value: "true"},
+					{Name: "JSON_PAYLOAD", Value: genBase64JSONPayload(map[string]string{"BUILDER_REGISTRY_HOST": "payloadhost"})},
+					{Name: "MARIADB_READREPLICA_HOSTS", Value: "rr1,rr2"},
+					{Name: "LAGOON_PROJECT", Value: "lagpro"},
+				},
+			},
+			want: map[string]Source{
+				"pushTags":        {Layer: "feature flag (environment variable)", Variable: "LAGOON_FEATURE_FLAG_BUILDER_PUSH_TAGS", Scope: "global"},
+				"debug":           {Layer: layerFeatureFlagForce, Variable: "LAGOON_FEATURE_FLAG_FORCE_BUILDER_IMAGE_DEBUG"},
+				"registryHost":    {Layer: layerJSONPayload, Variable: "BUILDER_REGISTRY_HOST"},
+				"resultImageName": {Layer: layerEnvironment, Variable: "BUILDER_BACKUP_IMAGE_NAME", Scope: "global", Detail: "parsed from pattern ${project}/${service}"},
+				"mtk.username":    {Layer: layerEnvironment, Variable: "DB_USER_CENTRAL", Scope: "global", Detail: "named by MTK_USERNAME_NAME"},
+				"mtk.host":        {Layer: layerProcess, Variable: "MARIADB_READREPLICA_HOSTS", Detail: "first read replica"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projectvars, _ := json.Marshal(tt.args.projectVars)
+			envvars, _ := json.Marshal(tt.args.envVars)
+			t.Setenv("LAGOON_PROJECT_VARIABLES", string(projectvars))
+			t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+			for _, envVar := range tt.args.setVars {
+				t.Setenv(envVar.Name, envVar.Value)
+			}
+			_, got, err := resolveValues(time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC))
+			if err != nil {
+				t.Fatalf("resolveValues() error = %v", err)
+			}
+			for field, want := range tt.want {
+				if got[field] != want {
+					t.Errorf("resolveValues() source of %s = %v, want %v", field, got[field], want)
+				}
+			}
+		})
+	}
+}
+
+func Test_Explain(t *testing.T) {
+	envvars, _ := json.Marshal([]variables.LagoonEnvironmentVariable{
+		{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+		{Name: "BUILDER_REGISTRY_PASSWORD", Value: "supersecretregpass", Scope: "global"},
+		{Name: "BUILDER_MTK_PASSWORD", Value: "supersecretdbpass", Scope: "global"},
+	})
+	t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+	var out bytes.Buffer
+	if err := Explain(&out); err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	// collapse the column padding so the expected lines don't depend on the width of the table
+	got := strings.Join(strings.Fields(strings.ReplaceAll(out.String(), "\n", " | ")), " ")
+	if strings.Contains(got, "supersecret") {
+		t.Errorf("Explain() leaked a secret:\n%v", got)
+	}
+	for _, want := range []string{
+		"| registryUsername reguser environment variable BUILDER_REGISTRY_USERNAME (scope global) |",
+		"| registryPassword ******** environment variable BUILDER_REGISTRY_PASSWORD (scope global) |",
+		"| mtk.password ******** environment variable BUILDER_MTK_PASSWORD (scope global) |",
+		"| pushTags both default |",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Explain() missing %q in:\n%v", want, got)
+		}
+	}
+}
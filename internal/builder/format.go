@@ -0,0 +1,132 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// the formats that the dump command can output the values in
+const (
+	FormatJSON      = "json"
+	FormatEnv       = "env"
+	FormatDotenv    = "dotenv"
+	FormatBuildArgs = "build-args"
+)
+
+// Formats are all of the supported output formats
+var Formats = []string{FormatJSON, FormatEnv, FormatDotenv, FormatBuildArgs}
+
+// formattedValue is a single value with the variable name it is output as
+type formattedValue struct {
+	Key   string
+	Value string
+}
+
+// writeValues writes the Builder in the requested format
+func writeValues(w io.Writer, build Builder, format string) error {
+	switch format {
+	case FormatJSON, "":
+		b, err := json.Marshal(build)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case FormatEnv:
+		for _, v := range formattedValues(build) {
+			if _, err := fmt.Fprintf(w, "export %s=%s\n", v.Key, shellQuote(v.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatDotenv:
+		for _, v := range formattedValues(build) {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", v.Key, dotenvQuote(v.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatBuildArgs:
+		values := formattedValues(build)
+		// build arg files have no quoting, so a value can't span multiple lines
+		for _, v := range values {
+			if strings.ContainsAny(v.Value, "\r\n") {
+				return fmt.Errorf("the value of %s contains a newline and can't be written as a build arg", v.Key)
+			}
+		}
+		for _, v := range values {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", v.Key, v.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q, must be one of %s", format, strings.Join(Formats, ", "))
+}
+
+// formattedValues walks the Builder in field order, naming each value after its json tag
+// eg `resultImageName` is RESULT_IMAGE_NAME, and `mtk.host` is MTK_HOST
+func formattedValues(build Builder) []formattedValue {
+	values := []formattedValue{}
+	walkFields(reflect.ValueOf(build), "", func(path string, field reflect.StructField, value reflect.Value) {
+		values = append(values, formattedValue{
+			Key:   envName(path),
+			Value: fieldString(value),
+		})
+	})
+	return values
+}
+
+// fieldString formats a field as a string, lists are separated with commas
+func fieldString(value reflect.Value) string {
+	if value.Kind() == reflect.Slice {
+		items := []string{}
+		for i := 0; i < value.Len(); i++ {
+			items = append(items, fmt.Sprintf("%v", value.Index(i).Interface()))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprintf("%v", value.Interface())
+}
+
+// envName converts a json path like `mtk.extendedInsertRows` or `mtkYAML` into an upper case variable name
+func envName(path string) string {
+	var b strings.Builder
+	runes := []rune(path)
+	for i, r := range runes {
+		if r == '.' {
+			b.WriteRune('_')
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// start a new word at the start of a capitalised word, or at the end of an acronym
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// shellQuote single quotes a value so that it is never expanded by the shell, any single quotes in the value
+// are closed, escaped, and reopened
+func shellQuote(value string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", `'\''`))
+}
+
+// dotenvQuote quotes a value for a dotenv file, single quotes are used where possible as most dotenv parsers
+// treat them literally, otherwise double quotes with escapes are used
+func dotenvQuote(value string) string {
+	if !strings.ContainsAny(value, "'\r\n") {
+		return fmt.Sprintf("'%s'", value)
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, `$`, `\$`)
+	return fmt.Sprintf(`"%s"`, replacer.Replace(value))
+}
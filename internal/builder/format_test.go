@@ -0,0 +1,116 @@
+package builder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_envName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "serviceName", want: "SERVICE_NAME"},
+		{path: "resultImageDatabaseName", want: "RESULT_IMAGE_DATABASE_NAME"},
+		{path: "mtkYAML", want: "MTK_YAML"},
+		{path: "mtk.host", want: "MTK_HOST"},
+		{path: "debug", want: "DEBUG"},
+		{path: "dataDirUID", want: "DATA_DIR_UID"},
+		{path: "tlsCAFile", want: "TLS_CA_FILE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := envName(tt.path); got != tt.want {
+				t.Errorf("envName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeValues(t *testing.T) {
+	build := Builder{
+		DockerComposeServiceName: "mariadb",
+		RegistryPassword:         "it's a $secret",
+		MTKYAML:                  "bm9kYXRhOgogIC0gY2FjaGUqCg==",
+		Debug:                    true,
+		MTK:                      MTK{Host: "dbhost"},
+	}
+	tests := []struct {
+		name        string
+		description string
+		build       Builder
+		format      string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:        "test1",
+			description: "check that json is the default",
+			build:       build,
+			format:      "",
+			want:        []string{`{"serviceName":"mariadb",`, `"registryPassword":"it's a $secret",`, `"mtk":{"host":"dbhost",`},
+		},
+		{
+			name:        "test2",
+			description: "check that shell exports are single quoted",
+			build:       build,
+			format:      FormatEnv,
+			want: []string{
+				"export SERVICE_NAME='mariadb'\n",
+				`export REGISTRY_PASSWORD='it'\''s a $secret'` + "\n",
+				"export DEBUG='true'\n",
+				"export MTK_HOST='dbhost'\n",
+			},
+		},
+		{
+			name:        "test3",
+			description: "check that dotenv values fall back to double quotes when they contain a single quote",
+			build:       build,
+			format:      FormatDotenv,
+			want: []string{
+				"SERVICE_NAME='mariadb'\n",
+				`REGISTRY_PASSWORD="it's a \$secret"` + "\n",
+				"MTK_YAML='bm9kYXRhOgogIC0gY2FjaGUqCg=='\n",
+			},
+		},
+		{
+			name:        "test4",
+			description: "check that build args are unquoted",
+			build:       build,
+			format:      FormatBuildArgs,
+			want: []string{
+				"SERVICE_NAME=mariadb\n",
+				"REGISTRY_PASSWORD=it's a $secret\n",
+			},
+		},
+		{
+			name:        "test5",
+			description: "check that build args can't contain newlines",
+			build:       Builder{MTK: MTK{Password: "multi\nline"}},
+			format:      FormatBuildArgs,
+			wantErr:     true,
+		},
+		{
+			name:        "test6",
+			description: "check that unknown formats are an error",
+			build:       build,
+			format:      "yaml",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := writeValues(&out, tt.build, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("writeValues() missing %q in:\n%v", want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package builder
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// presetFiles are the built in sanitisation presets that can be selected with BUILDER_MTK_PRESET, the expressions are
+// run by the database, so there is a directory of presets for each sql dialect
+//
+//go:embed presets/mysql/*.yml presets/postgres/*.yml
+var presetFiles embed.FS
+
+// MTKConfig is the sanitisation config that is provided to MTK in BUILDER_MTK_YAML_BASE64
+// see example.mtk.yml for an example
+type MTKConfig struct {
+	// Rewrite is a map of tables, to a map of columns and the expression to replace their values with
+	Rewrite map[string]map[string]string `yaml:"rewrite,omitempty"`
+	// Where is a map of tables to a condition used to filter which rows are dumped
+	Where map[string]string `yaml:"where,omitempty"`
+	// NoData is a list of tables (or globs) that only have their structure dumped
+	NoData []string `yaml:"nodata,omitempty"`
+	// Ignore is a list of tables (or globs) that are not dumped at all
+	Ignore []string `yaml:"ignore,omitempty"`
+}
+
+// parseMTKConfig decodes and parses a base64 encoded mtk config from a variable, an empty value is an empty config
+func parseMTKConfig(variable, encoded string) (MTKConfig, error) {
+	config := MTKConfig{}
+	if encoded == "" {
+		return config, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return config, fmt.Errorf("unable to decode %s: %v", variable, err)
+	}
+	config, err = decodeMTKConfig(raw)
+	if err != nil {
+		return config, fmt.Errorf("unable to parse %s: %w", variable, err)
+	}
+	return config, nil
+}
+
+// decodeMTKConfig validates and then parses an mtk config
+func decodeMTKConfig(raw []byte) (MTKConfig, error) {
+	config := MTKConfig{}
+	if err := validateMTKYAML(raw); err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// presetDialect returns the directory of presets written in the sql dialect of the database type, mariadb and mysql
+// share the same presets
+func presetDialect(databaseType string) string {
+	if databaseType == "postgres" {
+		return "postgres"
+	}
+	return "mysql"
+}
+
+// Presets returns the names of the built in sanitisation presets for the database type
+func Presets(databaseType string) []string {
+	names := []string{}
+	files, _ := fs.Glob(presetFiles, fmt.Sprintf("presets/%s/*.yml", presetDialect(databaseType)))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(path.Base(file), ".yml"))
+	}
+	return names
+}
+
+// loadPreset parses one of the built in sanitisation presets for the database type
+func loadPreset(databaseType, name string) (MTKConfig, error) {
+	config := MTKConfig{}
+	if !slices.Contains(Presets(databaseType), name) {
+		return config, fmt.Errorf("unknown preset %q for %s, must be one of %s", name, databaseType, strings.Join(Presets(databaseType), ", "))
+	}
+	raw, err := presetFiles.ReadFile(fmt.Sprintf("presets/%s/%s.yml", presetDialect(databaseType), name))
+	if err != nil {
+		return config, err
+	}
+	config, err = decodeMTKConfig(raw)
+	if err != nil {
+		return config, fmt.Errorf("unable to parse preset %s: %w", name, err)
+	}
+	return config, nil
+}
+
+// merge layers another config on top of this one, the columns in rewrite and the tables in where are replaced if
+// they exist in both, and the tables in nodata and ignore are added to the existing ones
+func (c MTKConfig) merge(overlay MTKConfig) MTKConfig {
+	merged := MTKConfig{}
+	for _, config := range []MTKConfig{c, overlay} {
+		for table, columns := range config.Rewrite {
+			if merged.Rewrite == nil {
+				merged.Rewrite = map[string]map[string]string{}
+			}
+			if merged.Rewrite[table] == nil {
+				merged.Rewrite[table] = map[string]string{}
+			}
+			for column, expr := range columns {
+				merged.Rewrite[table][column] = expr
+			}
+		}
+		for table, where := range config.Where {
+			if merged.Where == nil {
+				merged.Where = map[string]string{}
+			}
+			merged.Where[table] = where
+		}
+		for _, table := range config.NoData {
+			if !slices.Contains(merged.NoData, table) {
+				merged.NoData = append(merged.NoData, table)
+			}
+		}
+		for _, table := range config.Ignore {
+			if !slices.Contains(merged.Ignore, table) {
+				merged.Ignore = append(merged.Ignore, table)
+			}
+		}
+	}
+	return merged
+}
+
+// mtkConfig returns the sanitisation config for the build, each of these is layered on top of the previous one:
+//   - the preset from BUILDER_MTK_PRESET
+//   - BUILDER_MTK_YAML_BASE64, where an environment variable replaces a project variable as usual
+//   - BUILDER_MTK_YAML_EXTRA_BASE64 from the project, then the environment, and then the task
+func (b Builder) mtkConfig() (MTKConfig, error) {
+	config := MTKConfig{}
+	if b.MTKPreset != "" {
+		preset, err := loadPreset(b.DatabaseType, b.MTKPreset)
+		if err != nil {
+			return config, err
+		}
+		config = preset
+	}
+	for _, layer := range b.mtkConfigLayers() {
+		overlay, err := parseMTKConfig(layer.Variable, layer.Value)
+		if err != nil {
+			return config, err
+		}
+		config = config.merge(overlay)
+	}
+	return config, nil
+}
+
+// mtkConfigLayer is one of the base64 encoded mtk configs, and the variable that it came from
+type mtkConfigLayer struct {
+	Field    string
+	Variable string
+	Value    string
+}
+
+// mtkConfigLayers returns the base64 encoded mtk configs in the order they are layered
+func (b Builder) mtkConfigLayers() []mtkConfigLayer {
+	layers := []mtkConfigLayer{{Field: "mtkYAML", Variable: "BUILDER_MTK_YAML_BASE64", Value: b.MTKYAML}}
+	for _, extra := range b.MTKYAMLExtra {
+		layers = append(layers, mtkConfigLayer{Field: "mtkYAMLExtra", Variable: "BUILDER_MTK_YAML_EXTRA_BASE64", Value: extra})
+	}
+	return layers
+}
+
+// marshal writes the config as yaml in the format mtk reads
+func (c MTKConfig) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// matchesTable checks if a table name matches any of the table names or globs in a list
+func matchesTable(patterns []string, table string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, table); matched {
+			return true
+		}
+	}
+	return false
+}
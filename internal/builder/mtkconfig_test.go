@@ -0,0 +1,163 @@
+package builder
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_loadPreset(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		databaseType string
+		want         []string
+	}{
+		{
+			name:         "test1",
+			description:  "check the mariadb presets",
+			databaseType: "mariadb",
+			want:         []string{"drupal7", "drupal8+", "laravel", "magento", "wordpress"},
+		},
+		{
+			name:         "test2",
+			description:  "check mysql has the same presets as mariadb",
+			databaseType: "mysql",
+			want:         []string{"drupal7", "drupal8+", "laravel", "magento", "wordpress"},
+		},
+		{
+			name:         "test3",
+			description:  "check postgres only has the presets for applications that run on postgres",
+			databaseType: "postgres",
+			want:         []string{"drupal7", "drupal8+", "laravel"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Presets(tt.databaseType); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Presets() = %v, want %v", got, tt.want)
+			}
+			for _, name := range tt.want {
+				config, err := loadPreset(tt.databaseType, name)
+				if err != nil {
+					t.Fatalf("loadPreset(%s) error = %v", name, err)
+				}
+				if len(config.Rewrite) == 0 || len(config.NoData) == 0 {
+					t.Errorf("loadPreset(%s) = %v, want rewrite and nodata tables", name, config)
+				}
+				// double quotes are identifiers in postgres, so the literals have to be single quoted
+				for table, columns := range config.Rewrite {
+					for column, expr := range columns {
+						if tt.databaseType == "postgres" && strings.Contains(expr, `"`) {
+							t.Errorf("loadPreset(%s) %s.%s = %s, want single quoted literals", name, table, column, expr)
+						}
+					}
+				}
+			}
+			if _, err := loadPreset(tt.databaseType, "joomla"); err == nil {
+				t.Errorf("loadPreset() expected an error for an unknown preset")
+			}
+		})
+	}
+	if _, err := loadPreset("postgres", "wordpress"); err == nil {
+		t.Errorf("loadPreset() expected an error for a preset that isn't written for postgres")
+	}
+}
+
+func Test_Builder_mtkConfig(t *testing.T) {
+	encode := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name        string
+		description string
+		build       Builder
+		want        MTKConfig
+		wantErr     bool
+	}{
+		{
+			name:        "test1",
+			description: "check that the mtk config is used as is without a preset",
+			build:       Builder{MTKYAML: encode("nodata:\n  - cache*\n")},
+			want:        MTKConfig{NoData: []string{"cache*"}},
+		},
+		{
+			name:        "test2",
+			description: "check that the mtk config is layered on top of the preset",
+			build: Builder{
+				MTKPreset: "laravel",
+				MTKYAML: encode(`rewrite:
+  users:
+    email: concat(id, "@example.com")
+    name: '"Sanitised"'
+where:
+  orders: created_at > NOW() - INTERVAL 1 YEAR
+nodata:
+  - sessions
+  - audits
+`),
+			},
+			want: MTKConfig{
+				Rewrite: map[string]map[string]string{
+					"users": {
+						"email":          `concat(id, "@example.com")`,
+						"password":       `"SANITIZED_PASSWORD"`,
+						"remember_token": "NULL",
+						"name":           `"Sanitised"`,
+					},
+				},
+				Where: map[string]string{"orders": "created_at > NOW() - INTERVAL 1 YEAR"},
+				NoData: []string{
+					"cache", "cache_locks", "sessions", "jobs", "job_batches", "failed_jobs", "password_resets",
+					"password_reset_tokens", "personal_access_tokens", "telescope_*", "audits",
+				},
+			},
+		},
+		{
+			name:        "test3",
+			description: "check that the extra configs are layered on top in order",
+			build: Builder{
+				MTKYAML: encode("rewrite:\n  users:\n    mail: '\"project\"'\nnodata:\n  - cache*\n"),
+				MTKYAMLExtra: []string{
+					encode("rewrite:\n  users:\n    mail: '\"environment\"'\nnodata:\n  - history\n"),
+					encode("ignore:\n  - watchdog\n"),
+				},
+			},
+			want: MTKConfig{
+				Rewrite: map[string]map[string]string{"users": {"mail": `"environment"`}},
+				NoData:  []string{"cache*", "history"},
+				Ignore:  []string{"watchdog"},
+			},
+		},
+		{
+			name:        "test4",
+			description: "check that an unknown preset is an error",
+			build:       Builder{MTKPreset: "joomla"},
+			wantErr:     true,
+		},
+		{
+			name:        "test5",
+			description: "check that invalid yaml is an error",
+			build:       Builder{MTKPreset: "drupal7", MTKYAML: encode("nodata: [")},
+			wantErr:     true,
+		},
+		{
+			name:        "test6",
+			description: "check that invalid yaml in an extra config is an error",
+			build:       Builder{MTKYAMLExtra: []string{encode("nodata: [")}},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build.mtkConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mtkConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mtkConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mtkConfigKeys are the top level keys that mtk reads from its config
+var mtkConfigKeys = []string{"rewrite", "where", "nodata", "ignore"}
+
+// MTKConfigError is a single problem in the mtk config, with the line it was found on
+type MTKConfigError struct {
+	Line    int
+	Message string
+}
+
+func (e *MTKConfigError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// MTKConfigErrors collects all of the problems found in the mtk config
+type MTKConfigErrors []*MTKConfigError
+
+func (e MTKConfigErrors) Error() string {
+	msgs := []string{}
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validateMTKYAML checks the structure of the mtk config before it is parsed, so that mistakes are found before
+// connecting to the database rather than part way through the dump, every problem is returned with its line number
+func validateMTKYAML(raw []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	// an empty document is an empty config
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	errs := MTKConfigErrors{}
+	add := func(node *yaml.Node, format string, a ...any) {
+		errs = append(errs, &MTKConfigError{Line: node.Line, Message: fmt.Sprintf(format, a...)})
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		add(root, "the config must be a map of %s", strings.Join(mtkConfigKeys, ", "))
+		return errs
+	}
+	forEachKey(root, add, func(key, value *yaml.Node) {
+		// a key with no value is the same as leaving it out
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" && slices.Contains(mtkConfigKeys, key.Value) {
+			return
+		}
+		switch key.Value {
+		case "rewrite":
+			if !isKind(value, yaml.MappingNode, add, "rewrite must be a map of tables to columns") {
+				return
+			}
+			forEachKey(value, add, func(table, columns *yaml.Node) {
+				if !isKind(columns, yaml.MappingNode, add, "rewrite for %s must be a map of columns to expressions", table.Value) {
+					return
+				}
+				forEachKey(columns, add, func(column, expr *yaml.Node) {
+					name := fmt.Sprintf("%s.%s", table.Value, column.Value)
+					if isKind(expr, yaml.ScalarNode, add, "rewrite for %s must be an expression", name) && strings.TrimSpace(expr.Value) == "" {
+						add(expr, "rewrite expression for %s is empty", name)
+					}
+				})
+			})
+		case "where":
+			if !isKind(value, yaml.MappingNode, add, "where must be a map of tables to conditions") {
+				return
+			}
+			forEachKey(value, add, func(table, condition *yaml.Node) {
+				if isKind(condition, yaml.ScalarNode, add, "where for %s must be a condition", table.Value) && strings.TrimSpace(condition.Value) == "" {
+					add(condition, "where condition for %s is empty", table.Value)
+				}
+			})
+		case "nodata", "ignore":
+			if !isKind(value, yaml.SequenceNode, add, "%s must be a list of tables", key.Value) {
+				return
+			}
+			seen := map[string]int{}
+			for _, table := range value.Content {
+				if !isKind(table, yaml.ScalarNode, add, "%s must be a list of tables", key.Value) {
+					continue
+				}
+				switch {
+				case strings.TrimSpace(table.Value) == "":
+					add(table, "%s contains an empty table name", key.Value)
+				case seen[table.Value] != 0:
+					add(table, "%s contains %s more than once, it was first listed on line %d", key.Value, table.Value, seen[table.Value])
+				default:
+					if _, err := path.Match(table.Value, ""); err != nil {
+						add(table, "%s contains an invalid glob %s", key.Value, table.Value)
+					}
+					seen[table.Value] = table.Line
+				}
+			}
+		default:
+			add(key, "unknown key %s, must be one of %s", key.Value, strings.Join(mtkConfigKeys, ", "))
+		}
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// forEachKey calls fn with each of the keys and values in a map, reporting any key that is repeated
+func forEachKey(node *yaml.Node, add func(*yaml.Node, string, ...any), fn func(key, value *yaml.Node)) {
+	seen := map[string]int{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if line, ok := seen[key.Value]; ok {
+			add(key, "%s is defined more than once, it was first defined on line %d", key.Value, line)
+			continue
+		}
+		seen[key.Value] = key.Line
+		fn(key, value)
+	}
+}
+
+// isKind checks that a node is of the expected kind, reporting the message if it isn't
+func isKind(node *yaml.Node, kind yaml.Kind, add func(*yaml.Node, string, ...any), format string, a ...any) bool {
+	if node.Kind != kind {
+		add(node, format, a...)
+		return false
+	}
+	return true
+}
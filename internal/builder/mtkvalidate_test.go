@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_validateMTKYAML(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		yaml        string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:        "test1",
+			description: "check that an empty config is valid",
+			yaml:        "",
+		},
+		{
+			name:        "test2",
+			description: "check that keys with no value are valid",
+			yaml:        "nodata:\nignore:\n",
+		},
+		{
+			name:        "test3",
+			description: "check that every problem is reported with its line number",
+			yaml: `rewrite:
+  users:
+    mail: ""
+    pass: '"SANITIZED_PASSWORD"'
+    pass: '"SANITIZED"'
+  sessions: truncate
+where:
+  node_revision__body: " "
+nodata:
+  - cache*
+  - cache[
+  - cache*
+ignore: watchdog
+exclude:
+  - history
+`,
+			want: []string{
+				"line 3: rewrite expression for users.mail is empty",
+				"line 5: pass is defined more than once, it was first defined on line 4",
+				"line 6: rewrite for sessions must be a map of columns to expressions",
+				`line 8: where condition for node_revision__body is empty`,
+				"line 11: nodata contains an invalid glob cache[",
+				"line 12: nodata contains cache* more than once, it was first listed on line 10",
+				"line 13: ignore must be a list of tables",
+				"line 14: unknown key exclude, must be one of rewrite, where, nodata, ignore",
+			},
+		},
+		{
+			name:        "test4",
+			description: "check that a config that isn't a map is reported",
+			yaml:        "- cache*\n",
+			want:        []string{"line 1: the config must be a map of rewrite, where, nodata, ignore"},
+		},
+		{
+			name:        "test5",
+			description: "check that yaml syntax errors are returned",
+			yaml:        "nodata: [\n",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMTKYAML([]byte(tt.yaml))
+			var got []string
+			var configErrs MTKConfigErrors
+			if errors.As(err, &configErrs) {
+				for _, configErr := range configErrs {
+					got = append(got, configErr.Error())
+				}
+			} else if (err != nil) != tt.wantErr {
+				t.Fatalf("validateMTKYAML() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateMTKYAML() = \n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateMTKYAML_example(t *testing.T) {
+	raw, err := os.ReadFile("../../example.mtk.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateMTKYAML(raw); err != nil {
+		t.Errorf("validateMTKYAML() example.mtk.yml error = %v", err)
+	}
+}
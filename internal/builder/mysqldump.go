@@ -0,0 +1,600 @@
+package builder
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// defaultExtendedInsertRows is how many rows are put in each INSERT if BUILDER_MTK_EXTENDED_INSERT_ROWS isn't set
+	defaultExtendedInsertRows = 1000
+	// maxInsertSize stops an INSERT growing past the size of a packet that the server will accept, no matter how many
+	// rows it has, it is well under the default max_allowed_packet
+	maxInsertSize = 1 << 20
+	// progressRows is how often the progress of a table is reported while its rows are dumped
+	progressRows = 100000
+	// mysqlColumnsQuery reads the columns of a table that have their values dumped, generated columns are left out as
+	// their values can't be inserted
+	mysqlColumnsQuery = "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND EXTRA NOT IN ('VIRTUAL GENERATED', 'STORED GENERATED', 'VIRTUAL', 'PERSISTENT') ORDER BY ORDINAL_POSITION"
+	// mysqlTriggersQuery reads the triggers of a table in the order that they fire
+	mysqlTriggersQuery = "SELECT TRIGGER_NAME FROM information_schema.TRIGGERS WHERE TRIGGER_SCHEMA = DATABASE() AND EVENT_OBJECT_TABLE = ? ORDER BY ACTION_ORDER"
+	// mysqlRoutinesQuery reads the stored procedures, functions and events
+	mysqlRoutinesQuery = "SELECT ROUTINE_TYPE, ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = DATABASE() UNION ALL SELECT 'EVENT', EVENT_NAME FROM information_schema.EVENTS WHERE EVENT_SCHEMA = DATABASE()"
+	// mysqlTimeZone is the time zone of the session that the dump is read in, and is set at the start of the dump, so
+	// that TIMESTAMP values are imported as they were read no matter the time zone of either server
+	mysqlTimeZone = "+00:00"
+	// mysqlSQLMode is the sql mode of the import, each routine is created with its own sql mode and then it is set back
+	mysqlSQLMode = "NO_AUTO_VALUE_ON_ZERO"
+)
+
+// mysqlNumericTypes are the column types that are written without quotes, UNSIGNED is removed from the type first
+var mysqlNumericTypes = []string{"TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR"}
+
+// mysqlBinaryTypes are the column types that are written as hex, so that the bytes aren't changed by the character set
+// of the import
+var mysqlBinaryTypes = []string{"BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY"}
+
+// mysqlDump produces a sanitised dump of a mariadb or mysql database in the task itself, applying the mtk config in the
+// same way as mtk:
+//   - ignore tables are not dumped at all
+//   - nodata tables only have their structure dumped
+//   - rewrite and where tables have their rows selected with the rewritten columns and condition
+//
+// the tables are read in a single read only transaction with a consistent snapshot, so the dump is consistent for innodb
+// tables without locking them, the triggers of each table are dumped after its rows so that they don't fire during the
+// import, and each view is dumped as a placeholder table in its place and then created after all of the tables, in the
+// order that they select from each other
+//
+// stored procedures, functions and events are dumped last, any that the user isn't allowed to read are reported with a
+// skipped event instead
+type mysqlDump struct {
+	DB     *sql.DB
+	Config MTKConfig
+	// ExtendedInsertRows is how many rows are put in each INSERT
+	ExtendedInsertRows int
+	// Progress is called as each table is dumped, it is optional
+	Progress func(dumpEvent)
+}
+
+// dumpEventKind is what has happened to a table during the dump
+type dumpEventKind string
+
+const (
+	dumpIgnored   dumpEventKind = "ignored"
+	dumpStructure dumpEventKind = "structure"
+	dumpRows      dumpEventKind = "rows"
+	dumpDone      dumpEventKind = "done"
+	dumpView      dumpEventKind = "view"
+	dumpRoutine   dumpEventKind = "routine"
+	dumpSkipped   dumpEventKind = "skipped"
+)
+
+// dumpEvent is reported as each table is dumped, so that the progress of a large database can be followed
+type dumpEvent struct {
+	Table string
+	Kind  dumpEventKind
+	// Rows is how many rows of the table have been dumped so far
+	Rows int64
+	// Sanitised is true if the rows were rewritten or filtered
+	Sanitised bool
+	// Triggers is how many triggers of the table were dumped
+	Triggers int
+	// Type is the type of a routine, eg PROCEDURE
+	Type string
+}
+
+func (e dumpEvent) String() string {
+	var done string
+	switch e.Kind {
+	case dumpIgnored:
+		return fmt.Sprintf("%s: ignored", e.Table)
+	case dumpRows:
+		return fmt.Sprintf("%s: %d rows so far", e.Table, e.Rows)
+	case dumpView:
+		return fmt.Sprintf("%s: view", e.Table)
+	case dumpRoutine:
+		return fmt.Sprintf("%s: %s", e.Table, strings.ToLower(e.Type))
+	case dumpSkipped:
+		return fmt.Sprintf("%s: %s not dumped, the user isn't allowed to read it", e.Table, strings.ToLower(e.Type))
+	case dumpStructure:
+		done = fmt.Sprintf("%s: structure only", e.Table)
+	default:
+		done = fmt.Sprintf("%s: %d rows", e.Table, e.Rows)
+		if e.Sanitised {
+			done = fmt.Sprintf("%s: %d sanitised rows", e.Table, e.Rows)
+		}
+	}
+	if e.Triggers > 0 {
+		return fmt.Sprintf("%s, %d triggers", done, e.Triggers)
+	}
+	return done
+}
+
+// dumpError is returned when dumping a table fails, with the statement that the database rejected
+type dumpError struct {
+	Table string
+	Query string
+	Err   error
+}
+
+func (e *dumpError) Error() string {
+	return fmt.Sprintf("unable to dump %s: %v (%s)", e.Table, e.Err, e.Query)
+}
+
+func (e *dumpError) Unwrap() error {
+	return e.Err
+}
+
+// mysqlTable is a table or view in the database being dumped
+type mysqlTable struct {
+	Name string
+	View bool
+}
+
+func quoteMySQLIdentifier(name string) string {
+	return fmt.Sprintf("`%s`", strings.ReplaceAll(name, "`", "``"))
+}
+
+// quoteMySQLLiteral quotes a string in the same way as mysql_real_escape_string, the dump sets a sql mode without
+// NO_BACKSLASH_ESCAPES so that the escapes are read back in
+func quoteMySQLLiteral(value []byte) string {
+	var b strings.Builder
+	b.Grow(len(value) + 2)
+	b.WriteByte('\'')
+	for _, c := range value {
+		switch c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case 0x1a:
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// mysqlValue writes a value read from the database as a literal for its column type
+func mysqlValue(value sql.RawBytes, columnType string) string {
+	columnType = strings.TrimPrefix(columnType, "UNSIGNED ")
+	switch {
+	case value == nil:
+		return "NULL"
+	case slices.Contains(mysqlNumericTypes, columnType):
+		return string(value)
+	case slices.Contains(mysqlBinaryTypes, columnType) && len(value) > 0:
+		return "0x" + hex.EncodeToString(value)
+	}
+	return quoteMySQLLiteral(value)
+}
+
+func (d mysqlDump) progress(e dumpEvent) {
+	if d.Progress != nil {
+		d.Progress(e)
+	}
+}
+
+// queryStrings runs a query that returns a single column of strings
+func (d mysqlDump) queryStrings(ctx context.Context, conn *sql.Conn, table, query string, args ...any) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, &dumpError{Table: table, Query: query, Err: err}
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, &dumpError{Table: table, Query: query, Err: err}
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &dumpError{Table: table, Query: query, Err: err}
+	}
+	return values, nil
+}
+
+func (d mysqlDump) tables(ctx context.Context, conn *sql.Conn) ([]mysqlTable, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW FULL TABLES")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the tables: %w", err)
+	}
+	defer rows.Close()
+	tables := []mysqlTable{}
+	for rows.Next() {
+		var name, tableType string
+		if err := rows.Scan(&name, &tableType); err != nil {
+			return nil, err
+		}
+		tables = append(tables, mysqlTable{Name: name, View: tableType == "VIEW"})
+	}
+	return tables, rows.Err()
+}
+
+// columns returns the columns of a table that have their values dumped, a rewritten column that isn't one of them is
+// an error, so that a misspelt column can't leave the real values in the dump
+func (d mysqlDump) columns(ctx context.Context, conn *sql.Conn, t mysqlTable) ([]string, error) {
+	columns, err := d.queryStrings(ctx, conn, t.Name, mysqlColumnsQuery, t.Name)
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for column := range d.Config.Rewrite[t.Name] {
+		if !slices.Contains(columns, column) {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, &dumpError{Table: t.Name, Query: mysqlColumnsQuery, Err: fmt.Errorf(
+			"the rewritten columns %s don't exist or are generated columns", strings.Join(missing, ", "))}
+	}
+	return columns, nil
+}
+
+// createStatement returns the CREATE TABLE or CREATE VIEW statement, which is the second column of SHOW CREATE
+func (d mysqlDump) createStatement(ctx context.Context, conn *sql.Conn, t mysqlTable) (string, error) {
+	query := fmt.Sprintf("SHOW CREATE TABLE %s", quoteMySQLIdentifier(t.Name))
+	if t.View {
+		query = fmt.Sprintf("SHOW CREATE VIEW %s", quoteMySQLIdentifier(t.Name))
+	}
+	return d.showCreate(ctx, conn, t.Name, query, 1)
+}
+
+// showCreate returns the statement in the column of the result of a SHOW CREATE query
+func (d mysqlDump) showCreate(ctx context.Context, conn *sql.Conn, table, query string, column int) (string, error) {
+	values, err := d.showCreateRow(ctx, conn, table, query, column)
+	if err != nil {
+		return "", err
+	}
+	return values[column], nil
+}
+
+// showCreateRow returns the row of the result of a SHOW CREATE query, which has to have at least column + 1 columns,
+// NULL values are returned as empty strings
+func (d mysqlDump) showCreateRow(ctx context.Context, conn *sql.Conn, table, query string, column int) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &dumpError{Table: table, Query: query, Err: err}
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, &dumpError{Table: table, Query: query, Err: err}
+	}
+	if !rows.Next() || len(columns) <= column {
+		if err := rows.Err(); err != nil {
+			return nil, &dumpError{Table: table, Query: query, Err: err}
+		}
+		return nil, &dumpError{Table: table, Query: query, Err: fmt.Errorf("no create statement was returned")}
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, &dumpError{Table: table, Query: query, Err: err}
+	}
+	row := make([]string, len(values))
+	for i, value := range values {
+		row[i] = string(value)
+	}
+	return row, nil
+}
+
+// writeTriggers writes the triggers of a table, returning how many there are
+func (d mysqlDump) writeTriggers(ctx context.Context, conn *sql.Conn, w *bufio.Writer, t mysqlTable) (int, error) {
+	triggers, err := d.queryStrings(ctx, conn, t.Name, mysqlTriggersQuery, t.Name)
+	if err != nil || len(triggers) == 0 {
+		return 0, err
+	}
+	fmt.Fprintf(w, "--\n-- Triggers for %s\n--\n\n", quoteMySQLIdentifier(t.Name))
+	for _, trigger := range triggers {
+		// the statement is the third column, after the name and sql mode of the trigger
+		create, err := d.showCreate(ctx, conn, t.Name, fmt.Sprintf("SHOW CREATE TRIGGER %s", quoteMySQLIdentifier(trigger)), 2)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(w, "DROP TRIGGER IF EXISTS %s;\nDELIMITER ;;\n%s;;\nDELIMITER ;\n\n", quoteMySQLIdentifier(trigger), create)
+	}
+	return len(triggers), nil
+}
+
+// mysqlRoutineCreateColumns is the column of SHOW CREATE that has the statement for each type of routine, it is after
+// the time zone for events
+var mysqlRoutineCreateColumns = map[string]int{"PROCEDURE": 2, "FUNCTION": 2, "EVENT": 3}
+
+// mysqlAccessDenied are the errors returned when the user can't read the definition of a routine
+var mysqlAccessDenied = []uint16{1044, 1142, 1227, 1370}
+
+// writeRoutines writes the stored procedures, functions and events, any that the user can't read the definition of are
+// reported with a skipped event instead
+func (d mysqlDump) writeRoutines(ctx context.Context, conn *sql.Conn, w *bufio.Writer) error {
+	rows, err := conn.QueryContext(ctx, mysqlRoutinesQuery)
+	if err != nil {
+		return &dumpError{Table: "routines", Query: mysqlRoutinesQuery, Err: err}
+	}
+	defer rows.Close()
+	routines := [][2]string{}
+	for rows.Next() {
+		var routineType, name string
+		if err := rows.Scan(&routineType, &name); err != nil {
+			return &dumpError{Table: "routines", Query: mysqlRoutinesQuery, Err: err}
+		}
+		routines = append(routines, [2]string{routineType, name})
+	}
+	if err := rows.Err(); err != nil {
+		return &dumpError{Table: "routines", Query: mysqlRoutinesQuery, Err: err}
+	}
+	// the rows are closed before the definitions are read, as the connection can only run one query at a time
+	rows.Close()
+	for _, routine := range routines {
+		routineType, name := routine[0], routine[1]
+		column := mysqlRoutineCreateColumns[routineType]
+		query := fmt.Sprintf("SHOW CREATE %s %s", routineType, quoteMySQLIdentifier(name))
+		row, err := d.showCreateRow(ctx, conn, name, query, column)
+		var mysqlErr *mysql.MySQLError
+		// the statement is NULL rather than an error if the user can see the routine but didn't create it
+		if errors.As(err, &mysqlErr) && slices.Contains(mysqlAccessDenied, mysqlErr.Number) || err == nil && row[column] == "" {
+			d.progress(dumpEvent{Table: name, Kind: dumpSkipped, Type: routineType})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// the routine is created with the sql mode it was created with, which is the second column
+		fmt.Fprintf(w, "--\n-- Definition of %s %s\n--\n\n", strings.ToLower(routineType), quoteMySQLIdentifier(name))
+		fmt.Fprintf(w, "DROP %s IF EXISTS %s;\nSET SQL_MODE = %s;\nDELIMITER ;;\n%s;;\nDELIMITER ;\nSET SQL_MODE = '%s';\n\n",
+			routineType, quoteMySQLIdentifier(name), quoteMySQLLiteral([]byte(row[1])), row[column], mysqlSQLMode)
+		d.progress(dumpEvent{Table: name, Kind: dumpRoutine, Type: routineType})
+	}
+	return nil
+}
+
+// writeViewPlaceholder writes a table with the columns of a view in its place, so that views that select from it can
+// be created before it, it is replaced by the view once all of the tables and views exist
+func (d mysqlDump) writeViewPlaceholder(ctx context.Context, conn *sql.Conn, w *bufio.Writer, t mysqlTable) error {
+	columns, err := d.queryStrings(ctx, conn, t.Name, mysqlColumnsQuery, t.Name)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return &dumpError{Table: t.Name, Query: mysqlColumnsQuery, Err: fmt.Errorf("the view has no columns, it may select from a table that doesn't exist")}
+	}
+	placeholders := []string{}
+	for _, column := range columns {
+		placeholders = append(placeholders, fmt.Sprintf("  %s tinyint NOT NULL", quoteMySQLIdentifier(column)))
+	}
+	fmt.Fprintf(w, "--\n-- Temporary table structure for view %s\n--\n\n", quoteMySQLIdentifier(t.Name))
+	fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\nDROP VIEW IF EXISTS %s;\nCREATE TABLE %s (\n%s\n);\n\n",
+		quoteMySQLIdentifier(t.Name), quoteMySQLIdentifier(t.Name), quoteMySQLIdentifier(t.Name), strings.Join(placeholders, ",\n"))
+	return nil
+}
+
+// orderViews returns the views with each one after any of the other views that its create statement selects from, a
+// view that is part of a loop is left where it was found
+func orderViews(views []mysqlTable, creates map[string]string) []mysqlTable {
+	ordered := []mysqlTable{}
+	visited := map[string]bool{}
+	var visit func(t mysqlTable)
+	visit = func(t mysqlTable) {
+		if visited[t.Name] {
+			return
+		}
+		visited[t.Name] = true
+		for _, other := range views {
+			if other.Name != t.Name && strings.Contains(creates[t.Name], quoteMySQLIdentifier(other.Name)) {
+				visit(other)
+			}
+		}
+		ordered = append(ordered, t)
+	}
+	for _, t := range views {
+		visit(t)
+	}
+	return ordered
+}
+
+// sanitisedSelect returns the SELECT used to read the rows of a table, with any of the rewritten columns and condition
+func (d mysqlDump) sanitisedSelect(t mysqlTable, columns []string) string {
+	rewrites := d.Config.Rewrite[t.Name]
+	selects := []string{}
+	for _, column := range columns {
+		if expr, ok := rewrites[column]; ok {
+			selects = append(selects, fmt.Sprintf("(%s) AS %s", expr, quoteMySQLIdentifier(column)))
+			continue
+		}
+		selects = append(selects, quoteMySQLIdentifier(column))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), quoteMySQLIdentifier(t.Name))
+	if where, ok := d.Config.Where[t.Name]; ok {
+		query = fmt.Sprintf("%s WHERE %s", query, where)
+	}
+	return query
+}
+
+// writeRows writes the rows of a table as INSERTs of up to ExtendedInsertRows rows each
+func (d mysqlDump) writeRows(ctx context.Context, conn *sql.Conn, w *bufio.Writer, t mysqlTable) error {
+	columns, err := d.columns(ctx, conn, t)
+	if err != nil {
+		return err
+	}
+	_, rewritten := d.Config.Rewrite[t.Name]
+	_, filtered := d.Config.Where[t.Name]
+	event := dumpEvent{Table: t.Name, Kind: dumpDone, Sanitised: rewritten || filtered}
+	if len(columns) == 0 {
+		event.Triggers, err = d.writeTriggers(ctx, conn, w, t)
+		d.progress(event)
+		return err
+	}
+	query := d.sanitisedSelect(t, columns)
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return &dumpError{Table: t.Name, Query: query, Err: err}
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return &dumpError{Table: t.Name, Query: query, Err: err}
+	}
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, quoteMySQLIdentifier(column))
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", quoteMySQLIdentifier(t.Name), strings.Join(quoted, ", "))
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	fmt.Fprintf(w, "LOCK TABLES %s WRITE;\n", quoteMySQLIdentifier(t.Name))
+	var statement strings.Builder
+	statementRows := 0
+	flush := func() {
+		if statementRows > 0 {
+			w.WriteString(statement.String())
+			w.WriteString(";\n")
+		}
+		statement.Reset()
+		statementRows = 0
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return &dumpError{Table: t.Name, Query: query, Err: err}
+		}
+		literals := make([]string, len(values))
+		for i, value := range values {
+			literals[i] = mysqlValue(value, types[i].DatabaseTypeName())
+		}
+		if statementRows == 0 {
+			statement.WriteString(insert)
+		} else {
+			statement.WriteString(",")
+		}
+		fmt.Fprintf(&statement, "(%s)", strings.Join(literals, ","))
+		statementRows++
+		event.Rows++
+		if statementRows >= d.ExtendedInsertRows || statement.Len() >= maxInsertSize {
+			flush()
+		}
+		if event.Rows%progressRows == 0 {
+			d.progress(dumpEvent{Table: t.Name, Kind: dumpRows, Rows: event.Rows})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return &dumpError{Table: t.Name, Query: query, Err: err}
+	}
+	flush()
+	fmt.Fprint(w, "UNLOCK TABLES;\n\n")
+	// the rows are closed before the triggers are read, as the transaction can only run one query at a time
+	rows.Close()
+	if event.Triggers, err = d.writeTriggers(ctx, conn, w, t); err != nil {
+		return err
+	}
+	d.progress(event)
+	return nil
+}
+
+// Dump writes the sanitised dump
+func (d mysqlDump) Dump(ctx context.Context, out io.Writer) error {
+	if d.ExtendedInsertRows < 1 {
+		d.ExtendedInsertRows = defaultExtendedInsertRows
+	}
+	// the dump runs on a single connection, as the time zone and the transaction are part of its session
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start the dump: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET time_zone = '%s'", mysqlTimeZone)); err != nil {
+		return fmt.Errorf("unable to set the time zone of the dump: %w", err)
+	}
+	// the snapshot is taken when the transaction starts rather than at its first read, in the same way as
+	// mysqldump --single-transaction, so every table is read as it was at the same moment
+	for _, statement := range []string{
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+	} {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("unable to start the dump: %w", err)
+		}
+	}
+	// the transaction only reads, so it is always rolled back
+	defer conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+	tables, err := d.tables(ctx, conn)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "SET NAMES utf8mb4;\nSET TIME_ZONE = '%s';\nSET FOREIGN_KEY_CHECKS = 0;\nSET UNIQUE_CHECKS = 0;\nSET SQL_MODE = '%s';\n\n", mysqlTimeZone, mysqlSQLMode)
+	views := []mysqlTable{}
+	for _, t := range tables {
+		switch {
+		case matchesTable(d.Config.Ignore, t.Name):
+			d.progress(dumpEvent{Table: t.Name, Kind: dumpIgnored})
+			continue
+		case t.View:
+			if err := d.writeViewPlaceholder(ctx, conn, w, t); err != nil {
+				return err
+			}
+			views = append(views, t)
+			continue
+		}
+		create, err := d.createStatement(ctx, conn, t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "--\n-- Table structure for %s\n--\n\n", quoteMySQLIdentifier(t.Name))
+		fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n%s;\n\n", quoteMySQLIdentifier(t.Name), create)
+		if matchesTable(d.Config.NoData, t.Name) {
+			triggers, err := d.writeTriggers(ctx, conn, w, t)
+			if err != nil {
+				return err
+			}
+			d.progress(dumpEvent{Table: t.Name, Kind: dumpStructure, Triggers: triggers})
+			continue
+		}
+		fmt.Fprintf(w, "--\n-- Data for %s\n--\n\n", quoteMySQLIdentifier(t.Name))
+		if err := d.writeRows(ctx, conn, w, t); err != nil {
+			return err
+		}
+	}
+	creates := map[string]string{}
+	for _, t := range views {
+		if creates[t.Name], err = d.createStatement(ctx, conn, t); err != nil {
+			return err
+		}
+	}
+	for _, t := range orderViews(views, creates) {
+		fmt.Fprintf(w, "--\n-- View structure for %s\n--\n\n", quoteMySQLIdentifier(t.Name))
+		fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\nDROP VIEW IF EXISTS %s;\n%s;\n\n", quoteMySQLIdentifier(t.Name), quoteMySQLIdentifier(t.Name), creates[t.Name])
+		d.progress(dumpEvent{Table: t.Name, Kind: dumpView})
+	}
+	if err := d.writeRoutines(ctx, conn, w); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "SET FOREIGN_KEY_CHECKS = 1;\nSET UNIQUE_CHECKS = 1;\n")
+	return w.Flush()
+}
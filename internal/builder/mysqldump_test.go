@@ -0,0 +1,436 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// fakeDatabase is a database/sql driver that records the queries it is asked to run, answering each one with the
+// result of the first key that it contains, and failing any query that contains one of the keys in failures or errs
+// the arguments of a query are recorded after it in brackets, so that they can be matched as well
+type fakeDatabase struct {
+	mu       sync.Mutex
+	queries  []string
+	results  map[string]fakeResult
+	failures map[string]string
+	// errs are returned as they are, eg a *mysql.MySQLError
+	errs map[string]error
+}
+
+// fakeResult is the columns, their database types and the rows returned for a query
+type fakeResult struct {
+	columns []string
+	types   []string
+	rows    [][]driver.Value
+}
+
+func (f *fakeDatabase) open(ctx context.Context) (*sql.DB, error) {
+	return sql.OpenDB(fakeConnector{db: f}), nil
+}
+
+func (f *fakeDatabase) run(query string, args []driver.NamedValue) (fakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(args) > 0 {
+		values := []string{}
+		for _, arg := range args {
+			values = append(values, fmt.Sprint(arg.Value))
+		}
+		query = fmt.Sprintf("%s [%s]", query, strings.Join(values, ", "))
+	}
+	f.queries = append(f.queries, query)
+	for key, msg := range f.failures {
+		if strings.Contains(query, key) {
+			return fakeResult{}, errors.New(msg)
+		}
+	}
+	for key, err := range f.errs {
+		if strings.Contains(query, key) {
+			return fakeResult{}, err
+		}
+	}
+	for key, result := range f.results {
+		if strings.Contains(query, key) {
+			return result, nil
+		}
+	}
+	return fakeResult{}, nil
+}
+
+type fakeConnector struct {
+	db *fakeDatabase
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn(c), nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("the fake database can only be opened with a connector")
+}
+
+type fakeConn struct {
+	db *fakeDatabase
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("the fake database doesn't support prepared statements")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	_, err := c.db.run("BEGIN", nil)
+	return c, err
+}
+
+func (c fakeConn) Commit() error {
+	return nil
+}
+
+func (c fakeConn) Rollback() error {
+	return nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, err := c.db.run(query, args)
+	return driver.RowsAffected(0), err
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: result}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.result.types) {
+		return r.result.types[i]
+	}
+	return "VARCHAR"
+}
+
+// fakeMySQLDatabase returns a fake drupal database with a table that is rewritten and has a trigger, one that is
+// filtered, one that only has its structure dumped, one that is ignored, two views where the first selects from the
+// second, a procedure, an event, and a function that the user isn't allowed to read
+func fakeMySQLDatabase() *fakeDatabase {
+	return &fakeDatabase{
+		results: map[string]fakeResult{
+			"SHOW FULL TABLES": {
+				columns: []string{"Tables_in_drupal", "Table_type"},
+				rows: [][]driver.Value{
+					{"users", "BASE TABLE"},
+					{"recent_active_users", "VIEW"},
+					{"active_users", "VIEW"},
+					{"node", "BASE TABLE"},
+					{"cache_data", "BASE TABLE"},
+					{"watchdog", "BASE TABLE"},
+				},
+			},
+			"SHOW CREATE TABLE `users`": {
+				columns: []string{"Table", "Create Table"},
+				rows:    [][]driver.Value{{"users", "CREATE TABLE `users` (\n  `uid` int unsigned NOT NULL\n)"}},
+			},
+			"SHOW CREATE TABLE `node`": {
+				columns: []string{"Table", "Create Table"},
+				rows:    [][]driver.Value{{"node", "CREATE TABLE `node` (\n  `nid` int NOT NULL\n)"}},
+			},
+			"SHOW CREATE TABLE `cache_data`": {
+				columns: []string{"Table", "Create Table"},
+				rows:    [][]driver.Value{{"cache_data", "CREATE TABLE `cache_data` (\n  `cid` varchar(255) NOT NULL\n)"}},
+			},
+			"SHOW CREATE TABLE `watchdog`": {
+				columns: []string{"Table", "Create Table"},
+				rows:    [][]driver.Value{{"watchdog", "CREATE TABLE `watchdog` (\n  `wid` int NOT NULL\n)"}},
+			},
+			"ORDINAL_POSITION [watchdog]": {
+				columns: []string{"COLUMN_NAME"},
+				rows:    [][]driver.Value{{"wid"}},
+			},
+			"SHOW CREATE VIEW `recent_active_users`": {
+				columns: []string{"View", "Create View", "character_set_client", "collation_connection"},
+				rows:    [][]driver.Value{{"recent_active_users", "CREATE VIEW `recent_active_users` AS select `uid` from `active_users` where `uid` > 1", "utf8mb4", "utf8mb4_general_ci"}},
+			},
+			"ORDINAL_POSITION [recent_active_users]": {
+				columns: []string{"COLUMN_NAME"},
+				rows:    [][]driver.Value{{"uid"}},
+			},
+			"ORDINAL_POSITION [active_users]": {
+				columns: []string{"COLUMN_NAME"},
+				rows:    [][]driver.Value{{"uid"}},
+			},
+			"SHOW CREATE VIEW `active_users`": {
+				columns: []string{"View", "Create View", "character_set_client", "collation_connection"},
+				rows:    [][]driver.Value{{"active_users", "CREATE VIEW `active_users` AS select `uid` from `users`", "utf8mb4", "utf8mb4_general_ci"}},
+			},
+			"ORDINAL_POSITION [users]": {
+				columns: []string{"COLUMN_NAME"},
+				rows:    [][]driver.Value{{"uid"}, {"name"}, {"mail"}, {"picture"}},
+			},
+			"ORDINAL_POSITION [node]": {
+				columns: []string{"COLUMN_NAME"},
+				rows:    [][]driver.Value{{"nid"}, {"title"}},
+			},
+			"FROM `users`": {
+				columns: []string{"uid", "name", "mail", "picture"},
+				types:   []string{"UNSIGNED INT", "VARCHAR", "VARCHAR", "BLOB"},
+				rows: [][]driver.Value{
+					{"1", "admin", "1@example.com", []byte{0x89, 'P', 'N', 'G'}},
+					{"2", "o'brien", "2@example.com", nil},
+					{"3", "line\nbreak", "3@example.com", []byte{}},
+				},
+			},
+			"ACTION_ORDER [users]": {
+				columns: []string{"TRIGGER_NAME"},
+				rows:    [][]driver.Value{{"users_changed"}},
+			},
+			"SHOW CREATE TRIGGER `users_changed`": {
+				columns: []string{"Trigger", "sql_mode", "SQL Original Statement", "character_set_client", "collation_connection", "Database Collation", "Created"},
+				rows:    [][]driver.Value{{"users_changed", "", "CREATE TRIGGER `users_changed` BEFORE UPDATE ON `users` FOR EACH ROW BEGIN SET NEW.changed = NOW(); END", "utf8mb4", "utf8mb4_general_ci", "utf8mb4_general_ci", nil}},
+			},
+			"information_schema.ROUTINES": {
+				columns: []string{"ROUTINE_TYPE", "ROUTINE_NAME"},
+				rows:    [][]driver.Value{{"PROCEDURE", "rebuild_cache"}, {"FUNCTION", "user_count"}, {"EVENT", "purge_sessions"}},
+			},
+			"SHOW CREATE PROCEDURE `rebuild_cache`": {
+				columns: []string{"Procedure", "sql_mode", "Create Procedure", "character_set_client", "collation_connection", "Database Collation"},
+				rows:    [][]driver.Value{{"rebuild_cache", "STRICT_TRANS_TABLES", "CREATE PROCEDURE `rebuild_cache`() BEGIN DELETE FROM `cache_data`; END", "utf8mb4", "utf8mb4_general_ci", "utf8mb4_general_ci"}},
+			},
+			"SHOW CREATE FUNCTION `user_count`": {
+				columns: []string{"Function", "sql_mode", "Create Function", "character_set_client", "collation_connection", "Database Collation"},
+				rows:    [][]driver.Value{{"user_count", "", nil, "utf8mb4", "utf8mb4_general_ci", "utf8mb4_general_ci"}},
+			},
+			"SHOW CREATE EVENT `purge_sessions`": {
+				columns: []string{"Event", "sql_mode", "time_zone", "Create Event", "character_set_client", "collation_connection", "Database Collation"},
+				rows:    [][]driver.Value{{"purge_sessions", "", "SYSTEM", "CREATE EVENT `purge_sessions` ON SCHEDULE EVERY 1 DAY DO DELETE FROM `sessions`", "utf8mb4", "utf8mb4_general_ci", "utf8mb4_general_ci"}},
+			},
+			"FROM `node`": {
+				columns: []string{"nid", "title"},
+				types:   []string{"INT", "VARCHAR"},
+				rows:    [][]driver.Value{{"1", `back\slash`}},
+			},
+		},
+	}
+}
+
+func Test_mysqlDump_Dump(t *testing.T) {
+	db := fakeMySQLDatabase()
+	sqlDB, _ := db.open(context.Background())
+	events := []string{}
+	dump := mysqlDump{
+		DB: sqlDB,
+		Config: MTKConfig{
+			Rewrite: map[string]map[string]string{
+				"users": {"mail": "concat(uid, '@example.com')"},
+			},
+			Where: map[string]string{
+				"node": "nid < 100",
+			},
+			NoData: []string{"cache*"},
+			Ignore: []string{"watchdog"},
+		},
+		ExtendedInsertRows: 2,
+		Progress: func(e dumpEvent) {
+			events = append(events, e.String())
+		},
+	}
+	var out bytes.Buffer
+	if err := dump.Dump(context.Background(), &out); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	want := "SET NAMES utf8mb4;\n" +
+		"SET TIME_ZONE = '+00:00';\n" +
+		"SET FOREIGN_KEY_CHECKS = 0;\n" +
+		"SET UNIQUE_CHECKS = 0;\n" +
+		"SET SQL_MODE = 'NO_AUTO_VALUE_ON_ZERO';\n\n" +
+		"--\n-- Table structure for `users`\n--\n\n" +
+		"DROP TABLE IF EXISTS `users`;\nCREATE TABLE `users` (\n  `uid` int unsigned NOT NULL\n);\n\n" +
+		"--\n-- Data for `users`\n--\n\n" +
+		"LOCK TABLES `users` WRITE;\n" +
+		"INSERT INTO `users` (`uid`, `name`, `mail`, `picture`) VALUES (1,'admin','1@example.com',0x89504e47),(2,'o\\'brien','2@example.com',NULL);\n" +
+		"INSERT INTO `users` (`uid`, `name`, `mail`, `picture`) VALUES (3,'line\\nbreak','3@example.com','');\n" +
+		"UNLOCK TABLES;\n\n" +
+		"--\n-- Triggers for `users`\n--\n\n" +
+		"DROP TRIGGER IF EXISTS `users_changed`;\nDELIMITER ;;\n" +
+		"CREATE TRIGGER `users_changed` BEFORE UPDATE ON `users` FOR EACH ROW BEGIN SET NEW.changed = NOW(); END;;\n" +
+		"DELIMITER ;\n\n" +
+		"--\n-- Temporary table structure for view `recent_active_users`\n--\n\n" +
+		"DROP TABLE IF EXISTS `recent_active_users`;\nDROP VIEW IF EXISTS `recent_active_users`;\nCREATE TABLE `recent_active_users` (\n  `uid` tinyint NOT NULL\n);\n\n" +
+		"--\n-- Temporary table structure for view `active_users`\n--\n\n" +
+		"DROP TABLE IF EXISTS `active_users`;\nDROP VIEW IF EXISTS `active_users`;\nCREATE TABLE `active_users` (\n  `uid` tinyint NOT NULL\n);\n\n" +
+		"--\n-- Table structure for `node`\n--\n\n" +
+		"DROP TABLE IF EXISTS `node`;\nCREATE TABLE `node` (\n  `nid` int NOT NULL\n);\n\n" +
+		"--\n-- Data for `node`\n--\n\n" +
+		"LOCK TABLES `node` WRITE;\n" +
+		"INSERT INTO `node` (`nid`, `title`) VALUES (1,'back\\\\slash');\n" +
+		"UNLOCK TABLES;\n\n" +
+		"--\n-- Table structure for `cache_data`\n--\n\n" +
+		"DROP TABLE IF EXISTS `cache_data`;\nCREATE TABLE `cache_data` (\n  `cid` varchar(255) NOT NULL\n);\n\n" +
+		"--\n-- View structure for `active_users`\n--\n\n" +
+		"DROP TABLE IF EXISTS `active_users`;\nDROP VIEW IF EXISTS `active_users`;\nCREATE VIEW `active_users` AS select `uid` from `users`;\n\n" +
+		"--\n-- View structure for `recent_active_users`\n--\n\n" +
+		"DROP TABLE IF EXISTS `recent_active_users`;\nDROP VIEW IF EXISTS `recent_active_users`;\n" +
+		"CREATE VIEW `recent_active_users` AS select `uid` from `active_users` where `uid` > 1;\n\n" +
+		"--\n-- Definition of procedure `rebuild_cache`\n--\n\n" +
+		"DROP PROCEDURE IF EXISTS `rebuild_cache`;\nSET SQL_MODE = 'STRICT_TRANS_TABLES';\nDELIMITER ;;\n" +
+		"CREATE PROCEDURE `rebuild_cache`() BEGIN DELETE FROM `cache_data`; END;;\n" +
+		"DELIMITER ;\nSET SQL_MODE = 'NO_AUTO_VALUE_ON_ZERO';\n\n" +
+		"--\n-- Definition of event `purge_sessions`\n--\n\n" +
+		"DROP EVENT IF EXISTS `purge_sessions`;\nSET SQL_MODE = '';\nDELIMITER ;;\n" +
+		"CREATE EVENT `purge_sessions` ON SCHEDULE EVERY 1 DAY DO DELETE FROM `sessions`;;\n" +
+		"DELIMITER ;\nSET SQL_MODE = 'NO_AUTO_VALUE_ON_ZERO';\n\n" +
+		"SET FOREIGN_KEY_CHECKS = 1;\n" +
+		"SET UNIQUE_CHECKS = 1;\n"
+	if out.String() != want {
+		t.Errorf("Dump() = \n%v\nwant\n%v", out.String(), want)
+	}
+	wantQueries := []string{
+		"SET time_zone = '+00:00'",
+		"SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ",
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+		"SHOW FULL TABLES",
+		"SHOW CREATE TABLE `users`",
+		mysqlColumnsQuery + " [users]",
+		"SELECT `uid`, `name`, (concat(uid, '@example.com')) AS `mail`, `picture` FROM `users`",
+		mysqlTriggersQuery + " [users]",
+		"SHOW CREATE TRIGGER `users_changed`",
+		mysqlColumnsQuery + " [recent_active_users]",
+		mysqlColumnsQuery + " [active_users]",
+		"SHOW CREATE TABLE `node`",
+		mysqlColumnsQuery + " [node]",
+		"SELECT `nid`, `title` FROM `node` WHERE nid < 100",
+		mysqlTriggersQuery + " [node]",
+		"SHOW CREATE TABLE `cache_data`",
+		mysqlTriggersQuery + " [cache_data]",
+		"SHOW CREATE VIEW `recent_active_users`",
+		"SHOW CREATE VIEW `active_users`",
+		mysqlRoutinesQuery,
+		"SHOW CREATE PROCEDURE `rebuild_cache`",
+		"SHOW CREATE FUNCTION `user_count`",
+		"SHOW CREATE EVENT `purge_sessions`",
+		"ROLLBACK",
+	}
+	if !reflect.DeepEqual(db.queries, wantQueries) {
+		t.Errorf("Dump() queries = \n%v\nwant\n%v", strings.Join(db.queries, "\n"), strings.Join(wantQueries, "\n"))
+	}
+	wantEvents := []string{
+		"users: 3 sanitised rows, 1 triggers",
+		"node: 1 sanitised rows",
+		"cache_data: structure only",
+		"watchdog: ignored",
+		"active_users: view",
+		"recent_active_users: view",
+		"rebuild_cache: procedure",
+		"user_count: function not dumped, the user isn't allowed to read it",
+		"purge_sessions: event",
+	}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("Dump() events = %v, want %v", events, wantEvents)
+	}
+}
+
+func Test_mysqlDump_Dump_error(t *testing.T) {
+	db := fakeMySQLDatabase()
+	db.failures = map[string]string{"FROM `node`": "Error 1054 (42S22): Unknown column 'nid' in 'where clause'"}
+	sqlDB, _ := db.open(context.Background())
+	err := mysqlDump{DB: sqlDB, Config: MTKConfig{Where: map[string]string{"node": "nid < 100"}}}.Dump(context.Background(), io.Discard)
+	var dumpErr *dumpError
+	if !errors.As(err, &dumpErr) {
+		t.Fatalf("Dump() error = %v, want a dumpError", err)
+	}
+	if dumpErr.Table != "node" || dumpErr.Query != "SELECT `nid`, `title` FROM `node` WHERE nid < 100" {
+		t.Errorf("Dump() error table = %v, query = %v", dumpErr.Table, dumpErr.Query)
+	}
+	want := "unable to dump node: Error 1054 (42S22): Unknown column 'nid' in 'where clause' (SELECT `nid`, `title` FROM `node` WHERE nid < 100)"
+	if err.Error() != want {
+		t.Errorf("Dump() error = %v, want %v", err, want)
+	}
+}
+
+func Test_mysqlDump_Dump_missingRewriteColumn(t *testing.T) {
+	db := fakeMySQLDatabase()
+	sqlDB, _ := db.open(context.Background())
+	config := MTKConfig{Rewrite: map[string]map[string]string{
+		"users": {"mail": "concat(uid, '@example.com')", "e_mail": "'x'", "pass": "'x'"},
+	}}
+	var out bytes.Buffer
+	err := mysqlDump{DB: sqlDB, Config: config}.Dump(context.Background(), &out)
+	want := "unable to dump users: the rewritten columns e_mail, pass don't exist or are generated columns"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("Dump() error = %v, want %v", err, want)
+	}
+	// nothing of the table is read once a rewritten column is found to be missing
+	if strings.Contains(out.String(), "INSERT INTO `users`") || slices.ContainsFunc(db.queries, func(q string) bool {
+		return strings.HasPrefix(q, "SELECT `uid`")
+	}) {
+		t.Errorf("Dump() read the rows of users, queries = %v", db.queries)
+	}
+}
+
+func Test_mysqlDump_Dump_routineAccessDenied(t *testing.T) {
+	db := fakeMySQLDatabase()
+	db.errs = map[string]error{"SHOW CREATE EVENT": &mysql.MySQLError{Number: 1044, Message: "Access denied for user 'dbuser'@'%' to database 'drupal'"}}
+	sqlDB, _ := db.open(context.Background())
+	events := []string{}
+	var out bytes.Buffer
+	err := mysqlDump{DB: sqlDB, Progress: func(e dumpEvent) {
+		events = append(events, e.String())
+	}}.Dump(context.Background(), &out)
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if strings.Contains(out.String(), "purge_sessions") {
+		t.Errorf("Dump() wrote the event that the user isn't allowed to read")
+	}
+	if want := "purge_sessions: event not dumped, the user isn't allowed to read it"; !slices.Contains(events, want) {
+		t.Errorf("Dump() events = %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,245 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/uselagoon/database-image-task/internal/oci"
+)
+
+const (
+	// ociEntrypointFilename is the copy of the builder image entrypoint that only initialises the database
+	ociEntrypointFilename = "init-entrypoint.sh"
+	ociLayerFilename      = "data-layer.tar"
+)
+
+// dataDirLayout is how the data directory is initialised by the builder image, and where it goes in the clean image,
+// these are the same values used by the database type's dockerfile and its build args
+type dataDirLayout struct {
+	// Target is where the data directory is copied to in the clean image
+	Target string
+	// UID is the user that the database runs as in the clean image
+	UID int
+	// DirMode is the permissions of the data directory in the clean image, if they need to be changed
+	DirMode fs.FileMode
+	// MyCnf is where the rendered my.cnf is copied to in the clean image, as well as the data directory, if it is set
+	MyCnf string
+	// Server is the command given to the entrypoint to import the dump
+	Server []string
+	// Env is the environment used by the entrypoint to create the database
+	Env []string
+}
+
+// dataLayout returns the layout of the data directory for the database type, the target, owner and my.cnf come from
+// the layout detected from the clean image
+func (p *Pipeline) dataLayout() dataDirLayout {
+	dataDir := p.Build.DataDir
+	// the import my.cnf has to be the first argument to mysqld
+	importConfig := fmt.Sprintf("--defaults-extra-file=%s", filepath.Join(p.WorkDir, "import.my.cnf"))
+	switch p.Build.DatabaseType {
+	case "mysql":
+		return dataDirLayout{
+			Target: p.cleanImage.DataDir,
+			UID:    p.cleanImage.UID,
+			MyCnf:  p.cleanImage.MyCnf,
+			Server: append(append([]string{"mysqld", importConfig}, p.Build.importServerArgs()...), "--datadir", dataDir),
+			Env: []string{
+				fmt.Sprintf("MYSQL_ROOT_PASSWORD=%s", p.Build.ResultImageRootPassword),
+				fmt.Sprintf("MYSQL_DATABASE=%s", p.Build.ResultImageDatabaseName),
+				fmt.Sprintf("MYSQL_USER=%s", p.Build.ResultImageUser),
+				fmt.Sprintf("MYSQL_PASSWORD=%s", p.Build.ResultImagePassword),
+			},
+		}
+	case "postgres":
+		return dataDirLayout{
+			Target:  p.cleanImage.DataDir,
+			UID:     p.cleanImage.UID,
+			DirMode: 0700,
+			Server:  []string{"postgres"},
+			Env: []string{
+				fmt.Sprintf("POSTGRES_PASSWORD=%s", p.Build.ResultImagePassword),
+				fmt.Sprintf("POSTGRES_USER=%s", p.Build.ResultImageUser),
+				fmt.Sprintf("POSTGRES_DB=%s", p.Build.ResultImageDatabaseName),
+				fmt.Sprintf("PGDATA=%s", dataDir),
+			},
+		}
+	}
+	return dataDirLayout{
+		Target: p.cleanImage.DataDir,
+		UID:    p.cleanImage.UID,
+		MyCnf:  p.cleanImage.MyCnf,
+		Server: append(append([]string{"mysqld", importConfig}, p.Build.importServerArgs()...), "--datadir", dataDir, "--aria-log-dir-path", dataDir),
+		Env: []string{
+			fmt.Sprintf("MYSQL_ROOT_PASSWORD=%s", p.Build.ResultImageRootPassword),
+			fmt.Sprintf("MARIADB_DATABASE=%s", p.Build.ResultImageDatabaseName),
+			fmt.Sprintf("MARIADB_USER=%s", p.Build.ResultImageUser),
+			fmt.Sprintf("MARIADB_PASSWORD=%s", p.Build.ResultImagePassword),
+		},
+	}
+}
+
+// ociKeychain only gives the registry credentials to the configured registry, the clean image is pulled anonymously
+// from anywhere else
+func (p *Pipeline) ociKeychain() oci.Credentials {
+	return oci.Credentials{
+		Registry: p.Build.RegistryHost,
+		Username: p.Build.RegistryUsername,
+		Password: p.Build.RegistryPassword,
+	}
+}
+
+// ociImportTools checks that the task is running in an image based on the builder image, the import needs its
+// entrypoint, bash and the database server, this is checked before anything is dumped instead of failing the import
+// nothing is needed if the data directory already has a database in it
+func (p *Pipeline) ociImportTools() error {
+	entries, err := os.ReadDir(p.Build.DataDir)
+	if err == nil && len(entries) > 0 {
+		return nil
+	}
+	missing := []string{}
+	if _, err := os.Stat(p.Entrypoint); err != nil {
+		missing = append(missing, p.Entrypoint)
+	}
+	for _, command := range []string{"bash", p.dataLayout().Server[0]} {
+		if _, err := p.LookPath(command); err != nil {
+			missing = append(missing, command)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the oci backend imports the dump with the builder image %s, but %s can't be found, the task needs to run in an image based on %s, or with a data directory that already has the database in it",
+			p.Build.SourceImageName, strings.Join(missing, ", "), p.Build.SourceImageName)
+	}
+	return nil
+}
+
+// initialiseDataDir imports the sanitised dump into the data directory by running the entrypoint of the builder image,
+// this is what the builder stage of the dockerfile does, so the task has to run in an image based on the builder image
+// if the data directory already has a database in it, eg it was prepared by an init container, it is used as is
+func (p *Pipeline) initialiseDataDir(ctx context.Context, layout dataDirLayout) error {
+	entries, err := os.ReadDir(p.Build.DataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		fmt.Fprintf(p.Stdout, "using the existing database in %s\n", p.Build.DataDir)
+		return nil
+	}
+	// that file does the database initialisation but also runs the database server, by removing the last line it will only initialise
+	entrypoint, err := os.ReadFile(p.Entrypoint)
+	if err != nil {
+		return fmt.Errorf("unable to read the builder image entrypoint: %w", err)
+	}
+	entrypoint = bytes.ReplaceAll(entrypoint, []byte(`exec "$@"`), []byte(`echo "not running $@"`))
+	initEntrypoint := filepath.Join(p.WorkDir, ociEntrypointFilename)
+	if err := os.WriteFile(initEntrypoint, entrypoint, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.InitDBDir, 0755); err != nil {
+		return err
+	}
+	dumpFile := p.Build.Compression.filename()
+	if err := copyFile(filepath.Join(p.WorkDir, dumpFile), filepath.Join(p.InitDBDir, dumpFile)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.Build.DataDir, 0755); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.Stdout, "importing the sanitised dump into %s\n", p.Build.DataDir)
+	var output bytes.Buffer
+	err = p.Executor.Run(ctx, Command{
+		Name:   "bash",
+		Args:   append([]string{initEntrypoint}, layout.Server...),
+		Env:    layout.Env,
+		Dir:    p.WorkDir,
+		Stdout: &output,
+		Stderr: &output,
+	})
+	if err != nil {
+		// print only the last 3 lines of the output that show the error, printing more than this has potential to leak data
+		lines := strings.Split(strings.TrimRight(output.String(), "\n"), "\n")
+		fmt.Fprintln(p.Stdout, strings.Join(lines[max(len(lines)-3, 0):], "\n"))
+		return fmt.Errorf("database import failed: %v", err)
+	}
+	return nil
+}
+
+// ociBackend builds and pushes the image without a docker host, the data directory is initialised locally and
+// appended to the clean image as a new layer, then pushed over the OCI distribution API
+type ociBackend struct {
+	p     *Pipeline
+	image v1.Image
+}
+
+// Build initialises the data directory, then appends it to the clean image, the dockerfile isn't used
+func (b *ociBackend) Build(ctx context.Context, req BuildRequest) error {
+	p := b.p
+	layout := p.dataLayout()
+	if err := p.initialiseDataDir(ctx, layout); err != nil {
+		return err
+	}
+	files := map[string][]byte{}
+	if layout.MyCnf != "" {
+		// the clean image starts with the `.my.cnf` in the data directory and copied to its my.cnf
+		myCnf, err := os.ReadFile(filepath.Join(p.WorkDir, "my.cnf"))
+		if err != nil {
+			return err
+		}
+		files[path.Join(layout.Target, ".my.cnf")] = myCnf
+		files[layout.MyCnf] = myCnf
+	}
+	layer, err := oci.Layer(p.Build.DataDir, filepath.Join(p.WorkDir, ociLayerFilename), oci.LayerOptions{
+		Target:  layout.Target,
+		UID:     layout.UID,
+		DirMode: layout.DirMode,
+		Files:   files,
+		ModTime: p.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the data layer: %w", err)
+	}
+	platform := v1.Platform{OS: "linux", Architecture: runtime.GOARCH}
+	img, err := oci.Append(ctx, p.Build.CleanImageName, layer, p.ociKeychain(), platform, p.Now())
+	if err != nil {
+		return err
+	}
+	b.image = img
+	fmt.Fprintf(p.Stdout, "added %s to %s as %s\n", p.Build.DataDir, p.Build.CleanImageName, layout.Target)
+	return nil
+}
+
+// Push pushes the image assembled by Build straight to the registry
+func (b *ociBackend) Push(ctx context.Context, images []string) error {
+	if err := oci.Push(ctx, b.image, images, b.p.ociKeychain()); err != nil {
+		return err
+	}
+	for _, image := range images {
+		fmt.Fprintf(b.p.Stdout, "pushed %s\n", image)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
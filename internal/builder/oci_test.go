@@ -0,0 +1,154 @@
+package builder
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+func Test_Pipeline_Run_oci(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		dbType      string
+		cleanImage  string
+		dataFiles   map[string]string
+		wantInit    bool
+		wantLayer   []string
+	}{
+		{
+			name:        "test1",
+			description: "check a mariadb build initialises the empty data directory and pushes it without a docker host",
+			dbType:      "mariadb",
+			cleanImage:  "uselagoon/mariadb-10.6-drupal:latest",
+			wantInit:    true,
+			wantLayer: []string{
+				"var/lib/mysql/ 100:0",
+				"etc/mysql/my.cnf 100:0",
+				"var/lib/mysql/.my.cnf 100:0",
+			},
+		},
+		{
+			name:        "test2",
+			description: "check a postgres build uses the data directory that has already been initialised",
+			dbType:      "postgres",
+			cleanImage:  "uselagoon/postgres-14-drupal:latest",
+			dataFiles:   map[string]string{"PG_VERSION": "14\n"},
+			wantLayer: []string{
+				"var/lib/postgresql/data/ 70:0",
+				"var/lib/postgresql/data/PG_VERSION 70:0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+			t.Cleanup(server.Close)
+			host := strings.TrimPrefix(server.URL, "http://")
+			base, _ := random.Image(64, 1)
+			baseRef, _ := name.ParseReference(fmt.Sprintf("%s/%s", host, tt.cleanImage))
+			if err := remote.Write(baseRef, base); err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			dataDir := filepath.Join(t.TempDir(), "initialized-db")
+			for file, content := range tt.dataFiles {
+				os.MkdirAll(dataDir, 0700)
+				os.WriteFile(filepath.Join(dataDir, file), []byte(content), 0600)
+			}
+			envvars, _ := json.Marshal([]variables.LagoonEnvironmentVariable{
+				{Name: "BUILDER_BACKUP_IMAGE_TYPE", Value: tt.dbType, Scope: "global"},
+				{Name: "BUILDER_BUILD_BACKEND", Value: "oci", Scope: "global"},
+				{Name: "BUILDER_DATA_DIR", Value: dataDir, Scope: "global"},
+				{Name: "BUILDER_CLEAN_IMAGE_NAME", Value: baseRef.String(), Scope: "global"},
+				{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+				{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+				{Name: "BUILDER_REGISTRY_HOST", Value: host, Scope: "global"},
+				{Name: "BUILDER_BACKUP_IMAGE_NAME", Value: "${registry}/${project}/${environment}", Scope: "global"},
+				{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+				{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+				{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+			})
+			t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+			t.Setenv("LAGOON_PROJECT", "lagpro")
+			t.Setenv("LAGOON_ENVIRONMENT", "lagenv")
+
+			exec := &fakeExecutor{output: map[string]string{
+				postgresSnapshotSession: "snapshot=00000003-0000001B-1\n",
+				"pg_dump --version":     "pg_dump (PostgreSQL) 14.13\n",
+			}}
+			p, engine, _ := newTestPipeline(t, exec)
+			p.Entrypoint = filepath.Join(t.TempDir(), "docker-entrypoint.sh")
+			os.WriteFile(p.Entrypoint, []byte("#!/bin/bash\n_main() {\n\texec \"$@\"\n}\n"), 0755)
+			p.InitDBDir = filepath.Join(t.TempDir(), "docker-entrypoint-initdb.d")
+			if err := p.Run(context.Background()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if engine.Requests() != nil {
+				t.Errorf("Run() made docker requests %v", engine.Requests())
+			}
+
+			initCommand := fmt.Sprintf("bash %s", filepath.Join(p.WorkDir, ociEntrypointFilename))
+			ranInit := false
+			for _, cmd := range exec.commands {
+				ranInit = ranInit || strings.HasPrefix(cmd, initCommand)
+			}
+			if ranInit != tt.wantInit {
+				t.Errorf("Run() initialised the data directory = %v, want %v\n%v", ranInit, tt.wantInit, strings.Join(exec.commands, "\n"))
+			}
+			if tt.wantInit {
+				b, _ := os.ReadFile(filepath.Join(p.WorkDir, ociEntrypointFilename))
+				if !strings.Contains(string(b), `echo "not running $@"`) {
+					t.Errorf("Run() entrypoint was not changed to only initialise\n%s", b)
+				}
+				if _, err := os.Stat(filepath.Join(p.InitDBDir, p.Build.Compression.filename())); err != nil {
+					t.Errorf("Run() dump was not copied to the initdb directory: %v", err)
+				}
+			}
+
+			for _, tag := range []string{"latest", "backup-2026-10-18"} {
+				ref, _ := name.ParseReference(fmt.Sprintf("%s/lagpro/lagenv:%s", host, tag))
+				img, err := remote.Image(ref)
+				if err != nil {
+					t.Fatalf("remote.Image(%s) error = %v", ref, err)
+				}
+				layers, _ := img.Layers()
+				if len(layers) != 2 {
+					t.Fatalf("%s has %d layers, want 2", ref, len(layers))
+				}
+				rc, _ := layers[1].Uncompressed()
+				got := []string{}
+				tr := tar.NewReader(rc)
+				for {
+					hdr, err := tr.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("%v", err)
+					}
+					got = append(got, fmt.Sprintf("%s %d:%d", hdr.Name, hdr.Uid, hdr.Gid))
+				}
+				rc.Close()
+				if !reflect.DeepEqual(got, tt.wantLayer) {
+					t.Errorf("%s data layer = \n%v\nwant\n%v", ref, strings.Join(got, "\n"), strings.Join(tt.wantLayer, "\n"))
+				}
+			}
+		})
+	}
+}
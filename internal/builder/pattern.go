@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+// patternFilters are the filters that can be applied to a placeholder, eg `${branch|sanitize|truncate:20}`, each one
+// is given the value and the argument after the `:`, if there is one
+var patternFilters = map[string]func(value, arg string) (string, error){
+	"lower": func(value, arg string) (string, error) {
+		return strings.ToLower(value), nil
+	},
+	"upper": func(value, arg string) (string, error) {
+		return strings.ToUpper(value), nil
+	},
+	"truncate": func(value, arg string) (string, error) {
+		length, err := strconv.Atoi(arg)
+		if err != nil || length < 1 {
+			return "", fmt.Errorf("truncate needs a length greater than 0, not %q", arg)
+		}
+		if len(value) > length {
+			value = value[:length]
+		}
+		return value, nil
+	},
+	"sanitize": func(value, arg string) (string, error) {
+		return replaceDoubleSpecial(value), nil
+	},
+	"default": func(value, arg string) (string, error) {
+		if value == "" {
+			return arg, nil
+		}
+		return value, nil
+	},
+}
+
+// patternValues are the values of each of the placeholders that can be used in the image name and tag patterns
+func patternValues(build Builder, now time.Time) map[string]string {
+	year, week := now.ISOWeek()
+	return map[string]string{
+		"registry":     build.RegistryHost,
+		"organization": build.RegistryOrganization,
+		"project":      variables.GetEnv("LAGOON_PROJECT", ""),
+		"environment":  variables.GetEnv("LAGOON_ENVIRONMENT", ""),
+		"service":      build.DockerComposeServiceName,
+		// the database name is always munged, otherwise a name that is valid for the database server isn't a valid repository
+		"database":  replaceDoubleSpecial(build.MTK.Database),
+		"branch":    variables.GetEnv("LAGOON_GIT_BRANCH", ""),
+		"git_sha":   variables.GetEnv("LAGOON_GIT_SHA", ""),
+		"task_id":   variables.GetEnv("LAGOON_TASK_ID", ""),
+		"date":      now.Format(time.DateOnly),
+		"timestamp": now.Format("20060102150405"),
+		"month":     now.Format("2006-01"),
+		"week":      fmt.Sprintf("%d-w%02d", year, week),
+	}
+}
+
+// imagePatternParser expands every `${placeholder}` in the pattern, with any filters applied in order
+// an unknown placeholder or filter is an error, rather than ending up in the image name
+func imagePatternParser(pattern string, build Builder, now time.Time) (string, error) {
+	values := patternValues(build, now)
+	var result strings.Builder
+	rest := pattern
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			result.WriteString(rest)
+			return result.String(), nil
+		}
+		result.WriteString(rest[:start])
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("%w %s: %s is missing a closing }", ErrInvalidPattern, pattern, rest[start:])
+		}
+		expr := rest[start+2 : start+end]
+		value, err := expandPlaceholder(expr, values)
+		if err != nil {
+			return "", fmt.Errorf("%w %s: %v", ErrInvalidPattern, pattern, err)
+		}
+		result.WriteString(value)
+		rest = rest[start+end+1:]
+	}
+}
+
+// expandPlaceholder returns the value of a placeholder expression, `name|filter|filter:arg`
+func expandPlaceholder(expr string, values map[string]string) (string, error) {
+	parts := strings.Split(expr, "|")
+	name := strings.TrimSpace(parts[0])
+	value, ok := values[name]
+	if !ok {
+		known := []string{}
+		for k := range values {
+			known = append(known, k)
+		}
+		slices.Sort(known)
+		return "", fmt.Errorf("unknown placeholder ${%s}, use one of %s", name, strings.Join(known, ", "))
+	}
+	for _, f := range parts[1:] {
+		filterName, arg, _ := strings.Cut(strings.TrimSpace(f), ":")
+		filter, ok := patternFilters[filterName]
+		if !ok {
+			return "", fmt.Errorf("unknown filter %s in ${%s}", filterName, expr)
+		}
+		var err error
+		value, err = filter(value, arg)
+		if err != nil {
+			return "", fmt.Errorf("%v in ${%s}", err, expr)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,349 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// postgresSnapshotPrefix is written before the id of the exported snapshot, so that it can be found in the output of
+// the psql session that holds the snapshot
+const postgresSnapshotPrefix = "snapshot="
+
+// postgresDump produces a sanitised plain SQL dump of a postgres database using pg_dump and psql
+// the mtk config is applied in the same way that mtk applies it to mysql databases:
+//   - ignore tables are not dumped at all
+//   - nodata tables only have their structure dumped
+//   - rewrite and where tables have their rows exported with COPY using a SELECT with the rewritten columns and condition
+//
+// the rewrite and where expressions are run by postgres, so they must be written in postgres SQL
+//
+// each pg_dump and psql run is a separate session, so the snapshot of a transaction that is held open for the whole
+// dump is exported and every run reads the database as it was when that transaction started, this keeps the tables
+// consistent with each other when the database is being written to
+type postgresDump struct {
+	Executor Executor
+	MTK      MTK
+	Config   MTKConfig
+	Stderr   io.Writer
+
+	// snapshot is the id of the exported snapshot that the dump is read in, if there is one
+	snapshot string
+}
+
+// postgresSnapshot is a psql session that holds open the transaction that a snapshot was exported from, the snapshot
+// can only be used while the transaction is open
+type postgresSnapshot struct {
+	ID   string
+	hold *io.PipeWriter
+	done chan error
+}
+
+// release ends the transaction and waits for the psql session to exit
+func (s *postgresSnapshot) release() error {
+	// the transaction only read, so committing it doesn't change anything
+	_, err := io.WriteString(s.hold, "COMMIT;\n")
+	s.hold.Close()
+	if runErr := <-s.done; runErr != nil {
+		return fmt.Errorf("unable to release the snapshot of the database: %v", runErr)
+	}
+	return err
+}
+
+// postgresTable is a table in the database being dumped
+type postgresTable struct {
+	Schema string
+	Name   string
+}
+
+func (t postgresTable) identifier() string {
+	return fmt.Sprintf("%s.%s", quotePostgresIdentifier(t.Schema), quotePostgresIdentifier(t.Name))
+}
+
+// matches checks the table against a list of tables or globs, which can be either the table name or schema.table
+func (t postgresTable) matches(patterns []string) bool {
+	return matchesTable(patterns, t.Name) || matchesTable(patterns, fmt.Sprintf("%s.%s", t.Schema, t.Name))
+}
+
+// lookup finds the table in a map of table names, which can be either the table name or schema.table
+func lookupPostgresTable[T any](values map[string]T, t postgresTable) (T, bool) {
+	if v, ok := values[fmt.Sprintf("%s.%s", t.Schema, t.Name)]; ok {
+		return v, true
+	}
+	v, ok := values[t.Name]
+	return v, ok
+}
+
+func quotePostgresIdentifier(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(name, `"`, `""`))
+}
+
+func quotePostgresLiteral(value string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", "''"))
+}
+
+// environment is the libpq environment used to connect to the database
+func (d postgresDump) environment() []string {
+	env := []string{
+		fmt.Sprintf("PGHOST=%s", d.MTK.Host),
+		fmt.Sprintf("PGUSER=%s", d.MTK.Username),
+		fmt.Sprintf("PGPASSWORD=%s", d.MTK.Password),
+		fmt.Sprintf("PGDATABASE=%s", d.MTK.Database),
+	}
+	if d.MTK.Socket != "" {
+		// libpq connects to the socket in the directory given as the host
+		env[0] = fmt.Sprintf("PGHOST=%s", d.MTK.Socket)
+	}
+	optional := []struct{ name, value string }{
+		{name: "PGPORT", value: d.MTK.Port},
+		{name: "PGSSLMODE", value: d.MTK.TLSMode},
+		{name: "PGSSLROOTCERT", value: d.MTK.TLSCA},
+		{name: "PGSSLCERT", value: d.MTK.TLSCert},
+		{name: "PGSSLKEY", value: d.MTK.TLSKey},
+	}
+	for _, o := range optional {
+		if o.value != "" {
+			env = append(env, fmt.Sprintf("%s=%s", o.name, o.value))
+		}
+	}
+	return env
+}
+
+// inSnapshot returns the psql arguments that run a statement, in a transaction that reads the exported snapshot if
+// there is one, the status of each statement is left out of the output with -q
+func (d postgresDump) inSnapshot(statement string) []string {
+	if d.snapshot == "" {
+		return []string{"-c", statement}
+	}
+	return []string{
+		"-c", "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY",
+		"-c", fmt.Sprintf("SET TRANSACTION SNAPSHOT %s", quotePostgresLiteral(d.snapshot)),
+		"-c", statement,
+		"-c", "COMMIT",
+	}
+}
+
+// exportSnapshot starts a psql session with a repeatable read transaction and exports its snapshot, the session is
+// held open, reading more statements from its stdin, until the snapshot is released
+func (d postgresDump) exportSnapshot(ctx context.Context) (*postgresSnapshot, error) {
+	holdReader, hold := io.Pipe()
+	outReader, out := io.Pipe()
+	s := &postgresSnapshot{hold: hold, done: make(chan error, 1)}
+	go func() {
+		err := d.Executor.Run(ctx, Command{
+			Name: "psql",
+			Args: []string{"-X", "-q", "-A", "-t", "-v", "ON_ERROR_STOP=1"},
+			Env:  d.environment(),
+			Stdin: io.MultiReader(strings.NewReader(fmt.Sprintf(
+				"BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\nSELECT '%s' || pg_export_snapshot();\n", postgresSnapshotPrefix,
+			)), holdReader),
+			Stdout: out,
+			Stderr: d.Stderr,
+		})
+		// the pipes are closed so that nothing is left waiting on a session that has exited
+		out.CloseWithError(err)
+		holdReader.CloseWithError(err)
+		s.done <- err
+	}()
+	r := bufio.NewReader(outReader)
+	for {
+		line, err := r.ReadString('\n')
+		if id, ok := strings.CutPrefix(strings.TrimSpace(line), postgresSnapshotPrefix); ok {
+			s.ID = id
+			break
+		}
+		if err != nil {
+			hold.Close()
+			runErr := <-s.done
+			if runErr == nil {
+				runErr = errors.New("psql didn't return a snapshot")
+			}
+			return nil, fmt.Errorf("unable to export a snapshot of the database: %v", runErr)
+		}
+	}
+	// anything else the session writes is discarded, so that it never blocks on its output
+	go io.Copy(io.Discard, r)
+	return s, nil
+}
+
+// query runs a query with psql and returns each row of the output, columns are separated with a tab
+func (d postgresDump) query(ctx context.Context, query string) ([]string, error) {
+	var out bytes.Buffer
+	err := d.Executor.Run(ctx, Command{
+		Name:   "psql",
+		Args:   append([]string{"-X", "-q", "-A", "-t", "-F", "\t", "-v", "ON_ERROR_STOP=1"}, d.inSnapshot(query)...),
+		Env:    d.environment(),
+		Stdout: &out,
+		Stderr: d.Stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("psql failed: %v", err)
+	}
+	rows := []string{}
+	for _, row := range strings.Split(out.String(), "\n") {
+		if row != "" {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func (d postgresDump) tables(ctx context.Context) ([]postgresTable, error) {
+	rows, err := d.query(ctx, "SELECT schemaname, tablename FROM pg_catalog.pg_tables WHERE schemaname NOT IN ('pg_catalog', 'information_schema') ORDER BY schemaname, tablename")
+	if err != nil {
+		return nil, err
+	}
+	tables := []postgresTable{}
+	for _, row := range rows {
+		schema, name, _ := strings.Cut(row, "\t")
+		tables = append(tables, postgresTable{Schema: schema, Name: name})
+	}
+	return tables, nil
+}
+
+// columns returns the columns of a table that have their values dumped, a rewrite of a column that isn't one of them is
+// an error, so that a misspelt column can't leave the real values in the dump
+func (d postgresDump) columns(ctx context.Context, t postgresTable) ([]string, error) {
+	columns, err := d.query(ctx, fmt.Sprintf(
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = %s AND table_name = %s AND is_generated = 'NEVER' ORDER BY ordinal_position",
+		quotePostgresLiteral(t.Schema), quotePostgresLiteral(t.Name),
+	))
+	if err != nil {
+		return nil, err
+	}
+	rewrites, _ := lookupPostgresTable(d.Config.Rewrite, t)
+	missing := []string{}
+	for column := range rewrites {
+		if !slices.Contains(columns, column) {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return nil, fmt.Errorf("unable to dump %s: the rewritten columns %s don't exist or are generated columns",
+			t.identifier(), strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// pgDump runs one section of pg_dump, writing it to the output
+func (d postgresDump) pgDump(ctx context.Context, w io.Writer, section string, excludeTables, excludeData []postgresTable) error {
+	args := []string{"--no-owner", "--no-privileges", fmt.Sprintf("--section=%s", section)}
+	if d.snapshot != "" {
+		args = append(args, fmt.Sprintf("--snapshot=%s", d.snapshot))
+	}
+	for _, t := range excludeTables {
+		args = append(args, fmt.Sprintf("--exclude-table=%s", t.identifier()))
+	}
+	for _, t := range excludeData {
+		args = append(args, fmt.Sprintf("--exclude-table-data=%s", t.identifier()))
+	}
+	err := d.Executor.Run(ctx, Command{
+		Name:   "pg_dump",
+		Args:   args,
+		Env:    d.environment(),
+		Stdout: w,
+		Stderr: d.Stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("pg_dump of the %s section failed: %v", section, err)
+	}
+	return nil
+}
+
+// sanitisedSelect returns the SELECT used to export the rows of a rewritten or filtered table
+func (d postgresDump) sanitisedSelect(t postgresTable, columns []string) string {
+	rewrites, _ := lookupPostgresTable(d.Config.Rewrite, t)
+	selects := []string{}
+	for _, column := range columns {
+		if expr, ok := rewrites[column]; ok {
+			selects = append(selects, fmt.Sprintf("(%s) AS %s", expr, quotePostgresIdentifier(column)))
+			continue
+		}
+		selects = append(selects, quotePostgresIdentifier(column))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), t.identifier())
+	if where, ok := lookupPostgresTable(d.Config.Where, t); ok {
+		query = fmt.Sprintf("%s WHERE %s", query, where)
+	}
+	return query
+}
+
+// Dump writes the sanitised dump, the schema is written first, then the data, and then the indexes and constraints
+// so that the rewritten rows are loaded before any foreign keys are created
+func (d postgresDump) Dump(ctx context.Context, w io.Writer) error {
+	snapshot, err := d.exportSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	d.snapshot = snapshot.ID
+	err = d.dump(ctx, w)
+	if releaseErr := snapshot.release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// dump writes the sanitised dump, reading the database in the snapshot
+func (d postgresDump) dump(ctx context.Context, w io.Writer) error {
+	tables, err := d.tables(ctx)
+	if err != nil {
+		return err
+	}
+	ignored := []postgresTable{}
+	noData := []postgresTable{}
+	sanitised := []postgresTable{}
+	for _, t := range tables {
+		_, rewritten := lookupPostgresTable(d.Config.Rewrite, t)
+		_, filtered := lookupPostgresTable(d.Config.Where, t)
+		switch {
+		case t.matches(d.Config.Ignore):
+			ignored = append(ignored, t)
+		case t.matches(d.Config.NoData):
+			noData = append(noData, t)
+		case rewritten || filtered:
+			sanitised = append(sanitised, t)
+		}
+	}
+
+	// the columns are all checked before anything is written, so that a rewrite of a missing column fails straight away
+	columns := map[postgresTable][]string{}
+	for _, t := range sanitised {
+		if columns[t], err = d.columns(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	if err := d.pgDump(ctx, w, "pre-data", ignored, nil); err != nil {
+		return err
+	}
+	if err := d.pgDump(ctx, w, "data", ignored, append(noData, sanitised...)); err != nil {
+		return err
+	}
+	for _, t := range sanitised {
+		columns := columns[t]
+		quoted := []string{}
+		for _, column := range columns {
+			quoted = append(quoted, quotePostgresIdentifier(column))
+		}
+		fmt.Fprintf(w, "\n--\n-- Sanitised data for %s\n--\n\n", t.identifier())
+		fmt.Fprintf(w, "COPY %s (%s) FROM stdin;\n", t.identifier(), strings.Join(quoted, ", "))
+		err = d.Executor.Run(ctx, Command{
+			Name:   "psql",
+			Args:   append([]string{"-X", "-q", "-v", "ON_ERROR_STOP=1"}, d.inSnapshot(fmt.Sprintf("COPY (%s) TO STDOUT", d.sanitisedSelect(t, columns)))...),
+			Env:    d.environment(),
+			Stdout: w,
+			Stderr: d.Stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to export the sanitised rows of %s: %v", t.identifier(), err)
+		}
+		fmt.Fprint(w, "\\.\n\n")
+	}
+	return d.pgDump(ctx, w, "post-data", ignored, nil)
+}
@@ -0,0 +1,168 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+// postgresSnapshotSession is the psql session that exports the snapshot the dump is read in
+const postgresSnapshotSession = "psql -X -q -A -t -v ON_ERROR_STOP=1"
+
+func Test_postgresDump_Dump(t *testing.T) {
+	exec := &fakeExecutor{
+		output: map[string]string{
+			postgresSnapshotSession:                "snapshot=00000003-0000001B-1\n",
+			"FROM pg_catalog.pg_tables":            "public\tusers\npublic\tcache_data\npublic\tcache_menu\npublic\tnode\npublic\twatchdog\n",
+			"table_name = 'users'":                 "uid\nname\nmail\n",
+			"table_name = 'node'":                  "nid\nvid\n",
+			"--section=pre-data":                   "-- pre-data\n",
+			"--section=data":                       "-- data\n",
+			"--section=post-data":                  "-- post-data\n",
+			`COPY (SELECT "uid", "name", ('user' `: "1\tuser1\tuser1@example.com\n",
+			`COPY (SELECT "nid", "vid" FROM`:       "1\t1\n",
+		},
+	}
+	dump := postgresDump{
+		Executor: exec,
+		MTK:      MTK{Host: "pghost", Username: "pguser", Password: "pgpass", Database: "pgdb"},
+		Config: MTKConfig{
+			Rewrite: map[string]map[string]string{
+				"users": {"mail": "'user' || uid || '@example.com'"},
+			},
+			Where: map[string]string{
+				"public.node": "nid < 100",
+			},
+			NoData: []string{"cache*"},
+			Ignore: []string{"watchdog"},
+		},
+		Stderr: &bytes.Buffer{},
+	}
+	var out bytes.Buffer
+	if err := dump.Dump(context.Background(), &out); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	// every run reads the snapshot exported by the session that is held open until the end of the dump
+	inSnapshot := func(statement string) string {
+		return "-c BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY -c SET TRANSACTION SNAPSHOT '00000003-0000001B-1' -c " + statement + " -c COMMIT"
+	}
+	wantCommands := []string{
+		postgresSnapshotSession,
+		"psql -X -q -A -t -F \t -v ON_ERROR_STOP=1 " + inSnapshot("SELECT schemaname, tablename FROM pg_catalog.pg_tables WHERE schemaname NOT IN ('pg_catalog', 'information_schema') ORDER BY schemaname, tablename"),
+		"psql -X -q -A -t -F \t -v ON_ERROR_STOP=1 " + inSnapshot("SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = 'users' AND is_generated = 'NEVER' ORDER BY ordinal_position"),
+		"psql -X -q -A -t -F \t -v ON_ERROR_STOP=1 " + inSnapshot("SELECT column_name FROM information_schema.columns WHERE table_schema = 'public' AND table_name = 'node' AND is_generated = 'NEVER' ORDER BY ordinal_position"),
+		`pg_dump --no-owner --no-privileges --section=pre-data --snapshot=00000003-0000001B-1 --exclude-table="public"."watchdog"`,
+		`pg_dump --no-owner --no-privileges --section=data --snapshot=00000003-0000001B-1 --exclude-table="public"."watchdog" --exclude-table-data="public"."cache_data" --exclude-table-data="public"."cache_menu" --exclude-table-data="public"."users" --exclude-table-data="public"."node"`,
+		"psql -X -q -v ON_ERROR_STOP=1 " + inSnapshot(`COPY (SELECT "uid", "name", ('user' || uid || '@example.com') AS "mail" FROM "public"."users") TO STDOUT`),
+		"psql -X -q -v ON_ERROR_STOP=1 " + inSnapshot(`COPY (SELECT "nid", "vid" FROM "public"."node" WHERE nid < 100) TO STDOUT`),
+		`pg_dump --no-owner --no-privileges --section=post-data --snapshot=00000003-0000001B-1 --exclude-table="public"."watchdog"`,
+	}
+	if !reflect.DeepEqual(exec.commands, wantCommands) {
+		t.Errorf("Dump() commands = \n%v\nwant\n%v", strings.Join(exec.commands, "\n"), strings.Join(wantCommands, "\n"))
+	}
+	wantStdin := []string{"BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\nSELECT 'snapshot=' || pg_export_snapshot();\nCOMMIT;\n"}
+	if !reflect.DeepEqual(exec.stdin, wantStdin) {
+		t.Errorf("Dump() snapshot session stdin = %q, want %q", exec.stdin, wantStdin)
+	}
+	wantDump := `-- pre-data
+-- data
+
+--
+-- Sanitised data for "public"."users"
+--
+
+COPY "public"."users" ("uid", "name", "mail") FROM stdin;
+1	user1	user1@example.com
+\.
+
+
+--
+-- Sanitised data for "public"."node"
+--
+
+COPY "public"."node" ("nid", "vid") FROM stdin;
+1	1
+\.
+
+-- post-data
+`
+	if out.String() != wantDump {
+		t.Errorf("Dump() = \n%v\nwant\n%v", out.String(), wantDump)
+	}
+}
+
+func Test_postgresDump_Dump_missingRewriteColumn(t *testing.T) {
+	exec := &fakeExecutor{
+		output: map[string]string{
+			postgresSnapshotSession:     "snapshot=00000003-0000001B-1\n",
+			"FROM pg_catalog.pg_tables": "public\tusers\n",
+			"table_name = 'users'":      "uid\nname\nmail\n",
+		},
+	}
+	dump := postgresDump{
+		Executor: exec,
+		MTK:      MTK{Host: "pghost", Username: "pguser", Password: "pgpass", Database: "pgdb"},
+		Config: MTKConfig{
+			Rewrite: map[string]map[string]string{
+				"users": {"mail": "'sanitised'", "pass": "'sanitised'", "emial": "'sanitised'"},
+			},
+		},
+		Stderr: &bytes.Buffer{},
+	}
+	var out bytes.Buffer
+	err := dump.Dump(context.Background(), &out)
+	want := `unable to dump "public"."users": the rewritten columns emial, pass don't exist or are generated columns`
+	if err == nil || err.Error() != want {
+		t.Fatalf("Dump() error = %v, want %v", err, want)
+	}
+	// nothing is dumped, so the real values of the table can't end up in the image
+	if out.Len() != 0 {
+		t.Errorf("Dump() wrote %q before failing", out.String())
+	}
+	for _, cmd := range exec.commands {
+		if strings.HasPrefix(cmd, "pg_dump") || strings.Contains(cmd, "COPY") {
+			t.Errorf("Dump() ran %s after finding the missing columns", cmd)
+		}
+	}
+}
+
+func Test_Pipeline_Run_postgres(t *testing.T) {
+	envvars, _ := json.Marshal([]variables.LagoonEnvironmentVariable{
+		{Name: "BUILDER_BACKUP_IMAGE_TYPE", Value: "postgres", Scope: "global"},
+		{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+		{Name: "BUILDER_REGISTRY_PASSWORD", Value: "regpass", Scope: "global"},
+		{Name: "BUILDER_MTK_YAML_BASE64", Value: base64.StdEncoding.EncodeToString([]byte("nodata:\n  - cache*\n")), Scope: "global"},
+	})
+	t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+	t.Setenv("LAGOON_PROJECT", "lagpro")
+	t.Setenv("LAGOON_ENVIRONMENT", "lagenv")
+	t.Setenv("POSTGRES_HOSTNAME", "pghost")
+	t.Setenv("POSTGRES_USERNAME", "pguser")
+	t.Setenv("POSTGRES_DATABASE", "pgdb")
+	exec := &fakeExecutor{
+		output: map[string]string{
+			postgresSnapshotSession:     "snapshot=00000003-0000001B-1\n",
+			"FROM pg_catalog.pg_tables": "public\tcache_data\npublic\tnode\n",
+			"pg_dump --version":         "pg_dump (PostgreSQL) 14.13\n",
+		},
+	}
+	p, engine, _ := newTestPipeline(t, exec)
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := `pg_dump --no-owner --no-privileges --section=data --snapshot=00000003-0000001B-1 --exclude-table-data="public"."cache_data"`
+	if !slices.Contains(exec.commands, want) {
+		t.Errorf("Run() did not run %v, ran\n%v", want, strings.Join(exec.commands, "\n"))
+	}
+	wantDocker := `POST /build buildargs={"BUILDER_IMAGE":"postgres:14-alpine","CLEAN_IMAGE":"uselagoon/postgres-14-drupal:latest","DATA_DIR":"/var/lib/postgresql/data","DATA_UID":"70","RESULT_DATABASE":"drupal","RESULT_PASSWORD":"drupal","RESULT_USER":"drupal"} dockerfile=postgres.Dockerfile networkmode=host t=lagpro/lagenv:latest t=lagpro/lagenv:backup-2026-10-18`
+	if !slices.Contains(engine.Requests(), wantDocker) {
+		t.Errorf("Run() did not request %v, requested\n%v", wantDocker, strings.Join(engine.Requests(), "\n"))
+	}
+}
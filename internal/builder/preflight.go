@@ -0,0 +1,286 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// preflightTimeout is how long the database has to answer the preflight checks
+const preflightTimeout = 30 * time.Second
+
+// pgDumpVersionRegexp matches the major version in the output of pg_dump --version
+var pgDumpVersionRegexp = regexp.MustCompile(`\(PostgreSQL\) (\d+)`)
+
+// writePrivileges are the privileges that let the database user change the database that is being dumped
+var writePrivileges = []string{"INSERT", "UPDATE", "DELETE", "CREATE", "DROP", "ALTER", "TRUNCATE", "SUPERUSER"}
+
+// databaseInfo is what the preflight checks read from the database that is being dumped
+type databaseInfo struct {
+	// Exists is false if the database isn't on the server
+	Exists bool
+	// Size is the size of the data and indexes in bytes
+	Size uint64
+	// Privileges are the privileges that the user has on the database, only the ones that allow writes are used
+	Privileges []string
+	// ServerVersion is the major version of the server, it is only read for postgres
+	ServerVersion string
+}
+
+// canWrite returns the privileges that allow the user to change the database
+func (i databaseInfo) canWrite() []string {
+	privileges := []string{}
+	for _, privilege := range i.Privileges {
+		if slices.Contains(writePrivileges, strings.ToUpper(privilege)) && !slices.Contains(privileges, privilege) {
+			privileges = append(privileges, privilege)
+		}
+	}
+	return privileges
+}
+
+// formatSize formats a size in bytes with decimal units, the same as the docker cli
+func formatSize(size uint64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// inspectDatabase connects to the database with the resolved MTK values and reads its size and the privileges of the
+// user, mariadb and mysql databases are connected to directly and postgres databases with psql
+func (p *Pipeline) inspectDatabase(ctx context.Context) (*databaseInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if p.Build.DatabaseType == "postgres" {
+		return postgresDump{Executor: p.Executor, MTK: p.Build.MTK, Stderr: p.Stderr}.inspect(ctx)
+	}
+	cfg, err := p.Build.MTK.mysqlConfig(preflightTimeout)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	info := &databaseInfo{}
+	var name string
+	err = db.QueryRowContext(ctx, "SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", p.Build.MTK.Database).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return info, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info.Exists = true
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(SUM(DATA_LENGTH + INDEX_LENGTH), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?", p.Build.MTK.Database).Scan(&info.Size)
+	if err != nil {
+		return nil, err
+	}
+	// the schema privileges can be granted on a pattern, eg drupal\_%, so the database is matched with LIKE
+	grantee := `CONCAT("'", SUBSTRING_INDEX(CURRENT_USER(), '@', 1), "'@'", SUBSTRING_INDEX(CURRENT_USER(), '@', -1), "'")`
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT PRIVILEGE_TYPE FROM information_schema.USER_PRIVILEGES WHERE GRANTEE = %[1]s
+UNION SELECT PRIVILEGE_TYPE FROM information_schema.SCHEMA_PRIVILEGES WHERE GRANTEE = %[1]s AND ? LIKE TABLE_SCHEMA
+UNION SELECT PRIVILEGE_TYPE FROM information_schema.TABLE_PRIVILEGES WHERE GRANTEE = %[1]s AND TABLE_SCHEMA = ?`, grantee),
+		p.Build.MTK.Database, p.Build.MTK.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var privilege string
+		if err := rows.Scan(&privilege); err != nil {
+			return nil, err
+		}
+		info.Privileges = append(info.Privileges, privilege)
+	}
+	return info, rows.Err()
+}
+
+// inspect reads the size of the database and the privileges of the user with psql, psql fails to connect if the
+// database doesn't exist, so pg_database is checked first from the postgres database, if the user can't connect to
+// that the database is connected to directly
+func (d postgresDump) inspect(ctx context.Context) (*databaseInfo, error) {
+	maintenance := d
+	maintenance.MTK.Database = "postgres"
+	maintenance.Stderr = io.Discard
+	rows, err := maintenance.query(ctx, fmt.Sprintf("SELECT datname FROM pg_catalog.pg_database WHERE datname = %s", quotePostgresLiteral(d.MTK.Database)))
+	if err == nil && len(rows) == 0 {
+		return &databaseInfo{}, nil
+	}
+	rows, err = d.query(ctx, "SELECT pg_database_size(current_database()), current_setting('server_version_num')::int / 10000")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 1 {
+		return nil, fmt.Errorf("unable to read the size of the database")
+	}
+	sizeValue, serverVersion, _ := strings.Cut(rows[0], "\t")
+	size, err := strconv.ParseUint(sizeValue, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the size of the database %q: %v", sizeValue, err)
+	}
+	privileges, err := d.query(ctx, `SELECT DISTINCT privilege_type FROM information_schema.table_privileges WHERE grantee = current_user AND table_schema NOT IN ('pg_catalog', 'information_schema')
+UNION SELECT 'CREATE' WHERE has_database_privilege(current_database(), 'CREATE')
+UNION SELECT 'SUPERUSER' FROM pg_catalog.pg_roles WHERE rolname = current_user AND rolsuper
+ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	return &databaseInfo{Exists: true, Size: size, Privileges: privileges, ServerVersion: serverVersion}, nil
+}
+
+// pgDumpVersion returns the major version of pg_dump, from its version eg `pg_dump (PostgreSQL) 17.2`
+func (d postgresDump) pgDumpVersion(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	err := d.Executor.Run(ctx, Command{
+		Name:   "pg_dump",
+		Args:   []string{"--version"},
+		Stdout: &out,
+		Stderr: d.Stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to run pg_dump: %v", err)
+	}
+	m := pgDumpVersionRegexp.FindStringSubmatch(out.String())
+	if m == nil {
+		return "", fmt.Errorf("unable to read the version of pg_dump from %q", strings.TrimSpace(out.String()))
+	}
+	return m[1], nil
+}
+
+// postgresVersions checks that pg_dump can dump the server, and that the builder image can import what it writes,
+// pg_dump refuses to dump a newer server, and a newer pg_dump writes settings that an older server doesn't have, eg
+// pg_dump 17 writes SET transaction_timeout, which fails the import with ON_ERROR_STOP
+func (p *Pipeline) postgresVersions(ctx context.Context, info *databaseInfo) error {
+	client, err := postgresDump{Executor: p.Executor, Stderr: p.Stderr}.pgDumpVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.Stdout, "pg_dump is postgres %s\n", client)
+	server, _ := strconv.Atoi(info.ServerVersion)
+	if dump, _ := strconv.Atoi(client); server > dump {
+		return fmt.Errorf("the server is postgres %s but pg_dump is postgres %s, pg_dump can't dump a newer server", info.ServerVersion, client)
+	}
+	if builder, ok := parseImageVersion(p.Build.SourceImageName); ok && builder.Version != client {
+		return fmt.Errorf("pg_dump is postgres %s but the builder image %s is %s, the dump can only be imported by the same version, run the task in an image with the postgres %s client or use the builder image for postgres %s",
+			client, p.Build.SourceImageName, builder, builder.Version, client)
+	}
+	return nil
+}
+
+// existingDir returns the path, or the closest parent of it that exists, so that the free space can be checked before
+// a directory has been created
+func existingDir(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			return path
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// preflight checks the database before anything is dumped, so that bad credentials, a missing database or running out
+// of space are found straight away instead of part way through the task
+//
+// the dump is assumed to be about the size of the data and indexes, the working directory needs to hold the dump, and
+// the docker host needs to hold it in the build context, the builder image and the clean image
+func (p *Pipeline) preflight(ctx context.Context) error {
+	mtk := p.Build.MTK
+	location := mtk.address(p.Build.DatabaseType)
+	if mtk.Socket != "" {
+		location = mtk.Socket
+	}
+	info, err := p.InspectDatabase(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to connect to %s as %s: %w", location, mtk.Username, err)
+	}
+	if !info.Exists {
+		return fmt.Errorf("the database %s doesn't exist on %s", mtk.Database, location)
+	}
+	fmt.Fprintf(p.Stdout, "database %s on %s has %s of data and indexes\n", mtk.Database, location, formatSize(info.Size))
+	var errs []error
+	space := func(name, path string, needed uint64) {
+		free, err := freeSpace(existingDir(path))
+		if err != nil {
+			fmt.Fprintf(p.Stdout, "unable to check the free space of the %s %s: %v\n", name, path, err)
+			return
+		}
+		fmt.Fprintf(p.Stdout, "the %s %s has %s free\n", name, path, formatSize(free))
+		if free < needed {
+			errs = append(errs, fmt.Errorf("the %s %s has %s free, but needs about %s", name, path, formatSize(free), formatSize(needed)))
+		}
+	}
+	space("working directory", p.WorkDir, info.Size)
+	switch p.Build.BuildBackend {
+	case "docker":
+		if err := p.dockerHostSpace(ctx, 3*info.Size); err != nil {
+			errs = append(errs, err)
+		}
+	case "oci":
+		space("data directory", p.Build.DataDir, info.Size)
+		if err := p.ociImportTools(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.Build.DatabaseType == "postgres" {
+		if err := p.postgresVersions(ctx, info); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if privileges := info.canWrite(); len(privileges) > 0 {
+		// the dump only reads the database, so a user that can't change it can't damage it either
+		fmt.Fprintf(p.Stdout, "warning: %s can change the database %s (%s), a read-only user is recommended for dumps\n",
+			mtk.Username, mtk.Database, strings.Join(privileges, ", "))
+	}
+	return errors.Join(errs...)
+}
+
+// dockerHostSpace checks the free space on the docker host, only some storage drivers report it, eg devicemapper but
+// not overlay2, and there is no other way to read it over the engine api, so it only fails if the free space is known
+// and there isn't enough
+func (p *Pipeline) dockerHostSpace(ctx context.Context, needed uint64) error {
+	backend, ok := p.backend().(*dockerBackend)
+	if !ok {
+		return nil
+	}
+	client, err := backend.dockerClient()
+	if err != nil {
+		fmt.Fprintf(p.Stdout, "unable to check the free space of the docker host: %v\n", err)
+		return nil
+	}
+	info, err := client.Info(ctx)
+	if err != nil {
+		fmt.Fprintf(p.Stdout, "unable to check the free space of the docker host: %v\n", err)
+		return nil
+	}
+	free, ok := info.SpaceAvailable()
+	if !ok {
+		fmt.Fprintf(p.Stdout, "unable to check the free space of the docker host, the %s storage driver doesn't report it\n", info.Driver)
+		return nil
+	}
+	fmt.Fprintf(p.Stdout, "the docker host has %s free\n", formatSize(free))
+	if free < needed {
+		return fmt.Errorf("the docker host has %s free, but needs about %s", formatSize(free), formatSize(needed))
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_Pipeline_preflight(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		backend      string
+		info         *databaseInfo
+		inspectErr   error
+		driverStatus [][2]string
+		dbType       string
+		sourceImage  string
+		pgDump       string
+		entrypoint   bool
+		missing      []string
+		dataFiles    []string
+		wantErr      string
+		wantOutput   []string
+		wantNot      []string
+	}{
+		{
+			name:        "test1",
+			description: "check a read-only user with enough space passes without a warning",
+			backend:     "docker",
+			info:        &databaseInfo{Exists: true, Size: 1500000, Privileges: []string{"SELECT", "LOCK TABLES", "SHOW VIEW"}},
+			wantOutput: []string{
+				"database dbname on dbhost:3307 has 1.5 MB of data and indexes",
+				"unable to check the free space of the docker host, the overlay2 storage driver doesn't report it",
+			},
+			wantNot: []string{"warning:"},
+		},
+		{
+			name:        "test2",
+			description: "check a user that can change the database is warned about",
+			backend:     "kaniko",
+			info:        &databaseInfo{Exists: true, Size: 512, Privileges: []string{"SELECT", "INSERT", "DELETE", "INSERT"}},
+			wantOutput: []string{
+				"database dbname on dbhost:3307 has 512 B of data and indexes",
+				"warning: dbuser can change the database dbname (INSERT, DELETE), a read-only user is recommended for dumps",
+			},
+		},
+		{
+			name:        "test3",
+			description: "check a database that doesn't exist fails",
+			backend:     "docker",
+			info:        &databaseInfo{},
+			wantErr:     "the database dbname doesn't exist on dbhost:3307",
+		},
+		{
+			name:        "test4",
+			description: "check bad credentials fail",
+			backend:     "docker",
+			inspectErr:  errors.New("Error 1045 (28000): Access denied for user 'dbuser'@'10.0.0.1' (using password: YES)"),
+			wantErr:     "unable to connect to dbhost:3307 as dbuser: Error 1045 (28000): Access denied",
+		},
+		{
+			name:        "test5",
+			description: "check a database that won't fit in the working directory fails",
+			backend:     "kaniko",
+			info:        &databaseInfo{Exists: true, Size: 1 << 62},
+			wantErr:     "free, but needs about 4611.7 PB",
+			wantOutput:  []string{"database dbname on dbhost:3307 has 4611.7 PB of data and indexes"},
+		},
+		{
+			name:        "test6",
+			description: "check a database that won't fit on the docker host fails when the storage driver reports the space",
+			backend:     "docker",
+			info:        &databaseInfo{Exists: true, Size: 4000000000},
+			driverStatus: [][2]string{
+				{"Data Space Available", "10.74 GB"},
+			},
+			wantErr:    "the docker host has 10.7 GB free, but needs about 12.0 GB",
+			wantOutput: []string{"the docker host has 10.7 GB free"},
+		},
+		{
+			name:        "test7",
+			description: "check the oci backend passes in an image based on the builder image",
+			backend:     "oci",
+			info:        &databaseInfo{Exists: true, Size: 512},
+			entrypoint:  true,
+			wantOutput:  []string{"the data directory"},
+		},
+		{
+			name:        "test8",
+			description: "check the oci backend fails without the entrypoint and server of the builder image",
+			backend:     "oci",
+			info:        &databaseInfo{Exists: true, Size: 512},
+			missing:     []string{"mysqld"},
+			wantErr:     "docker-entrypoint.sh, mysqld can't be found, the task needs to run in an image based on mariadb:10.6",
+		},
+		{
+			name:        "test9",
+			description: "check the oci backend doesn't need the builder image if the data directory already has a database",
+			backend:     "oci",
+			info:        &databaseInfo{Exists: true, Size: 512},
+			missing:     []string{"bash", "mysqld"},
+			dataFiles:   []string{"ibdata1"},
+		},
+		{
+			name:        "test10",
+			description: "check a postgres dump passes when pg_dump is the same version as the builder image and server",
+			backend:     "docker",
+			dbType:      "postgres",
+			sourceImage: "postgres:14-alpine",
+			pgDump:      "pg_dump (PostgreSQL) 14.13\n",
+			info:        &databaseInfo{Exists: true, Size: 512, ServerVersion: "14"},
+			wantOutput:  []string{"pg_dump is postgres 14"},
+		},
+		{
+			name:        "test11",
+			description: "check a postgres dump fails when pg_dump is newer than the builder image",
+			backend:     "docker",
+			dbType:      "postgres",
+			sourceImage: "postgres:14-alpine",
+			pgDump:      "pg_dump (PostgreSQL) 17.2\n",
+			info:        &databaseInfo{Exists: true, Size: 512, ServerVersion: "14"},
+			wantErr:     "pg_dump is postgres 17 but the builder image postgres:14-alpine is postgres 14, the dump can only be imported by the same version",
+		},
+		{
+			name:        "test12",
+			description: "check a postgres dump fails when the server is newer than pg_dump",
+			backend:     "docker",
+			dbType:      "postgres",
+			sourceImage: "postgres:16-alpine",
+			pgDump:      "pg_dump (PostgreSQL) 15.8\n",
+			info:        &databaseInfo{Exists: true, Size: 512, ServerVersion: "16"},
+			wantErr:     "the server is postgres 16 but pg_dump is postgres 15, pg_dump can't dump a newer server",
+		},
+		{
+			name:        "test13",
+			description: "check a postgres dump with a custom builder image only checks the server version",
+			backend:     "docker",
+			dbType:      "postgres",
+			sourceImage: "registry.example.com/postgres-custom:latest",
+			pgDump:      "pg_dump (PostgreSQL) 17.2\n",
+			info:        &databaseInfo{Exists: true, Size: 512, ServerVersion: "16"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, engine, out := newTestPipeline(t, &fakeExecutor{output: map[string]string{"pg_dump --version": tt.pgDump}})
+			engine.DriverStatus = tt.driverStatus
+			if tt.dbType == "" {
+				tt.dbType, tt.sourceImage = "mariadb", "mariadb:10.6"
+			}
+			p.Build = Builder{
+				DatabaseType:    tt.dbType,
+				BuildBackend:    tt.backend,
+				DockerHost:      engine.URL,
+				SourceImageName: tt.sourceImage,
+				DataDir:         filepath.Join(t.TempDir(), "initialized-db"),
+				MTK:             MTK{Host: "dbhost", Port: "3307", Username: "dbuser", Database: "dbname"},
+			}
+			for _, file := range tt.dataFiles {
+				os.MkdirAll(p.Build.DataDir, 0700)
+				os.WriteFile(filepath.Join(p.Build.DataDir, file), nil, 0600)
+			}
+			p.Entrypoint = filepath.Join(t.TempDir(), "docker-entrypoint.sh")
+			if tt.entrypoint {
+				os.WriteFile(p.Entrypoint, nil, 0755)
+			}
+			p.LookPath = func(file string) (string, error) {
+				if slices.Contains(tt.missing, file) {
+					return "", fmt.Errorf("exec: %q: executable file not found in $PATH", file)
+				}
+				return filepath.Join("/usr/bin", file), nil
+			}
+			p.InspectDatabase = func(ctx context.Context) (*databaseInfo, error) {
+				return tt.info, tt.inspectErr
+			}
+			err := p.preflight(context.Background())
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("preflight() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("preflight() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("preflight() output is missing %q:\n%v", want, out.String())
+				}
+			}
+			for _, not := range tt.wantNot {
+				if strings.Contains(out.String(), not) {
+					t.Errorf("preflight() output shouldn't contain %q:\n%v", not, out.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_postgresDump_inspect(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		output       map[string]string
+		failures     map[string]int
+		want         *databaseInfo
+		wantCommands int
+	}{
+		{
+			name:        "test1",
+			description: "check the size and privileges are read once the database is found in pg_database",
+			output: map[string]string{
+				"pg_database WHERE datname = 'dbname'": "dbname\n",
+				"pg_database_size":                     "7897088\t14\n",
+				"table_privileges":                     "CREATE\nINSERT\nSELECT\n",
+			},
+			want:         &databaseInfo{Exists: true, Size: 7897088, Privileges: []string{"CREATE", "INSERT", "SELECT"}, ServerVersion: "14"},
+			wantCommands: 3,
+		},
+		{
+			name:         "test2",
+			description:  "check a database that isn't in pg_database doesn't exist, without connecting to it",
+			output:       map[string]string{},
+			want:         &databaseInfo{},
+			wantCommands: 1,
+		},
+		{
+			name:        "test3",
+			description: "check the database is connected to directly if the user can't connect to the postgres database",
+			output: map[string]string{
+				"pg_database_size": "7897088\t14\n",
+				"table_privileges": "SELECT\n",
+			},
+			failures:     map[string]int{"pg_database WHERE": 1},
+			want:         &databaseInfo{Exists: true, Size: 7897088, Privileges: []string{"SELECT"}, ServerVersion: "14"},
+			wantCommands: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &fakeExecutor{output: tt.output, failures: tt.failures}
+			d := postgresDump{Executor: exec, MTK: MTK{Host: "dbhost", Database: "dbname"}}
+			got, err := d.inspect(context.Background())
+			if err != nil {
+				t.Fatalf("inspect() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inspect() = %+v, want %+v", got, tt.want)
+			}
+			if len(exec.commands) != tt.wantCommands {
+				t.Fatalf("inspect() ran %v, want %d psql queries", exec.commands, tt.wantCommands)
+			}
+			if !slices.Contains(exec.env[0], "PGDATABASE=postgres") {
+				t.Errorf("inspect() checked pg_database with %v, want the postgres database", exec.env[0])
+			}
+			for _, env := range exec.env[1:] {
+				if !slices.Contains(env, "PGDATABASE=dbname") {
+					t.Errorf("inspect() connected with %v, want the dbname database", env)
+				}
+			}
+		})
+	}
+}
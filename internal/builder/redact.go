@@ -0,0 +1,47 @@
+package builder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// redactedValue is displayed in place of any secret value
+const redactedValue = "********"
+
+// Redacted returns a copy of the Builder with every field tagged as a secret replaced with a placeholder
+// empty secrets are left empty so that it is still clear when one hasn't been set
+func (b Builder) Redacted() Builder {
+	redacted := b
+	walkFields(reflect.ValueOf(&redacted).Elem(), "", func(path string, field reflect.StructField, value reflect.Value) {
+		if isSecret(field) && value.Kind() == reflect.String && value.String() != "" {
+			value.SetString(redactedValue)
+		}
+	})
+	return redacted
+}
+
+func isSecret(field reflect.StructField) bool {
+	return field.Tag.Get("secret") == "true"
+}
+
+// walkFields calls fn for each exported field of a struct using the json name of the field as the path
+// nested structs are walked with their json name as a prefix
+func walkFields(v reflect.Value, prefix string, fn func(path string, field reflect.StructField, value reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		if prefix != "" {
+			name = fmt.Sprintf("%s.%s", prefix, name)
+		}
+		if field.Type.Kind() == reflect.Struct {
+			walkFields(v.Field(i), name, fn)
+			continue
+		}
+		fn(name, field, v.Field(i))
+	}
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+func Test_Builder_Redacted(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		build       Builder
+		want        Builder
+	}{
+		{
+			name:        "test1",
+			description: "check that the registry and database passwords are redacted",
+			build: Builder{
+				RegistryUsername: "reguser",
+				RegistryPassword: "regpass",
+				MTK:              MTK{Username: "dbuser", Password: "dbpass"},
+			},
+			want: Builder{
+				RegistryUsername: "reguser",
+				RegistryPassword: redactedValue,
+				MTK:              MTK{Username: "dbuser", Password: redactedValue},
+			},
+		},
+		{
+			name:        "test2",
+			description: "check that empty secrets are left empty",
+			build: Builder{
+				RegistryUsername: "reguser",
+				MTK:              MTK{Username: "dbuser"},
+			},
+			want: Builder{
+				RegistryUsername: "reguser",
+				MTK:              MTK{Username: "dbuser"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.build
+			if got := tt.build.Redacted(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Redacted() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(original, tt.build) {
+				t.Errorf("Redacted() modified the original Builder")
+			}
+		})
+	}
+}
+
+func Test_Pipeline_debugOutputIsRedacted(t *testing.T) {
+	envvars, _ := json.Marshal([]variables.LagoonEnvironmentVariable{
+		{Name: "BUILDER_IMAGE_DEBUG", Value: "true", Scope: "global"},
+		{Name: "BUILDER_REGISTRY_USERNAME", Value: "reguser", Scope: "global"},
+		{Name: "BUILDER_REGISTRY_PASSWORD", Value: "supersecretregpass", Scope: "global"},
+		{Name: "BUILDER_MTK_HOSTNAME", Value: "dbhost", Scope: "global"},
+		{Name: "BUILDER_MTK_USERNAME", Value: "dbuser", Scope: "global"},
+		{Name: "BUILDER_MTK_PASSWORD", Value: "supersecretdbpass", Scope: "global"},
+		{Name: "BUILDER_MTK_DATABASE", Value: "dbname", Scope: "global"},
+	})
+	t.Setenv("LAGOON_ENVIRONMENT_VARIABLES", string(envvars))
+	t.Setenv("LAGOON_PROJECT", "lagpro")
+	t.Setenv("LAGOON_ENVIRONMENT", "lagenv")
+	p, _, out := newTestPipeline(t, &fakeExecutor{})
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if strings.Contains(out.String(), "supersecret") {
+		t.Errorf("Run() leaked a secret in the debug output:\n%v", out.String())
+	}
+	if !strings.Contains(out.String(), `"password": "`+redactedValue+`"`) {
+		t.Errorf("Run() debug output is missing the redacted database password:\n%v", out.String())
+	}
+}
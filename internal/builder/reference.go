@@ -0,0 +1,184 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// supportedRegistryTypes are the values that BUILDER_REGISTRY_TYPE can be set to, auto works it out from the registry
+// host of the resulting image, harbor can't be detected so it has to be set
+var supportedRegistryTypes = []string{"auto", "dockerhub", "quay", "ghcr", "harbor", "ecr", "generic"}
+
+// registryRules are the limits a registry puts on repository names on top of the distribution grammar
+type registryRules struct {
+	// Name is used in the validation errors
+	Name string
+	// MinComponents and MaxComponents are how many path components the registry accepts, a MaxComponents of 0 is no limit
+	MinComponents int
+	MaxComponents int
+	// MaxLength is the longest path, without the registry host, that the registry accepts
+	MaxLength int
+	// Namespace is what the first component has to match if it is set, described by NamespaceHint
+	Namespace     *regexp.Regexp
+	NamespaceHint string
+	// Path is what the whole path has to match if it is set, described by PathHint
+	Path     *regexp.Regexp
+	PathHint string
+}
+
+// registries are the rules for each of the registry types, generic is only the distribution grammar
+var registries = map[string]registryRules{
+	"dockerhub": {
+		Name:          "docker hub",
+		MinComponents: 2,
+		MaxComponents: 2,
+		MaxLength:     255,
+		Namespace:     regexp.MustCompile(`^[a-z0-9]{4,30}$`),
+		NamespaceHint: "4 to 30 lowercase letters and digits",
+	},
+	"quay": {
+		Name:          "quay",
+		MinComponents: 2,
+		MaxComponents: 2,
+		MaxLength:     255,
+		Namespace:     regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{1,254}$`),
+		NamespaceHint: "2 to 255 lowercase letters, digits, underscores, periods and dashes",
+	},
+	"ghcr": {
+		Name:          "ghcr",
+		MinComponents: 2,
+		MaxLength:     255,
+		Namespace:     regexp.MustCompile(`^[a-z0-9](?:-?[a-z0-9]){0,38}$`),
+		NamespaceHint: "a github user or organization, at most 39 lowercase letters, digits and single dashes",
+	},
+	"harbor": {
+		Name:          "harbor",
+		MinComponents: 2,
+		MaxLength:     255,
+	},
+	"ecr": {
+		Name:          "ecr",
+		MinComponents: 1,
+		MaxLength:     256,
+		Path:          regexp.MustCompile(`^(?:[a-z0-9]+(?:[._-][a-z0-9]+)*/)*[a-z0-9]+(?:[._-][a-z0-9]+)*$`),
+		PathHint:      "components must be lowercase letters and digits separated by a single period, underscore or dash",
+	},
+	"generic": {
+		Name:          "the registry",
+		MinComponents: 1,
+		MaxLength:     255,
+	},
+}
+
+var (
+	ecrDomainRegexp = regexp.MustCompile(`\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(?:\.cn)?$`)
+	// invalidPathRegexp and separatorRunRegexp are used to normalise a path component to the distribution grammar
+	invalidPathRegexp  = regexp.MustCompile(`[^a-z0-9._-]+`)
+	separatorRunRegexp = regexp.MustCompile(`[._-]{2,}`)
+	invalidTagRegexp   = regexp.MustCompile(`[^\w.-]+`)
+)
+
+// splitRegistry splits the registry host from a repository name, the configured registry host is treated as a host even
+// if docker wouldn't, eg a registry without a period in its name
+func splitRegistry(name, registryHost string) (string, string) {
+	if registryHost != "" {
+		if path, ok := strings.CutPrefix(name, registryHost+"/"); ok {
+			return registryHost, path
+		}
+	}
+	return splitDomain(name)
+}
+
+// detectRegistryType works out the type of the registry from its host
+func detectRegistryType(domain string) string {
+	switch {
+	case domain == "" || domain == "docker.io" || domain == "index.docker.io" || domain == "registry-1.docker.io":
+		return "dockerhub"
+	case domain == "quay.io":
+		return "quay"
+	case domain == "ghcr.io":
+		return "ghcr"
+	case ecrDomainRegexp.MatchString(domain):
+		return "ecr"
+	}
+	return "generic"
+}
+
+// resultRegistryType is the type of the registry that the resulting image is pushed to
+func (b Builder) resultRegistryType() string {
+	if b.RegistryType != "" && b.RegistryType != "auto" {
+		return b.RegistryType
+	}
+	domain, _ := splitRegistry(b.ResultImageName, b.RegistryHost)
+	return detectRegistryType(domain)
+}
+
+// normaliseRepository lower cases the path of a repository name and replaces anything that the distribution grammar
+// doesn't allow, the registry host is left as it is
+func normaliseRepository(name, registryHost string) string {
+	domain, path := splitRegistry(name, registryHost)
+	components := []string{}
+	for _, component := range strings.Split(path, "/") {
+		component = invalidPathRegexp.ReplaceAllString(strings.ToLower(component), "-")
+		component = separatorRunRegexp.ReplaceAllStringFunc(component, func(run string) string {
+			// two underscores or any number of dashes are allowed, anything else is reduced to its first separator
+			if run == "__" || strings.Trim(run, "-") == "" {
+				return run
+			}
+			return run[:1]
+		})
+		if component = strings.Trim(component, "._-"); component != "" {
+			components = append(components, component)
+		}
+	}
+	path = strings.Join(components, "/")
+	if domain != "" {
+		return fmt.Sprintf("%s/%s", domain, path)
+	}
+	return path
+}
+
+// normaliseTag replaces anything that isn't allowed in a tag with a dash, and cuts it down to 128 characters
+func normaliseTag(tag string) string {
+	tag = strings.TrimLeft(invalidTagRegexp.ReplaceAllString(tag, "-"), ".-")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
+
+// validateResultRepository checks the repository name of the resulting image against the distribution grammar and
+// the limits of the registry it is pushed to
+func validateResultRepository(name, registryHost, registryType string) error {
+	domain, path := splitRegistry(name, registryHost)
+	if registryHost != "" && domain == registryHost {
+		// the configured registry host might not look like a host to docker, so only the path is checked
+		if len(name) > maxRepositoryLength {
+			return fmt.Errorf("the name is %d characters long, it can be at most %d", len(name), maxRepositoryLength)
+		}
+		if err := validatePath(path); err != nil {
+			return err
+		}
+	} else if err := validateRepository(name); err != nil {
+		return err
+	}
+	rules, ok := registries[registryType]
+	if !ok {
+		return nil
+	}
+	components := strings.Split(path, "/")
+	switch {
+	case len(components) < rules.MinComponents:
+		return fmt.Errorf("%s needs at least %d path components, eg namespace/repository", rules.Name, rules.MinComponents)
+	case rules.MaxComponents > 0 && len(components) > rules.MaxComponents:
+		return fmt.Errorf("%s allows at most %d path components, eg namespace/repository", rules.Name, rules.MaxComponents)
+	case len(path) > rules.MaxLength:
+		return fmt.Errorf("%s allows at most %d characters in the repository name, not %d", rules.Name, rules.MaxLength, len(path))
+	case rules.Namespace != nil && !rules.Namespace.MatchString(components[0]):
+		return fmt.Errorf("%q is not a valid %s namespace, it must be %s", components[0], rules.Name, rules.NamespaceHint)
+	case rules.Path != nil && !rules.Path.MatchString(path):
+		return fmt.Errorf("%q is not a valid %s repository, %s", path, rules.Name, rules.PathHint)
+	}
+	return nil
+}
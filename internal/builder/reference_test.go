@@ -0,0 +1,171 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_normaliseRepository(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		repository   string
+		registryHost string
+		want         string
+	}{
+		{
+			name:        "test1",
+			description: "check a valid name is left as it is",
+			repository:  "quay.io/lagpro/lagenv__data-1",
+			want:        "quay.io/lagpro/lagenv__data-1",
+		},
+		{
+			name:        "test2",
+			description: "check the path is lower cased, and the registry host isn't",
+			repository:  "Registry.Example.com:5000/LagPro/Main",
+			want:        "Registry.Example.com:5000/lagpro/main",
+		},
+		{
+			name:         "test3",
+			description:  "check invalid characters, separator runs, leading separators and empty components are fixed",
+			repository:   "reghost//-lagpro/feature@branch..name_.x/",
+			registryHost: "reghost",
+			want:         "reghost/lagpro/feature-branch.name_x",
+		},
+		{
+			name:        "test4",
+			description: "check dashes and double underscores are kept",
+			repository:  "lagpro/a---b__c",
+			want:        "lagpro/a---b__c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normaliseRepository(tt.repository, tt.registryHost); got != tt.want {
+				t.Errorf("normaliseRepository() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_normaliseTag(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		tag         string
+		want        string
+	}{
+		{
+			name:        "test1",
+			description: "check a valid tag is left as it is",
+			tag:         "Backup_2026-10-18.1",
+			want:        "Backup_2026-10-18.1",
+		},
+		{
+			name:        "test2",
+			description: "check invalid characters and leading separators are replaced",
+			tag:         "-.feature/some branch",
+			want:        "feature-some-branch",
+		},
+		{
+			name:        "test3",
+			description: "check long tags are cut down to 128 characters",
+			tag:         strings.Repeat("a", 200),
+			want:        strings.Repeat("a", 128),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normaliseTag(tt.tag); got != tt.want {
+				t.Errorf("normaliseTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateResultRepository(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		repository   string
+		registryHost string
+		registryType string
+		wantErr      string
+	}{
+		{
+			name:         "test1",
+			description:  "check a docker hub repository",
+			repository:   "lagpro/lagenv",
+			registryType: "auto",
+		},
+		{
+			name:         "test2",
+			description:  "check docker hub only allows a namespace and repository",
+			repository:   "docker.io/lagpro/lagenv/mariadb",
+			registryType: "auto",
+			wantErr:      "docker hub allows at most 2 path components, eg namespace/repository",
+		},
+		{
+			name:         "test3",
+			description:  "check docker hub namespaces are at least 4 characters",
+			repository:   "lag/lagenv",
+			registryType: "auto",
+			wantErr:      `"lag" is not a valid docker hub namespace, it must be 4 to 30 lowercase letters and digits`,
+		},
+		{
+			name:         "test4",
+			description:  "check quay needs a namespace",
+			repository:   "quay.io/lagenv",
+			registryType: "auto",
+			wantErr:      "quay needs at least 2 path components, eg namespace/repository",
+		},
+		{
+			name:         "test5",
+			description:  "check ghcr allows nested repositories but checks the owner",
+			repository:   "ghcr.io/uselagoon--org/lagpro/lagenv",
+			registryType: "auto",
+			wantErr:      `"uselagoon--org" is not a valid ghcr namespace, it must be a github user or organization, at most 39 lowercase letters, digits and single dashes`,
+		},
+		{
+			name:         "test6",
+			description:  "check ecr doesn't allow double underscores",
+			repository:   "123456789012.dkr.ecr.ap-southeast-2.amazonaws.com/lagpro/lag__env",
+			registryType: "auto",
+			wantErr:      `"lagpro/lag__env" is not a valid ecr repository, components must be lowercase letters and digits separated by a single period, underscore or dash`,
+		},
+		{
+			name:         "test7",
+			description:  "check harbor has to be set, and needs a project",
+			repository:   "harbor.example.com/lagenv",
+			registryType: "harbor",
+			wantErr:      "harbor needs at least 2 path components, eg namespace/repository",
+		},
+		{
+			name:         "test8",
+			description:  "check the configured registry host is used even if it doesn't look like a host",
+			repository:   "reghost/lagpro/lagenv/mariadb-data",
+			registryHost: "reghost",
+			registryType: "auto",
+		},
+		{
+			name:         "test9",
+			description:  "check the whole name can't be longer than 255 characters",
+			repository:   "registry.example.com/lagpro/" + strings.Repeat("a", 240),
+			registryType: "auto",
+			wantErr:      "the name is 268 characters long, it can be at most 255",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Builder{ResultImageName: tt.repository, RegistryHost: tt.registryHost, RegistryType: tt.registryType}
+			err := validateResultRepository(tt.repository, tt.registryHost, b.resultRegistryType())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateResultRepository() error = %v", err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateResultRepository() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package builder
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// replicaProbeTimeout is how long each read replica has to accept a connection and answer the replication status
+const replicaProbeTimeout = 5 * time.Second
+
+// supportedReplicaStrategies are the values that BUILDER_READREPLICA_STRATEGY can be set to
+var supportedReplicaStrategies = []string{"first-healthy", "least-lag", "random", "primary-fallback"}
+
+// Replicas are the read replicas of the database, one of which is chosen to dump from when the build runs
+type Replicas struct {
+	// Hosts are the read replicas from <SERVICE>_READREPLICA_HOSTS, in the order they were given
+	Hosts []string `json:"hosts,omitempty"`
+	// Primary is the database host that the replicas were given for, only used by the primary-fallback strategy
+	Primary string `json:"primary,omitempty"`
+	// Strategy is how the replica is chosen from the healthy ones
+	Strategy string `json:"strategy"`
+	// MaxLag is the number of seconds a replica can be behind before it is unhealthy, any lag is allowed if it isn't set
+	MaxLag string `json:"maxLag,omitempty"`
+
+	// port is the port of the primary, used for any of the hosts that are given without a port
+	port string
+}
+
+// address splits one of the hosts into the host and port to connect to
+func (r Replicas) address(host string) (string, string) {
+	host, port := splitHostPort(host)
+	if port == "" {
+		port = r.port
+	}
+	return host, port
+}
+
+// replicaStatus is the result of probing a read replica, the lag is nil if the replication status couldn't be read
+type replicaStatus struct {
+	Host string
+	Lag  *time.Duration
+	Err  error
+}
+
+// healthy is true if the replica could be reached and isn't lagging more than the max lag
+func (s replicaStatus) healthy(maxLag *time.Duration) bool {
+	if s.Err != nil {
+		return false
+	}
+	return maxLag == nil || s.Lag == nil || *s.Lag <= *maxLag
+}
+
+func (s replicaStatus) String() string {
+	switch {
+	case s.Err != nil:
+		return fmt.Sprintf("%s is unhealthy: %v", s.Host, s.Err)
+	case s.Lag == nil:
+		return fmt.Sprintf("%s is reachable, its replication lag is unknown", s.Host)
+	}
+	return fmt.Sprintf("%s is %s behind", s.Host, *s.Lag)
+}
+
+// maxLag returns the max lag as a duration, or nil if any lag is allowed
+func (r Replicas) maxLag() *time.Duration {
+	if r.MaxLag == "" {
+		return nil
+	}
+	seconds, _ := strconv.Atoi(r.MaxLag)
+	lag := time.Duration(seconds) * time.Second
+	return &lag
+}
+
+// choose picks the host to dump from using the strategy, the statuses are in the same order as the hosts
+// a replica with an unknown lag is healthy, but is only chosen by least-lag if none of the replicas have a known lag
+func (r Replicas) choose(statuses []replicaStatus) (string, error) {
+	healthy := []replicaStatus{}
+	for _, status := range statuses {
+		if status.healthy(r.maxLag()) {
+			healthy = append(healthy, status)
+		}
+	}
+	if len(healthy) == 0 {
+		if r.Strategy == "primary-fallback" && r.Primary != "" {
+			return r.Primary, nil
+		}
+		return "", fmt.Errorf("none of the read replicas %v are healthy", r.Hosts)
+	}
+	switch r.Strategy {
+	case "least-lag":
+		// the sort is stable so the order the replicas were given in breaks any ties
+		slices.SortStableFunc(healthy, func(a, b replicaStatus) int {
+			switch {
+			case a.Lag == nil && b.Lag == nil:
+				return 0
+			case a.Lag == nil:
+				return 1
+			case b.Lag == nil:
+				return -1
+			}
+			return cmp.Compare(*a.Lag, *b.Lag)
+		})
+	case "random":
+		return healthy[rand.IntN(len(healthy))].Host, nil
+	}
+	return healthy[0].Host, nil
+}
+
+// probeReplica connects to a read replica and reads how far behind the primary it is, postgres replicas and replicas
+// where the user isn't allowed to read the replication status are only checked for a connection
+func (p *Pipeline) probeReplica(ctx context.Context, host string) replicaStatus {
+	status := replicaStatus{Host: host}
+	ctx, cancel := context.WithTimeout(ctx, replicaProbeTimeout)
+	defer cancel()
+	replica := p.Build.MTK
+	replica.Host, replica.Port = p.Build.Replicas.address(host)
+	replica.Socket = ""
+	address := replica.address(p.Build.DatabaseType)
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	conn.Close()
+	if p.Build.DatabaseType == "postgres" {
+		return status
+	}
+	cfg, err := replica.mysqlConfig(replicaProbeTimeout)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	lag, err := replicationLag(ctx, db)
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == 1227:
+		// ER_SPECIFIC_ACCESS_DENIED_ERROR, the user doesn't have REPLICATION CLIENT so only the connection is checked
+	case err != nil:
+		status.Err = err
+	default:
+		status.Lag = lag
+	}
+	return status
+}
+
+// replicationLag reads Seconds_Behind_Master (or Seconds_Behind_Source on newer mysql) from the replication status
+// SHOW SLAVE STATUS is tried if SHOW REPLICA STATUS isn't supported, for mariadb before 10.5 and mysql before 8.0.22
+func replicationLag(ctx context.Context, db *sql.DB) (*time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1064 {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("replication isn't configured")
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	for i, column := range columns {
+		if column != "Seconds_Behind_Master" && column != "Seconds_Behind_Source" {
+			continue
+		}
+		// the lag is NULL when the replication threads aren't running
+		if !values[i].Valid {
+			return nil, errors.New("replication isn't running")
+		}
+		seconds, err := strconv.Atoi(values[i].String)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the replication lag %q: %v", values[i].String, err)
+		}
+		lag := time.Duration(seconds) * time.Second
+		return &lag, nil
+	}
+	return nil, errors.New("the replication status has no lag")
+}
+
+// selectReplica probes each of the read replicas and sets the host to dump from, nothing is probed if there aren't
+// any read replicas
+func (p *Pipeline) selectReplica(ctx context.Context) error {
+	replicas := p.Build.Replicas
+	if len(replicas.Hosts) == 0 {
+		return nil
+	}
+	statuses := []replicaStatus{}
+	for _, host := range replicas.Hosts {
+		status := p.ProbeReplica(ctx, host)
+		fmt.Fprintf(p.Stdout, "read replica %s\n", status)
+		statuses = append(statuses, status)
+	}
+	host, err := replicas.choose(statuses)
+	if err != nil {
+		return err
+	}
+	if host == replicas.Primary && !slices.Contains(replicas.Hosts, host) {
+		fmt.Fprintf(p.Stdout, "none of the read replicas are healthy, falling back to the primary %s\n", host)
+	} else {
+		fmt.Fprintf(p.Stdout, "using read replica %s chosen by %s\n", host, replicas.Strategy)
+	}
+	p.Build.MTK.Host, p.Build.MTK.Port = replicas.address(host)
+	return nil
+}
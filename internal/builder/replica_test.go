@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lagOf(seconds int) *time.Duration {
+	lag := time.Duration(seconds) * time.Second
+	return &lag
+}
+
+func Test_Replicas_choose(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		description string
+		replicas    Replicas
+		statuses    []replicaStatus
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:        "test1",
+			description: "check first-healthy skips a replica that is down",
+			replicas:    Replicas{Strategy: "first-healthy"},
+			statuses: []replicaStatus{
+				{Host: "rr1", Err: down},
+				{Host: "rr2", Lag: lagOf(30)},
+				{Host: "rr3", Lag: lagOf(0)},
+			},
+			want: []string{"rr2"},
+		},
+		{
+			name:        "test2",
+			description: "check first-healthy skips a replica that is lagging more than the max lag",
+			replicas:    Replicas{Strategy: "first-healthy", MaxLag: "10"},
+			statuses: []replicaStatus{
+				{Host: "rr1", Lag: lagOf(30)},
+				{Host: "rr2", Lag: lagOf(10)},
+			},
+			want: []string{"rr2"},
+		},
+		{
+			name:        "test3",
+			description: "check least-lag prefers a known lag, and the order breaks ties",
+			replicas:    Replicas{Strategy: "least-lag"},
+			statuses: []replicaStatus{
+				{Host: "rr1"},
+				{Host: "rr2", Lag: lagOf(5)},
+				{Host: "rr3", Lag: lagOf(2)},
+				{Host: "rr4", Lag: lagOf(2)},
+			},
+			want: []string{"rr3"},
+		},
+		{
+			name:        "test4",
+			description: "check random only picks healthy replicas",
+			replicas:    Replicas{Strategy: "random"},
+			statuses: []replicaStatus{
+				{Host: "rr1", Err: down},
+				{Host: "rr2"},
+				{Host: "rr3", Lag: lagOf(1)},
+			},
+			want: []string{"rr2", "rr3"},
+		},
+		{
+			name:        "test5",
+			description: "check primary-fallback uses the primary when none of the replicas are healthy",
+			replicas:    Replicas{Primary: "dbhost", Strategy: "primary-fallback", MaxLag: "60"},
+			statuses: []replicaStatus{
+				{Host: "rr1", Err: down},
+				{Host: "rr2", Lag: lagOf(3600)},
+			},
+			want: []string{"dbhost"},
+		},
+		{
+			name:        "test6",
+			description: "check the other strategies fail when none of the replicas are healthy",
+			replicas:    Replicas{Primary: "dbhost", Strategy: "first-healthy"},
+			statuses: []replicaStatus{
+				{Host: "rr1", Err: down},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.replicas.choose(tt.statuses)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("choose() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Contains(tt.want, got) {
+				t.Errorf("choose() = %v, want one of %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Pipeline_selectReplica(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		replicas    Replicas
+		wantHost    string
+		wantProbes  []string
+		wantOutput  []string
+	}{
+		{
+			name:        "test1",
+			description: "check nothing is probed without read replicas",
+			replicas:    Replicas{Strategy: "first-healthy"},
+			wantHost:    "dbhost",
+		},
+		{
+			name:        "test2",
+			description: "check every replica is probed and the chosen one is used for the dump",
+			replicas:    Replicas{Hosts: []string{"dbrrhost1", "dbrrhost2", "dbrrhost3"}, Primary: "dbhost", Strategy: "least-lag"},
+			wantHost:    "dbrrhost3",
+			wantProbes:  []string{"dbrrhost1", "dbrrhost2", "dbrrhost3"},
+			wantOutput: []string{
+				"read replica dbrrhost1 is unhealthy: dial tcp: connection refused",
+				"read replica dbrrhost2 is 1m0s behind",
+				"read replica dbrrhost3 is 2s behind",
+				"using read replica dbrrhost3 chosen by least-lag",
+			},
+		},
+		{
+			name:        "test3",
+			description: "check the primary is used when it is the fallback",
+			replicas:    Replicas{Hosts: []string{"dbrrhost1"}, Primary: "dbhost", Strategy: "primary-fallback"},
+			wantHost:    "dbhost",
+			wantProbes:  []string{"dbrrhost1"},
+			wantOutput: []string{
+				"none of the read replicas are healthy, falling back to the primary dbhost",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, out := newTestPipeline(t, &fakeExecutor{})
+			probes := []string{}
+			p.ProbeReplica = func(ctx context.Context, host string) replicaStatus {
+				probes = append(probes, host)
+				switch host {
+				case "dbrrhost2":
+					return replicaStatus{Host: host, Lag: lagOf(60)}
+				case "dbrrhost3":
+					return replicaStatus{Host: host, Lag: lagOf(2)}
+				}
+				return replicaStatus{Host: host, Err: errors.New("dial tcp: connection refused")}
+			}
+			p.Build = Builder{MTK: MTK{Host: "dbhost"}, Replicas: tt.replicas}
+			if len(tt.replicas.Hosts) > 0 {
+				p.Build.MTK.Host = tt.replicas.Hosts[0]
+			}
+			if err := p.selectReplica(context.Background()); err != nil {
+				t.Fatalf("selectReplica() error = %v", err)
+			}
+			if p.Build.MTK.Host != tt.wantHost {
+				t.Errorf("selectReplica() host = %v, want %v", p.Build.MTK.Host, tt.wantHost)
+			}
+			if !slices.Equal(probes, tt.wantProbes) {
+				t.Errorf("selectReplica() probed %v, want %v", probes, tt.wantProbes)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want+"\n") {
+					t.Errorf("selectReplica() output is missing %q:\n%v", want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uselagoon/database-image-task/internal/oci"
+)
+
+// defaultRetentionTagPattern matches the default tags, so that latest and any other tags are never removed
+const defaultRetentionTagPattern = `^backup-[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+
+// Retention is the policy used to remove old tags of the resulting image from the registry after a successful push,
+// the counts and max age are kept as strings so that they can be validated
+type Retention struct {
+	// KeepLast keeps the newest tags
+	KeepLast string `json:"keepLast,omitempty"`
+	// KeepDaily, KeepWeekly and KeepMonthly keep the newest tag from each of the newest days, weeks and months
+	KeepDaily   string `json:"keepDaily,omitempty"`
+	KeepWeekly  string `json:"keepWeekly,omitempty"`
+	KeepMonthly string `json:"keepMonthly,omitempty"`
+	// MaxAge removes tags that are older than this even if they would be kept, eg 90d, 12w or 720h
+	MaxAge string `json:"maxAge,omitempty"`
+	// TagPattern is a regular expression that a tag has to match to be removed
+	TagPattern string `json:"tagPattern"`
+	// DryRun lists the tags that would be removed without removing them
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// dryRunValue is the raw value of BUILDER_RETENTION_DRY_RUN, kept so that it can be validated
+	dryRunValue string
+}
+
+// taggedImage is a tag in the registry, the digest of the manifest it points to and when the image was created
+type taggedImage struct {
+	Tag     string
+	Digest  string
+	Created time.Time
+}
+
+// enabled is true if any of the rules are set
+func (r Retention) enabled() bool {
+	return r.KeepLast != "" || r.KeepDaily != "" || r.KeepWeekly != "" || r.KeepMonthly != "" || r.MaxAge != ""
+}
+
+// parseMaxAge parses a duration that can also be given in days or weeks, eg 90d or 12w
+func parseMaxAge(value string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		name   string
+		unit   time.Duration
+	}{
+		{suffix: "d", name: "days", unit: 24 * time.Hour},
+		{suffix: "w", name: "weeks", unit: 7 * 24 * time.Hour},
+	}
+	for _, u := range units {
+		if count, ok := strings.CutSuffix(value, u.suffix); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%q is not a positive number of %s", count, u.name)
+			}
+			return time.Duration(n) * u.unit, nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration", value)
+	}
+	return d, nil
+}
+
+// plan works out which of the images are kept and which are removed, newest first
+// an image is kept if any of the keep rules keep it, or if there are no keep rules, unless it is older than the max age
+// the protected tags, the ones that were just pushed, are always kept
+func (r Retention) plan(images []taggedImage, protected []string, now time.Time) ([]taggedImage, []taggedImage) {
+	images = slices.Clone(images)
+	slices.SortFunc(images, func(a, b taggedImage) int {
+		if c := b.Created.Compare(a.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(b.Tag, a.Tag)
+	})
+	count := func(value string) int {
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	kept := map[string]bool{}
+	hasKeepRules := r.KeepLast != "" || r.KeepDaily != "" || r.KeepWeekly != "" || r.KeepMonthly != ""
+	for i, image := range images {
+		if !hasKeepRules || i < count(r.KeepLast) {
+			kept[image.Tag] = true
+		}
+	}
+	// keep the newest image in each of the newest periods
+	periods := []struct {
+		keep   int
+		period func(time.Time) string
+	}{
+		{keep: count(r.KeepDaily), period: func(t time.Time) string { return t.UTC().Format(time.DateOnly) }},
+		{keep: count(r.KeepWeekly), period: func(t time.Time) string {
+			year, week := t.UTC().ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{keep: count(r.KeepMonthly), period: func(t time.Time) string { return t.UTC().Format("2006-01") }},
+	}
+	for _, p := range periods {
+		seen := map[string]bool{}
+		for _, image := range images {
+			period := p.period(image.Created)
+			if len(seen) < p.keep && !seen[period] {
+				seen[period] = true
+				kept[image.Tag] = true
+			}
+		}
+	}
+	if r.MaxAge != "" {
+		maxAge, _ := parseMaxAge(r.MaxAge)
+		for _, image := range images {
+			if now.Sub(image.Created) > maxAge {
+				delete(kept, image.Tag)
+			}
+		}
+	}
+	keep, remove := []taggedImage{}, []taggedImage{}
+	for _, image := range images {
+		if kept[image.Tag] || slices.Contains(protected, image.Tag) {
+			keep = append(keep, image)
+		} else {
+			remove = append(remove, image)
+		}
+	}
+	return keep, remove
+}
+
+// pruneTags removes the tags of the resulting image that aren't kept by the retention policy, the registry is used
+// directly for every build backend
+// a tag is removed by deleting the manifest it points to, which also removes any other tags of the same image, so a
+// tag is kept if the image is also tagged with a tag that is kept, protected or doesn't match the pattern
+func (p *Pipeline) pruneTags(ctx context.Context) error {
+	retention := p.Build.Retention
+	if !retention.enabled() {
+		fmt.Fprintln(p.Stdout, "no retention policy is set, no tags will be removed")
+		return nil
+	}
+	if p.Build.BuildBackend == "kaniko" {
+		// kaniko pushes the image after the task, so the new tags aren't in the registry yet
+		fmt.Fprintln(p.Stdout, "the retention policy isn't applied with the kaniko backend, as the image hasn't been pushed yet")
+		return nil
+	}
+	pattern, err := regexp.Compile(retention.TagPattern)
+	if err != nil {
+		return fmt.Errorf("invalid retention tag pattern: %v", err)
+	}
+	tags, err := oci.ListTags(ctx, p.Build.ResultImageName, p.ociKeychain())
+	if err != nil {
+		return err
+	}
+	digests := map[string]string{}
+	images := []taggedImage{}
+	for _, tag := range tags {
+		ref := fmt.Sprintf("%s:%s", p.Build.ResultImageName, tag)
+		if digests[tag], err = oci.Digest(ctx, ref, p.ociKeychain()); err != nil {
+			return err
+		}
+		if !pattern.MatchString(tag) {
+			continue
+		}
+		created, err := oci.Created(ctx, ref, p.ociKeychain())
+		if err != nil {
+			return err
+		}
+		images = append(images, taggedImage{Tag: tag, Digest: digests[tag], Created: created})
+	}
+	keep, remove := retention.plan(images, p.Build.Tags, p.Now())
+	fmt.Fprintf(p.Stdout, "keeping %d of the %d tags matching %s\n", len(keep), len(images), retention.TagPattern)
+	if retention.DryRun {
+		for _, image := range keep {
+			fmt.Fprintf(p.Stdout, "would keep %s:%s (created %s)\n", p.Build.ResultImageName, image.Tag, image.Created.Format(time.DateTime))
+		}
+	}
+	// the tags that aren't removed, by the digest they point to
+	held := map[string][]string{}
+	for _, tag := range tags {
+		if !slices.ContainsFunc(remove, func(image taggedImage) bool { return image.Tag == tag }) {
+			held[digests[tag]] = append(held[digests[tag]], tag)
+		}
+	}
+	// the result of deleting each manifest, so that the other tags of a manifest that has been deleted are reported
+	// without deleting it again
+	deleted := map[string]error{}
+	var errs []error
+	for _, image := range remove {
+		ref := fmt.Sprintf("%s:%s", p.Build.ResultImageName, image.Tag)
+		if others := held[image.Digest]; len(others) > 0 {
+			fmt.Fprintf(p.Stdout, "not removing %s (created %s), it is the same image as %s\n", ref, image.Created.Format(time.DateTime), strings.Join(others, ", "))
+			continue
+		}
+		if retention.DryRun {
+			fmt.Fprintf(p.Stdout, "would remove %s (created %s)\n", ref, image.Created.Format(time.DateTime))
+			continue
+		}
+		err, done := deleted[image.Digest]
+		if !done {
+			// keep going so that one tag that can't be removed doesn't stop the rest
+			err = oci.DeleteManifest(ctx, p.Build.ResultImageName, image.Digest, p.ociKeychain())
+			deleted[image.Digest] = err
+			if err != nil {
+				errs = append(errs, fmt.Errorf("unable to remove %s: %w", ref, err))
+			}
+		}
+		if err == nil {
+			fmt.Fprintf(p.Stdout, "removed %s (created %s)\n", ref, image.Created.Format(time.DateTime))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,270 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// backupImages returns a backup tag created at 01:00 on each of the days before now
+func backupImages(now time.Time, days int) []taggedImage {
+	images := []taggedImage{}
+	for i := 0; i < days; i++ {
+		created := now.AddDate(0, 0, -i)
+		images = append(images, taggedImage{Tag: "backup-" + created.Format(time.DateOnly), Created: created})
+	}
+	return images
+}
+
+func tagNames(images []taggedImage) []string {
+	tags := []string{}
+	for _, image := range images {
+		tags = append(tags, image.Tag)
+	}
+	return tags
+}
+
+func Test_Retention_plan(t *testing.T) {
+	now := time.Date(2026, 10, 18, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		description string
+		retention   Retention
+		images      []taggedImage
+		protected   []string
+		wantRemove  []string
+	}{
+		{
+			name:        "test1",
+			description: "check the newest tags are kept",
+			retention:   Retention{KeepLast: "3"},
+			images:      backupImages(now, 5),
+			wantRemove:  []string{"backup-2026-10-15", "backup-2026-10-14"},
+		},
+		{
+			name:        "test2",
+			description: "check the newest tag of each week and month is kept",
+			retention:   Retention{KeepWeekly: "2", KeepMonthly: "2"},
+			images:      backupImages(now, 40),
+			// the newest in week 42 and 41, and the newest in october and september
+			wantRemove: func() []string {
+				remove := tagNames(backupImages(now, 40))
+				return slices.DeleteFunc(remove, func(tag string) bool {
+					return slices.Contains([]string{"backup-2026-10-18", "backup-2026-10-11", "backup-2026-09-30"}, tag)
+				})
+			}(),
+		},
+		{
+			name:        "test3",
+			description: "check the newest tag of each day is kept, even if there is more than one a day",
+			retention:   Retention{KeepDaily: "2"},
+			images: []taggedImage{
+				{Tag: "backup-a", Created: now},
+				{Tag: "backup-b", Created: now.Add(-time.Hour)},
+				{Tag: "backup-c", Created: now.Add(-24 * time.Hour)},
+				{Tag: "backup-d", Created: now.Add(-48 * time.Hour)},
+			},
+			wantRemove: []string{"backup-b", "backup-d"},
+		},
+		{
+			name:        "test4",
+			description: "check the max age on its own removes only the old tags",
+			retention:   Retention{MaxAge: "2d"},
+			images:      backupImages(now, 5),
+			wantRemove:  []string{"backup-2026-10-15", "backup-2026-10-14"},
+		},
+		{
+			name:        "test5",
+			description: "check the max age removes tags that would otherwise be kept, but never the tags that were just pushed",
+			retention:   Retention{KeepLast: "10", MaxAge: "24h"},
+			images:      append(backupImages(now, 3), taggedImage{Tag: "backup-old", Created: now.AddDate(-1, 0, 0)}),
+			protected:   []string{"latest", "backup-old"},
+			wantRemove:  []string{"backup-2026-10-16"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove := tt.retention.plan(tt.images, tt.protected, now)
+			if got := tagNames(remove); !reflect.DeepEqual(got, tt.wantRemove) {
+				t.Errorf("plan() removed = %v, want %v", got, tt.wantRemove)
+			}
+			if len(keep)+len(remove) != len(tt.images) {
+				t.Errorf("plan() kept %d and removed %d of %d", len(keep), len(remove), len(tt.images))
+			}
+		})
+	}
+}
+
+// distributionRegistry is an in memory registry that deletes manifests in the same way as the distribution registry,
+// deleting a manifest by its digest removes all of its tags, and deleting a manifest by a tag is rejected
+func distributionRegistry() http.Handler {
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, ref, ok := strings.Cut(r.URL.Path, "/manifests/")
+		if r.Method != http.MethodDelete || !ok {
+			reg.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasPrefix(ref, "sha256:") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprint(w, `{"errors":[{"code":"UNSUPPORTED","message":"The operation is unsupported."}]}`)
+			return
+		}
+		rec := httptest.NewRecorder()
+		reg.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, repo+"/tags/list", nil))
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		for _, tag := range list.Tags {
+			rec := httptest.NewRecorder()
+			reg.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, repo+"/manifests/"+tag, nil))
+			if rec.Header().Get("Docker-Content-Digest") == ref {
+				reg.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, repo+"/manifests/"+tag, nil))
+			}
+		}
+		reg.ServeHTTP(w, r)
+	})
+}
+
+func Test_Pipeline_pruneTags(t *testing.T) {
+	now := time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC)
+	tests := []struct {
+		name        string
+		description string
+		dryRun      bool
+		// extraTags are also pushed for the image created the number of days before now
+		extraTags  map[int][]string
+		wantTags   []string
+		wantOutput []string
+	}{
+		{
+			name:        "test1",
+			description: "check a dry run lists the tags without removing them",
+			dryRun:      true,
+			wantTags:    []string{"backup-2026-10-14", "backup-2026-10-15", "backup-2026-10-16", "backup-2026-10-17", "backup-2026-10-18", "latest", "weekly"},
+			wantOutput: []string{
+				"keeping 2 of the 5 tags matching ^backup-[0-9]{4}-[0-9]{2}-[0-9]{2}$",
+				"would keep %s:backup-2026-10-18 (created 2026-10-18 01:02:03)",
+				"would remove %s:backup-2026-10-16 (created 2026-10-16 01:02:03)",
+			},
+		},
+		{
+			name:        "test2",
+			description: "check the tags that aren't kept are removed, and the tags that don't match are left alone",
+			wantTags:    []string{"backup-2026-10-17", "backup-2026-10-18", "latest", "weekly"},
+			wantOutput: []string{
+				"removed %s:backup-2026-10-14 (created 2026-10-14 01:02:03)",
+			},
+		},
+		{
+			name:        "test3",
+			description: "check a tag isn't removed if the image is also tagged with a tag that is kept or doesn't match",
+			extraTags: map[int][]string{
+				1: {"backup-2026-10-14"},
+				3: {"pinned"},
+			},
+			wantTags: []string{"backup-2026-10-14", "backup-2026-10-15", "backup-2026-10-17", "backup-2026-10-18", "latest", "pinned", "weekly"},
+			wantOutput: []string{
+				"not removing %s:backup-2026-10-14 (created 2026-10-17 01:02:03), it is the same image as backup-2026-10-17",
+				"removed %s:backup-2026-10-16 (created 2026-10-16 01:02:03)",
+				"not removing %s:backup-2026-10-15 (created 2026-10-15 01:02:03), it is the same image as pinned",
+			},
+		},
+		{
+			name:        "test4",
+			description: "check the tags of an image that are all removed are reported when its manifest is deleted",
+			extraTags: map[int][]string{
+				4: {"backup-2026-10-16"},
+			},
+			wantTags: []string{"backup-2026-10-17", "backup-2026-10-18", "latest", "weekly"},
+			wantOutput: []string{
+				"removed %s:backup-2026-10-16 (created 2026-10-14 01:02:03)",
+				"removed %s:backup-2026-10-15 (created 2026-10-15 01:02:03)",
+				"removed %s:backup-2026-10-14 (created 2026-10-14 01:02:03)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(distributionRegistry())
+			t.Cleanup(server.Close)
+			repository := strings.TrimPrefix(server.URL, "http://") + "/lagpro/lagenv"
+			images := []v1.Image{}
+			for i := 0; i < 5; i++ {
+				created := now.AddDate(0, 0, -i)
+				img, err := random.Image(64, 1)
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				img, _ = mutate.CreatedAt(img, v1.Time{Time: created})
+				images = append(images, img)
+				tags := []string{"backup-" + created.Format(time.DateOnly)}
+				if i == 0 {
+					tags = append(tags, "latest", "weekly")
+				}
+				for _, tag := range tags {
+					ref, _ := name.ParseReference(repository + ":" + tag)
+					if err := remote.Write(ref, img); err != nil {
+						t.Fatalf("%v", err)
+					}
+				}
+			}
+			// the extra tags are pushed last, so that they replace any of the tags above
+			for i, tags := range tt.extraTags {
+				for _, tag := range tags {
+					ref, _ := name.ParseReference(repository + ":" + tag)
+					if err := remote.Write(ref, images[i]); err != nil {
+						t.Fatalf("%v", err)
+					}
+				}
+			}
+
+			p, _, out := newTestPipeline(t, &fakeExecutor{})
+			p.Build = Builder{
+				ResultImageName: repository,
+				Tags:            []string{"latest", "backup-2026-10-18"},
+				BuildBackend:    "docker",
+				Retention: Retention{
+					KeepLast:   "2",
+					TagPattern: defaultRetentionTagPattern,
+					DryRun:     tt.dryRun,
+				},
+			}
+			if err := p.pruneTags(context.Background()); err != nil {
+				t.Fatalf("pruneTags() error = %v", err)
+			}
+			repo, _ := name.NewRepository(repository)
+			got, err := remote.List(repo)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.wantTags) {
+				t.Errorf("pruneTags() left %v, want %v", got, tt.wantTags)
+			}
+			for _, want := range tt.wantOutput {
+				if want = strings.ReplaceAll(want, "%s", repository); !strings.Contains(out.String(), want+"\n") {
+					t.Errorf("pruneTags() output is missing %q:\n%v", want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultImageTag is the pattern used for the tag of the resulting image when BUILDER_BACKUP_IMAGE_TAG isn't set
+const defaultImageTag = "backup-${date}"
+
+// splitTags splits a comma separated list of tags, dropping any blank entries
+func splitTags(value string) []string {
+	tags := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// expandExtraTags expands and normalises each of the extra tags, so that rolling tags like `month-${month}` can be used
+func expandExtraTags(tags []string, build Builder, now time.Time) ([]string, error) {
+	expanded := []string{}
+	for _, tag := range tags {
+		value, err := imagePatternParser(tag, build, now)
+		if err != nil {
+			return nil, err
+		}
+		if value = normaliseTag(value); value != "" {
+			expanded = append(expanded, value)
+		}
+	}
+	return expanded, nil
+}
+
+// pushTags returns every tag that the resulting image is pushed with for the push tags mode, in the order that they
+// are pushed, the extra tags are pushed in every mode, and each tag is only pushed once, eg when the tag is latest
+func (b Builder) pushTags() []string {
+	modeTags := []string{}
+	switch b.PushTags {
+	case "both":
+		modeTags = append(modeTags, "latest", b.ResultImageTag)
+	case "latest":
+		modeTags = append(modeTags, "latest")
+	case "default":
+		modeTags = append(modeTags, b.ResultImageTag)
+	}
+	tags := []string{}
+	for _, tag := range append(modeTags, b.ExtraTags...) {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// images returns the full reference of the resulting image for each of the tags
+func (b Builder) images() []string {
+	images := []string{}
+	for _, tag := range b.Tags {
+		images = append(images, fmt.Sprintf("%s:%s", b.ResultImageName, tag))
+	}
+	return images
+}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Builder_pushTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		pushTags    string
+		tag         string
+		extraTags   string
+		want        []string
+		wantImages  []string
+	}{
+		{
+			name:        "test1",
+			description: "check both pushes latest and the default tag",
+			pushTags:    "both",
+			want:        []string{"latest", "backup-2026-10-18"},
+			wantImages:  []string{"lagpro/lagenv:latest", "lagpro/lagenv:backup-2026-10-18"},
+		},
+		{
+			name:        "test2",
+			description: "check the extra tags are expanded, normalised and pushed after the default tag",
+			pushTags:    "default",
+			extraTags:   "weekly, month-${month},week-${week}, ,${branch|lower}",
+			want:        []string{"backup-2026-10-18", "weekly", "month-2026-10", "week-2026-w42", "feature-x"},
+			wantImages: []string{
+				"lagpro/lagenv:backup-2026-10-18",
+				"lagpro/lagenv:weekly",
+				"lagpro/lagenv:month-2026-10",
+				"lagpro/lagenv:week-2026-w42",
+				"lagpro/lagenv:feature-x",
+			},
+		},
+		{
+			name:        "test3",
+			description: "check custom only pushes the extra tags, and duplicates are only pushed once",
+			pushTags:    "custom",
+			extraTags:   "latest,nightly,latest",
+			want:        []string{"latest", "nightly"},
+			wantImages:  []string{"lagpro/lagenv:latest", "lagpro/lagenv:nightly"},
+		},
+		{
+			name:        "test4",
+			description: "check latest doesn't push the default tag",
+			pushTags:    "latest",
+			extraTags:   "nightly",
+			want:        []string{"latest", "nightly"},
+			wantImages:  []string{"lagpro/lagenv:latest", "lagpro/lagenv:nightly"},
+		},
+		{
+			name:        "test5",
+			description: "check both only pushes latest once when it is the default tag",
+			pushTags:    "both",
+			tag:         "latest",
+			want:        []string{"latest"},
+			wantImages:  []string{"lagpro/lagenv:latest"},
+		},
+	}
+	now := time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LAGOON_GIT_BRANCH", "Feature/X")
+			tag := tt.tag
+			if tag == "" {
+				tag = "backup-2026-10-18"
+			}
+			b := Builder{ResultImageName: "lagpro/lagenv", ResultImageTag: tag, PushTags: tt.pushTags}
+			extraTags, err := expandExtraTags(splitTags(tt.extraTags), b, now)
+			if err != nil {
+				t.Fatalf("expandExtraTags() error = %v", err)
+			}
+			b.ExtraTags = extraTags
+			b.Tags = b.pushTags()
+			if !reflect.DeepEqual(b.Tags, tt.want) {
+				t.Errorf("pushTags() = %v, want %v", b.Tags, tt.want)
+			}
+			if got := b.images(); !reflect.DeepEqual(got, tt.wantImages) {
+				t.Errorf("images() = %v, want %v", got, tt.wantImages)
+			}
+		})
+	}
+}
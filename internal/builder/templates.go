@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// builderTemplates are the my.cnf files and import scripts used by the dockerfiles, a missing value is an error rather
+// than an empty string so that a typo in a template can't produce a broken config
+var builderTemplates = template.Must(template.New("").Option("missingkey=error").ParseFS(templateFiles, "templates/*.tmpl"))
+
+// renderedFile is a template and the file in the working directory that it is rendered to
+type renderedFile struct {
+	Template string
+	File     string
+	Mode     fs.FileMode
+}
+
+// templateData is what the templates are rendered with, the fields of the Builder and the values derived from them
+type templateData struct {
+	Builder
+	// ServerArgs are the mysqld arguments used to import the dump
+	ServerArgs []string
+}
+
+// renderedFiles returns the files that are rendered for the database type, postgres doesn't need any
+func renderedFiles(dbType string) []renderedFile {
+	switch dbType {
+	case "mariadb", "mysql":
+		return []renderedFile{
+			{Template: "my.cnf.tmpl", File: "my.cnf", Mode: 0644},
+			{Template: "import.my.cnf.tmpl", File: "import.my.cnf", Mode: 0644},
+			{Template: fmt.Sprintf("%s-import.sh.tmpl", dbType), File: fmt.Sprintf("%s-import.sh", dbType), Mode: 0755},
+		}
+	}
+	return nil
+}
+
+// importServerArgs are the arguments that mysqld is run with to import the dump, tuned with the BUILDER_IMPORT_* variables
+func (b Builder) importServerArgs() []string {
+	return []string{
+		fmt.Sprintf("--innodb-buffer-pool-size=%s", b.Import.BufferPoolSize),
+		fmt.Sprintf("--innodb-sort-buffer-size=%s", b.Import.SortBufferSize),
+		"--bulk-insert-buffer-size=256M",
+		"--innodb-buffer-pool-instances=4",
+		fmt.Sprintf("--innodb-read-io-threads=%s", b.Import.IOThreads),
+		fmt.Sprintf("--innodb-write-io-threads=%s", b.Import.IOThreads),
+		fmt.Sprintf("--max-allowed-packet=%s", b.Import.MaxAllowedPacket),
+		// the dump has the stored functions, which can't be created while binary logging is on without this
+		"--log-bin-trust-function-creators=1",
+	}
+}
+
+// renderTemplate renders one of the builder templates
+func (b Builder) renderTemplate(name string) ([]byte, error) {
+	var buf bytes.Buffer
+	data := templateData{Builder: b, ServerArgs: b.importServerArgs()}
+	if err := builderTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("unable to render %s: %v", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderTemplates renders the templates for the database type into the working directory, this replaces the use of
+// envsubst so the templates only see the values generated by the builder
+func (p *Pipeline) renderTemplates() error {
+	for _, f := range renderedFiles(p.Build.DatabaseType) {
+		rendered, err := p.Build.renderTemplate(f.Template)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(p.WorkDir, f.File), rendered, f.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
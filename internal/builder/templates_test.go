@@ -0,0 +1,236 @@
+package builder
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Pipeline_renderTemplates(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		build       func(b *Builder)
+		want        map[string]string
+	}{
+		{
+			name:        "test1",
+			description: "check the mariadb templates are rendered with the default tuning",
+			build:       func(b *Builder) {},
+			want: map[string]string{
+				"my.cnf": "#  Create the `.my.cnf` that the lagoon mariadb images use\n\n[client]\nuser=root\npassword=\"Lag00n\"\n\n[mysql]\ndatabase=drupal\n\n[mysqld]\nmax_allowed_packet=1G\n",
+				"mariadb-import.sh": `#!/bin/bash
+
+/usr/local/bin/docker-entrypoint.sh mysqld \
+    --innodb-buffer-pool-size=2G \
+    --innodb-sort-buffer-size=128M \
+    --bulk-insert-buffer-size=256M \
+    --innodb-buffer-pool-instances=4 \
+    --innodb-read-io-threads=4 \
+    --innodb-write-io-threads=4 \
+    --max-allowed-packet=1G \
+    --log-bin-trust-function-creators=1 \
+    --datadir /initialized-db \
+    --aria-log-dir-path /initialized-db > /tmp/output.log 2>&1
+
+if [ "$?" != "0" ]; then
+    # print the last 3 lines of the log that shows the error
+    tail -n 3 /tmp/output.log
+    exit 1
+fi
+`,
+			},
+		},
+		{
+			name:        "test2",
+			description: "check the mysql templates are rendered with the tuning and my.cnf settings",
+			build: func(b *Builder) {
+				b.DatabaseType = "mysql"
+				b.ResultImageDatabaseName = "lagoon"
+				b.Import.BufferPoolSize = "512M"
+				b.Import.MaxAllowedPacket = "256M"
+				b.Import.IOThreads = "8"
+				b.MyCnf.MaxAllowedPacket = "64M"
+				b.MyCnf.BufferPoolSize = "256M"
+			},
+			want: map[string]string{
+				"my.cnf": "#  Create the `.my.cnf` that the lagoon mariadb images use\n\n[client]\nuser=root\npassword=\"Lag00n\"\n\n[mysql]\ndatabase=lagoon\n\n[mysqld]\nmax_allowed_packet=64M\ninnodb_buffer_pool_size=256M\n",
+				"import.my.cnf": `[mysqld]
+max_allowed_packet=256M
+bulk_insert_buffer_size = 256M
+innodb_buffer_pool_chunk_size = 128M
+innodb_buffer_pool_size = 128M
+innodb_buffer_pool_instances = 4
+innodb_read_io_threads = 8
+innodb_write_io_threads = 8
+`,
+				"mysql-import.sh": `#!/bin/bash
+
+/usr/local/bin/docker-entrypoint.sh mysqld \
+    --innodb-buffer-pool-size=512M \
+    --innodb-sort-buffer-size=128M \
+    --bulk-insert-buffer-size=256M \
+    --innodb-buffer-pool-instances=4 \
+    --innodb-read-io-threads=8 \
+    --innodb-write-io-threads=8 \
+    --max-allowed-packet=256M \
+    --log-bin-trust-function-creators=1 \
+    --datadir /initialized-db > /tmp/output.log 2>&1
+
+if [ "$?" != "0" ]; then
+    # print the last 3 lines of the log that shows the error
+    tail -n 3 /tmp/output.log
+    exit 1
+fi
+`,
+			},
+		},
+		{
+			name:        "test3",
+			description: "check the import script checks zstd is installed when the dump is compressed with it",
+			build: func(b *Builder) {
+				b.Compression = Compression{Codec: "zstd"}
+			},
+			want: map[string]string{
+				"mariadb-import.sh": `#!/bin/bash
+
+# the entrypoint decompresses the dump with zstd, which isn't installed in every builder image
+if ! command -v zstd > /dev/null; then
+    echo "zstd isn't installed in the builder image, set BUILDER_DUMP_COMPRESSION to gzip or none"
+    exit 1
+fi
+
+/usr/local/bin/docker-entrypoint.sh mysqld \
+    --innodb-buffer-pool-size=2G \
+    --innodb-sort-buffer-size=128M \
+    --bulk-insert-buffer-size=256M \
+    --innodb-buffer-pool-instances=4 \
+    --innodb-read-io-threads=4 \
+    --innodb-write-io-threads=4 \
+    --max-allowed-packet=1G \
+    --log-bin-trust-function-creators=1 \
+    --datadir /initialized-db \
+    --aria-log-dir-path /initialized-db > /tmp/output.log 2>&1
+
+if [ "$?" != "0" ]; then
+    # print the last 3 lines of the log that shows the error
+    tail -n 3 /tmp/output.log
+    exit 1
+fi
+`,
+			},
+		},
+		{
+			name:        "test4",
+			description: "check a generated root password isn't written into the my.cnf of the resulting image",
+			build: func(b *Builder) {
+				b.ResultImageRandomRootPassword = true
+				b.ResultImageRootPassword = "generated"
+			},
+			want: map[string]string{
+				"my.cnf": "#  Create the `.my.cnf` that the lagoon mariadb images use\n\n[client]\nuser=root\n# the root password was generated by the build, so it isn't kept in the image\n\n[mysql]\ndatabase=drupal\n\n[mysqld]\nmax_allowed_packet=1G\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, _ := newTestPipeline(t, &fakeExecutor{})
+			p.Build = validBuilder()
+			tt.build(&p.Build)
+			if err := p.renderTemplates(); err != nil {
+				t.Fatalf("renderTemplates() error = %v", err)
+			}
+			for file, want := range tt.want {
+				b, err := os.ReadFile(filepath.Join(p.WorkDir, file))
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				if string(b) != want {
+					t.Errorf("renderTemplates() %s = \n%v\nwant\n%v", file, string(b), want)
+				}
+			}
+		})
+	}
+}
+
+// dockerfileCopies returns the sources of the COPY instructions in a dockerfile, leaving out the ones copied from
+// another stage
+func dockerfileCopies(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	var sources []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "COPY" || strings.HasPrefix(fields[1], "--from=") {
+			continue
+		}
+		sources = append(sources, fields[1:len(fields)-1]...)
+	}
+	return sources
+}
+
+func Test_Dockerfiles_buildContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		databaseType string
+		compression  string
+	}{
+		{
+			name:         "test1",
+			description:  "check everything the mariadb dockerfile copies is in the build context",
+			databaseType: "mariadb",
+			compression:  "gzip",
+		},
+		{
+			name:         "test2",
+			description:  "check everything the mysql dockerfile copies is in the build context",
+			databaseType: "mysql",
+			compression:  "zstd",
+		},
+		{
+			name:         "test3",
+			description:  "check everything the postgres dockerfile copies is in the build context",
+			databaseType: "postgres",
+			compression:  "none",
+		},
+	}
+	// the files that the image copies into the working directory, which is the build context
+	var static []string
+	for _, source := range dockerfileCopies(t, filepath.Join("..", "..", "Dockerfile")) {
+		if strings.HasPrefix(source, "builder/") {
+			static = append(static, source)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, _ := newTestPipeline(t, &fakeExecutor{})
+			p.Build = validBuilder()
+			p.Build.DatabaseType = tt.databaseType
+			p.Build.Compression = Compression{Codec: tt.compression}
+			for _, source := range static {
+				b, err := os.ReadFile(filepath.Join("..", "..", source))
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+				os.WriteFile(filepath.Join(p.WorkDir, filepath.Base(source)), b, 0644)
+			}
+			if err := p.renderTemplates(); err != nil {
+				t.Fatalf("renderTemplates() error = %v", err)
+			}
+			os.WriteFile(filepath.Join(p.WorkDir, p.Build.Compression.filename()), nil, 0644)
+			dockerfile := filepath.Join(p.WorkDir, tt.databaseType+".Dockerfile")
+			for _, source := range dockerfileCopies(t, dockerfile) {
+				if matches, _ := filepath.Glob(filepath.Join(p.WorkDir, source)); len(matches) == 0 {
+					t.Errorf("%s copies %s, which isn't in the build context", filepath.Base(dockerfile), source)
+				}
+			}
+		})
+	}
+}
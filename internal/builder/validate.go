@@ -0,0 +1,366 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRequired is used when a value that is needed to run a build is missing
+	ErrRequired = errors.New("a value is required")
+	// ErrUnsupported is used when a value is not one of the supported options
+	ErrUnsupported = errors.New("value is not supported")
+	// ErrInvalidValue is used when a value can't be parsed
+	ErrInvalidValue = errors.New("value is invalid")
+	// ErrInvalidReference is used when an image name or tag is not a valid image reference
+	ErrInvalidReference = errors.New("invalid image reference")
+	// ErrIncompatibleImages is used when the builder and clean images are different database types or versions
+	ErrIncompatibleImages = errors.New("incompatible images")
+	// ErrUnrecognisedImage is used when the layout of the clean image can't be detected from its config
+	ErrUnrecognisedImage = errors.New("unrecognised clean image")
+	// ErrInvalidPattern is used when an image name or tag pattern can't be expanded
+	ErrInvalidPattern = errors.New("invalid pattern")
+)
+
+// supportedDatabaseTypes are the values that BUILDER_BACKUP_IMAGE_TYPE can be set to
+var supportedDatabaseTypes = []string{"mariadb", "mysql", "postgres"}
+
+// supportedPushTags are the values that BUILDER_PUSH_TAGS can be set to
+var supportedPushTags = []string{"both", "latest", "default", "custom"}
+
+// supportedBuildBackends are the values that BUILDER_BUILD_BACKEND can be set to
+var supportedBuildBackends = []string{"docker", "podman", "buildah", "kaniko", "oci"}
+
+// ValidationError is a single problem with a resolved value
+type ValidationError struct {
+	Field    string
+	Variable string
+	Value    string
+	Err      error
+	Reason   string
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("%s (%s): %v", e.Field, e.Variable, e.Err)
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s, %s", msg, e.Reason)
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors collects all of the problems found when validating the values
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := []string{fmt.Sprintf("found %d problem(s) with the configuration:", len(e))}
+	for _, err := range e {
+		lines = append(lines, fmt.Sprintf("  - %v", err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap allows errors.Is and errors.As to be used against any of the collected errors
+func (e ValidationErrors) Unwrap() []error {
+	errs := []error{}
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Validate checks all of the values needed to run a build, returning every problem found rather than just the first
+func (b Builder) Validate() error {
+	errs := ValidationErrors{}
+	add := func(field, variable, value string, err error, reason string) {
+		errs = append(errs, &ValidationError{Field: field, Variable: variable, Value: value, Err: err, Reason: reason})
+	}
+	required := func(field, variable, value string) {
+		if value == "" {
+			add(field, variable, value, ErrRequired, "")
+		}
+	}
+
+	required("registryUsername", "BUILDER_REGISTRY_USERNAME", b.RegistryUsername)
+	required("registryPassword", "BUILDER_REGISTRY_PASSWORD", b.RegistryPassword)
+	// the host isn't needed when the database is connected to through a socket
+	if b.MTK.Socket == "" {
+		required("mtk.host", "BUILDER_MTK_HOSTNAME", b.MTK.Host)
+	} else if len(b.Replicas.Hosts) > 0 {
+		add("mtk.socket", "BUILDER_MTK_SOCKET", b.MTK.Socket, ErrUnsupported, "can't be used with read replicas")
+	}
+	if b.MTK.Port != "" {
+		if port, err := strconv.Atoi(b.MTK.Port); err != nil || port < 1 || port > 65535 {
+			add("mtk.port", "BUILDER_MTK_PORT", b.MTK.Port, ErrInvalidValue, "must be a port number from 1 to 65535")
+		}
+	}
+	if b.MTK.TLSMode != "" && !slices.Contains(supportedTLSModes, b.MTK.TLSMode) {
+		add("mtk.tlsMode", "BUILDER_MTK_TLS_MODE", b.MTK.TLSMode, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedTLSModes, ", ")))
+	}
+	if (b.MTK.TLSCert == "") != (b.MTK.TLSKey == "") {
+		add("mtk.tlsCert", "BUILDER_MTK_TLS_CERT", b.MTK.TLSCert, ErrRequired, "the client certificate and key must be set together")
+	}
+	required("mtk.username", "BUILDER_MTK_USERNAME", b.MTK.Username)
+	required("mtk.database", "BUILDER_MTK_DATABASE", b.MTK.Database)
+
+	if !slices.Contains(supportedDatabaseTypes, b.DatabaseType) {
+		add("databaseType", "BUILDER_BACKUP_IMAGE_TYPE", b.DatabaseType, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedDatabaseTypes, ", ")))
+	} else {
+		if err := validateImageReference(b.SourceImageName); err != nil {
+			add("sourceImage", "BUILDER_IMAGE_NAME", b.SourceImageName, ErrInvalidReference, err.Error())
+		}
+		if err := validateImageReference(b.CleanImageName); err != nil {
+			add("cleanImage", "BUILDER_CLEAN_IMAGE_NAME", b.CleanImageName, ErrInvalidReference, err.Error())
+		}
+		if reason := checkImagePair(b.DatabaseType, b.SourceImageName, b.CleanImageName); reason != "" {
+			add("cleanImage", "BUILDER_CLEAN_IMAGE_NAME", b.CleanImageName, ErrIncompatibleImages, reason)
+		}
+	}
+	if !slices.Contains(supportedRegistryTypes, b.RegistryType) {
+		add("registryType", "BUILDER_REGISTRY_TYPE", b.RegistryType, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedRegistryTypes, ", ")))
+	} else if err := validateResultRepository(b.ResultImageName, b.RegistryHost, b.resultRegistryType()); err != nil {
+		add("resultImageName", "BUILDER_BACKUP_IMAGE_NAME", b.ResultImageName, ErrInvalidReference, err.Error())
+	}
+	tagReason := "tags must be at most 128 characters of letters, digits, underscores, periods and dashes, and not start with a period or dash"
+	if b.ResultImageTag == "" {
+		add("resultImageTag", "BUILDER_BACKUP_IMAGE_TAG", b.ResultImageTag, ErrRequired, "the tag is empty once its placeholders are replaced")
+	} else if !tagRegexp.MatchString(b.ResultImageTag) {
+		add("resultImageTag", "BUILDER_BACKUP_IMAGE_TAG", b.ResultImageTag, ErrInvalidReference, tagReason)
+	}
+	for _, tag := range b.ExtraTags {
+		if !tagRegexp.MatchString(tag) {
+			add("extraTags", "BUILDER_BACKUP_IMAGE_EXTRA_TAGS", tag, ErrInvalidReference, tagReason)
+		}
+	}
+	if !slices.Contains(supportedPushTags, b.PushTags) {
+		add("pushTags", "BUILDER_PUSH_TAGS", b.PushTags, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedPushTags, ", ")))
+	} else if len(b.Tags) == 0 {
+		add("tags", "BUILDER_PUSH_TAGS", b.PushTags, ErrRequired, "there are no tags to push, custom needs BUILDER_BACKUP_IMAGE_EXTRA_TAGS")
+	}
+	if !slices.Contains(supportedBuildBackends, b.BuildBackend) {
+		add("buildBackend", "BUILDER_BUILD_BACKEND", b.BuildBackend, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedBuildBackends, ", ")))
+	}
+	if b.BuildBackend == "oci" && !filepath.IsAbs(b.DataDir) {
+		add("dataDir", "BUILDER_DATA_DIR", b.DataDir, ErrInvalidValue, "must be an absolute path")
+	}
+	if b.BuildBackend == "kaniko" && !filepath.IsAbs(b.ContextOutputDir) {
+		add("contextOutputDir", "BUILDER_CONTEXT_OUTPUT_DIR", b.ContextOutputDir, ErrInvalidValue, "must be an absolute path")
+	}
+	if b.debugValue != "" {
+		if _, err := strconv.ParseBool(b.debugValue); err != nil {
+			add("debug", "BUILDER_IMAGE_DEBUG", b.debugValue, ErrInvalidValue, "must be true or false")
+		}
+	}
+	if b.randomRootPasswordValue != "" {
+		if _, err := strconv.ParseBool(b.randomRootPasswordValue); err != nil {
+			add("resultImageRandomRootPassword", "BUILDER_RESULT_IMAGE_RANDOM_ROOT_PASSWORD", b.randomRootPasswordValue, ErrInvalidValue, "must be true or false")
+		}
+	}
+	if !databaseUserRegexp.MatchString(b.ResultImageUser) {
+		add("resultImageUser", "BUILDER_RESULT_IMAGE_USER", b.ResultImageUser, ErrInvalidValue,
+			"must be at most 32 characters of letters, digits and underscores")
+	}
+	password := func(field, variable, value string) {
+		if value == "" {
+			add(field, variable, value, ErrRequired, "")
+		} else if strings.ContainsFunc(value, func(r rune) bool { return r < ' ' || r == '"' || r == '\\' || r == 0x7f }) {
+			// the password is written into the my.cnf of the resulting image as a quoted value
+			add(field, variable, value, ErrInvalidValue, "must not contain quotes, backslashes or control characters")
+		}
+	}
+	// postgres images don't have a root password, and a random one is only generated when the build runs
+	if b.DatabaseType != "postgres" && !b.ResultImageRandomRootPassword {
+		password("resultImageRootPassword", "BUILDER_RESULT_IMAGE_ROOT_PASSWORD", b.ResultImageRootPassword)
+	}
+	password("resultImagePassword", "BUILDER_RESULT_IMAGE_PASSWORD", b.ResultImagePassword)
+	// the preset expressions are written in the sql dialect of the database, so not every preset is there for postgres
+	if b.MTKPreset != "" && !slices.Contains(Presets(b.DatabaseType), b.MTKPreset) {
+		add("mtkPreset", "BUILDER_MTK_PRESET", b.MTKPreset, ErrUnsupported,
+			fmt.Sprintf("must be one of %s for %s", strings.Join(Presets(b.DatabaseType), ", "), b.DatabaseType))
+	}
+	for _, layer := range b.mtkConfigLayers() {
+		if _, err := parseMTKConfig(layer.Variable, layer.Value); err != nil {
+			// report each of the problems in the config separately so that they can all be fixed at once
+			var configErrs MTKConfigErrors
+			if errors.As(err, &configErrs) {
+				for _, configErr := range configErrs {
+					add(layer.Field, layer.Variable, layer.Value, ErrInvalidValue, configErr.Error())
+				}
+			} else {
+				add(layer.Field, layer.Variable, layer.Value, ErrInvalidValue, err.Error())
+			}
+		}
+	}
+	size := func(field, variable, value string) {
+		if !mysqlSizeRegexp.MatchString(value) {
+			add(field, variable, value, ErrInvalidValue, "must be a size in bytes, optionally followed by K, M or G, eg 512M")
+		}
+	}
+	size("import.bufferPoolSize", "BUILDER_IMPORT_BUFFER_POOL_SIZE", b.Import.BufferPoolSize)
+	size("import.maxAllowedPacket", "BUILDER_IMPORT_MAX_ALLOWED_PACKET", b.Import.MaxAllowedPacket)
+	size("import.sortBufferSize", "BUILDER_IMPORT_SORT_BUFFER_SIZE", b.Import.SortBufferSize)
+	if threads, err := strconv.Atoi(b.Import.IOThreads); err != nil || threads < 1 || threads > 64 {
+		add("import.ioThreads", "BUILDER_IMPORT_IO_THREADS", b.Import.IOThreads, ErrInvalidValue, "must be a number from 1 to 64")
+	}
+	size("myCnf.maxAllowedPacket", "BUILDER_MYCNF_MAX_ALLOWED_PACKET", b.MyCnf.MaxAllowedPacket)
+	if b.MyCnf.BufferPoolSize != "" {
+		size("myCnf.bufferPoolSize", "BUILDER_MYCNF_BUFFER_POOL_SIZE", b.MyCnf.BufferPoolSize)
+	}
+	for _, keep := range []struct{ field, variable, value string }{
+		{field: "retention.keepLast", variable: "BUILDER_RETENTION_KEEP_LAST", value: b.Retention.KeepLast},
+		{field: "retention.keepDaily", variable: "BUILDER_RETENTION_KEEP_DAILY", value: b.Retention.KeepDaily},
+		{field: "retention.keepWeekly", variable: "BUILDER_RETENTION_KEEP_WEEKLY", value: b.Retention.KeepWeekly},
+		{field: "retention.keepMonthly", variable: "BUILDER_RETENTION_KEEP_MONTHLY", value: b.Retention.KeepMonthly},
+	} {
+		if keep.value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(keep.value); err != nil || n < 0 {
+			add(keep.field, keep.variable, keep.value, ErrInvalidValue, "must be a number of tags to keep")
+		}
+	}
+	if b.Retention.MaxAge != "" {
+		if _, err := parseMaxAge(b.Retention.MaxAge); err != nil {
+			add("retention.maxAge", "BUILDER_RETENTION_MAX_AGE", b.Retention.MaxAge, ErrInvalidValue,
+				fmt.Sprintf("%v, it must be a number of days or weeks, eg 90d or 12w, or a duration, eg 720h", err))
+		}
+	}
+	if _, err := regexp.Compile(b.Retention.TagPattern); err != nil || b.Retention.TagPattern == "" {
+		add("retention.tagPattern", "BUILDER_RETENTION_TAG_PATTERN", b.Retention.TagPattern, ErrInvalidValue, "must be a regular expression")
+	}
+	if b.Retention.dryRunValue != "" {
+		if _, err := strconv.ParseBool(b.Retention.dryRunValue); err != nil {
+			add("retention.dryRun", "BUILDER_RETENTION_DRY_RUN", b.Retention.dryRunValue, ErrInvalidValue, "must be true or false")
+		}
+	}
+	if !slices.Contains(supportedReplicaStrategies, b.Replicas.Strategy) {
+		add("replicas.strategy", "BUILDER_READREPLICA_STRATEGY", b.Replicas.Strategy, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedReplicaStrategies, ", ")))
+	}
+	if b.Replicas.MaxLag != "" {
+		if lag, err := strconv.Atoi(b.Replicas.MaxLag); err != nil || lag < 0 {
+			add("replicas.maxLag", "BUILDER_READREPLICA_MAX_LAG", b.Replicas.MaxLag, ErrInvalidValue, "must be a number of seconds")
+		}
+	}
+	if attempts, err := strconv.Atoi(b.DockerReady.Attempts); err != nil || attempts < 1 {
+		add("dockerReady.attempts", "BUILDER_DOCKER_READY_ATTEMPTS", b.DockerReady.Attempts, ErrInvalidValue, "must be a positive number")
+	}
+	delay, err := strconv.Atoi(b.DockerReady.Delay)
+	if err != nil || delay < 0 {
+		add("dockerReady.delay", "BUILDER_DOCKER_READY_DELAY", b.DockerReady.Delay, ErrInvalidValue, "must be a number of seconds")
+	} else if maxDelay, err := strconv.Atoi(b.DockerReady.MaxDelay); err != nil || maxDelay < delay {
+		add("dockerReady.maxDelay", "BUILDER_DOCKER_READY_MAX_DELAY", b.DockerReady.MaxDelay, ErrInvalidValue,
+			"must be a number of seconds that isn't less than BUILDER_DOCKER_READY_DELAY")
+	}
+	if !slices.Contains(supportedCompressionCodecs, b.Compression.Codec) {
+		add("compression.codec", "BUILDER_DUMP_COMPRESSION", b.Compression.Codec, ErrUnsupported,
+			fmt.Sprintf("must be one of %s", strings.Join(supportedCompressionCodecs, ", ")))
+	} else if b.Compression.Level != "" {
+		low, high := b.Compression.levels()
+		if level, err := strconv.Atoi(b.Compression.Level); b.Compression.Codec == "none" {
+			add("compression.level", "BUILDER_DUMP_COMPRESSION_LEVEL", b.Compression.Level, ErrUnsupported, "can't be set without compression")
+		} else if err != nil || level < low || level > high {
+			add("compression.level", "BUILDER_DUMP_COMPRESSION_LEVEL", b.Compression.Level, ErrInvalidValue,
+				fmt.Sprintf("must be a number from %d to %d for %s", low, high, b.Compression.Codec))
+		}
+	}
+	if b.ExtendedInsertRows != "" {
+		if rows, err := strconv.Atoi(b.ExtendedInsertRows); err != nil || rows < 1 {
+			add("extendedInsertRows", "BUILDER_MTK_EXTENDED_INSERT_ROWS", b.ExtendedInsertRows, ErrInvalidValue, "must be a positive number")
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Validate generates the values and then validates them
+func Validate() error {
+	build, err := generateValues(time.Now())
+	if err != nil {
+		return err
+	}
+	return build.Validate()
+}
+
+var (
+	// these follow the grammar in https://github.com/distribution/reference
+	domainComponentRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	pathComponentRegexp   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	// databaseUserRegexp matches the user names that can be created in both mysql and postgres
+	databaseUserRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+	// mysqlSizeRegexp matches the sizes that mysqld accepts, eg 1073741824, 512M or 2G
+	mysqlSizeRegexp = regexp.MustCompile(`^[1-9][0-9]*[KMG]?$`)
+	tagRegexp       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// maxRepositoryLength is the longest repository name, including the registry host, that the distribution grammar allows
+const maxRepositoryLength = 255
+
+// splitDomain splits the registry domain from the rest of a repository name, the first component is only a domain if it
+// contains a period or port, or is localhost, which is the same way docker decides
+func splitDomain(name string) (string, string) {
+	domain, remainder, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+		return "", name
+	}
+	return domain, remainder
+}
+
+// validateRepository checks that a repository name without a tag or digest is valid
+func validateRepository(name string) error {
+	if name == "" {
+		return fmt.Errorf("the name is empty")
+	}
+	if len(name) > maxRepositoryLength {
+		return fmt.Errorf("the name is %d characters long, it can be at most %d", len(name), maxRepositoryLength)
+	}
+	domain, path := splitDomain(name)
+	if domain != "" && !domainComponentRegexp.MatchString(domain) {
+		return fmt.Errorf("%q is not a valid registry host", domain)
+	}
+	return validatePath(path)
+}
+
+// validatePath checks the path components of a repository name, without the registry host
+func validatePath(path string) error {
+	for _, component := range strings.Split(path, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return fmt.Errorf("%q is not a valid path component, components must be lowercase letters and digits separated by a period, underscores or dashes", component)
+		}
+	}
+	return nil
+}
+
+// validateImageReference checks that a full image reference with an optional tag and digest is valid
+func validateImageReference(ref string) error {
+	name, digest, hasDigest := strings.Cut(ref, "@")
+	if hasDigest && !digestRegexp.MatchString(digest) {
+		return fmt.Errorf("%q is not a valid digest", digest)
+	}
+	// a tag is anything after the last colon, as long as it isn't part of the registry port
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		tag := name[idx+1:]
+		name = name[:idx]
+		if !tagRegexp.MatchString(tag) {
+			return fmt.Errorf("%q is not a valid tag", tag)
+		}
+	}
+	return validateRepository(name)
+}
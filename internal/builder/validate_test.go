@@ -0,0 +1,385 @@
+package builder
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func validBuilder() Builder {
+	return Builder{
+		DockerComposeServiceName:      "mariadb",
+		FixedDockerComposeServiceName: "MARIADB",
+		SourceImageName:               "mariadb:10.6",
+		CleanImageName:                "uselagoon/mariadb-10.6-drupal:latest",
+		ResultImageDatabaseName:       "drupal",
+		ResultImageRootPassword:       "Lag00n",
+		ResultImageUser:               "drupal",
+		ResultImagePassword:           "drupal",
+		ResultImageName:               "quay.io/lagpro/lagenv",
+		DockerHost:                    "docker-host.lagoon-image-builder.svc",
+		DockerReady:                   DockerReady{Attempts: "10", Delay: "5", MaxDelay: "5"},
+		PushTags:                      "both",
+		BuildBackend:                  "docker",
+		DataDir:                       "/initialized-db",
+		ContextOutputDir:              "/workspace",
+		RegistryUsername:              "reguser",
+		RegistryPassword:              "regpass",
+		RegistryHost:                  "quay.io",
+		RegistryType:                  "auto",
+		ResultImageTag:                "backup-2026-10-18",
+		Tags:                          []string{"latest", "backup-2026-10-18"},
+		DatabaseType:                  "mariadb",
+		MTK: MTK{
+			Host:     "dbhost",
+			Username: "dbuser",
+			Password: "dbpass",
+			Database: "dbname",
+		},
+		Import: Import{
+			BufferPoolSize:   "2G",
+			MaxAllowedPacket: "1G",
+			SortBufferSize:   "128M",
+			IOThreads:        "4",
+		},
+		MyCnf: MyCnf{
+			MaxAllowedPacket: "1G",
+		},
+		Retention: Retention{
+			TagPattern: defaultRetentionTagPattern,
+		},
+		Replicas: Replicas{
+			Strategy: "first-healthy",
+		},
+		Compression: Compression{
+			Codec: "gzip",
+		},
+	}
+}
+
+func Test_Builder_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		build       func(b *Builder)
+		want        []string
+	}{
+		{
+			name:        "test1",
+			description: "check that a valid configuration has no errors",
+			build:       func(b *Builder) {},
+			want:        nil,
+		},
+		{
+			name:        "test2",
+			description: "check that all of the problems are reported at once",
+			build: func(b *Builder) {
+				b.RegistryUsername = ""
+				b.RegistryPassword = ""
+				b.MTK.Host = ""
+				b.PushTags = "bogus"
+				b.debugValue = "yes please"
+			},
+			want: []string{"registryUsername", "registryPassword", "mtk.host", "pushTags", "debug"},
+		},
+		{
+			name:        "test3",
+			description: "check that an unsupported database type is reported instead of the blank images it leaves",
+			build: func(b *Builder) {
+				b.DatabaseType = "mongodb"
+				b.SourceImageName = ""
+				b.CleanImageName = ""
+			},
+			want: []string{"databaseType"},
+		},
+		{
+			name:        "test4",
+			description: "check invalid image references",
+			build: func(b *Builder) {
+				b.SourceImageName = "mariadb:10.6:latest"
+				b.CleanImageName = "uselagoon/MariaDB-10.6-drupal"
+				b.ResultImageName = "/lagenv"
+				b.ResultImageTag = "-backup"
+			},
+			want: []string{"sourceImage", "cleanImage", "resultImageName", "resultImageTag"},
+		},
+		{
+			name:        "test5",
+			description: "check extended insert rows must be a number",
+			build: func(b *Builder) {
+				b.ExtendedInsertRows = "lots"
+			},
+			want: []string{"extendedInsertRows"},
+		},
+		{
+			name:        "test6",
+			description: "check that builder and clean images of different versions are rejected",
+			build: func(b *Builder) {
+				b.CleanImageName = "uselagoon/mariadb-10.11-drupal:latest"
+			},
+			want: []string{"cleanImage"},
+		},
+		{
+			name:        "test7",
+			description: "check that unknown presets and undecodable mtk config are rejected",
+			build: func(b *Builder) {
+				b.MTKPreset = "joomla"
+				b.MTKYAML = "not base64"
+			},
+			want: []string{"mtkPreset", "mtkYAML"},
+		},
+		{
+			name:        "test8",
+			description: "check that unsupported build backends are rejected",
+			build: func(b *Builder) {
+				b.BuildBackend = "img"
+			},
+			want: []string{"buildBackend"},
+		},
+		{
+			name:        "test9",
+			description: "check that the oci backend needs an absolute data directory",
+			build: func(b *Builder) {
+				b.BuildBackend = "oci"
+				b.DataDir = "initialized-db"
+			},
+			want: []string{"dataDir"},
+		},
+		{
+			name:        "test10",
+			description: "check that the kaniko backend needs an absolute context output directory",
+			build: func(b *Builder) {
+				b.BuildBackend = "kaniko"
+				b.ContextOutputDir = "workspace"
+			},
+			want: []string{"contextOutputDir"},
+		},
+		{
+			name:        "test11",
+			description: "check the import tuning and my.cnf settings are validated",
+			build: func(b *Builder) {
+				b.Import.BufferPoolSize = "2GB"
+				b.Import.IOThreads = "0"
+				b.MyCnf.MaxAllowedPacket = "1G; rm -rf /"
+				b.MyCnf.BufferPoolSize = "lots"
+			},
+			want: []string{"import.bufferPoolSize", "import.ioThreads", "myCnf.maxAllowedPacket", "myCnf.bufferPoolSize"},
+		},
+		{
+			name:        "test12",
+			description: "check the result image credentials are validated",
+			build: func(b *Builder) {
+				b.randomRootPasswordValue = "sometimes"
+				b.ResultImageUser = "drupal-user"
+				b.ResultImageRootPassword = `pass"word`
+				b.ResultImagePassword = ""
+			},
+			want: []string{"resultImageRandomRootPassword", "resultImageUser", "resultImageRootPassword", "resultImagePassword"},
+		},
+		{
+			name:        "test13",
+			description: "check the root password isn't needed when it is generated",
+			build: func(b *Builder) {
+				b.randomRootPasswordValue = "true"
+				b.ResultImageRandomRootPassword = true
+				b.ResultImageRootPassword = ""
+			},
+			want: nil,
+		},
+		{
+			name:        "test14",
+			description: "check an unsupported registry type",
+			build: func(b *Builder) {
+				b.RegistryType = "artifactory"
+			},
+			want: []string{"registryType"},
+		},
+		{
+			name:        "test15",
+			description: "check the resulting image is checked against the limits of its registry",
+			build: func(b *Builder) {
+				b.ResultImageName = "quay.io/lagpro/lagenv/mariadb"
+			},
+			want: []string{"resultImageName"},
+		},
+		{
+			name:        "test16",
+			description: "check custom push tags need extra tags, and the extra tags are checked",
+			build: func(b *Builder) {
+				b.PushTags = "custom"
+				b.ExtraTags = []string{"-weekly"}
+				b.Tags = nil
+			},
+			want: []string{"extraTags", "tags"},
+		},
+		{
+			name:        "test17",
+			description: "check the retention policy is validated",
+			build: func(b *Builder) {
+				b.Retention = Retention{
+					KeepLast:    "-1",
+					KeepDaily:   "7",
+					KeepWeekly:  "four",
+					MaxAge:      "90days",
+					TagPattern:  "backup-(",
+					dryRunValue: "maybe",
+				}
+			},
+			want: []string{"retention.keepLast", "retention.keepWeekly", "retention.maxAge", "retention.tagPattern", "retention.dryRun"},
+		},
+		{
+			name:        "test18",
+			description: "check the read replica strategy and max lag are validated",
+			build: func(b *Builder) {
+				b.Replicas = Replicas{Hosts: []string{"dbrrhost1"}, Strategy: "fastest", MaxLag: "1m"}
+			},
+			want: []string{"replicas.strategy", "replicas.maxLag"},
+		},
+		{
+			name:        "test19",
+			description: "check the connection settings are validated",
+			build: func(b *Builder) {
+				b.MTK.Port = "65536"
+				b.MTK.TLSMode = "verify-identity"
+				b.MTK.TLSKey = "/etc/ssl/private/client.key"
+			},
+			want: []string{"mtk.port", "mtk.tlsMode", "mtk.tlsCert"},
+		},
+		{
+			name:        "test20",
+			description: "check the host isn't needed with a socket, and tls can be used with postgres",
+			build: func(b *Builder) {
+				b.DatabaseType = "postgres"
+				b.SourceImageName = "postgres:14-alpine"
+				b.CleanImageName = "uselagoon/postgres-14-drupal:latest"
+				b.ResultImageRootPassword = ""
+				b.MTK.Host = ""
+				b.MTK.Socket = "/var/run/postgresql"
+				b.MTK.TLSMode = "require"
+			},
+			want: nil,
+		},
+		{
+			name:        "test21",
+			description: "check the compression level is validated against the range of the codec",
+			build: func(b *Builder) {
+				b.Compression = Compression{Codec: "gzip", Level: "19"}
+			},
+			want: []string{"compression.level"},
+		},
+		{
+			name:        "test22",
+			description: "check an unsupported codec is reported",
+			build: func(b *Builder) {
+				b.Compression = Compression{Codec: "bzip2"}
+			},
+			want: []string{"compression.codec"},
+		},
+		{
+			name:        "test23",
+			description: "check a level can't be set without compression",
+			build: func(b *Builder) {
+				b.Compression = Compression{Codec: "none", Level: "3"}
+			},
+			want: []string{"compression.level"},
+		},
+		{
+			name:        "test24",
+			description: "check a preset that is only written for mysql is rejected for postgres",
+			build: func(b *Builder) {
+				b.DatabaseType = "postgres"
+				b.SourceImageName = "postgres:14-alpine"
+				b.CleanImageName = "uselagoon/postgres-14-drupal:latest"
+				b.MTKPreset = "wordpress"
+			},
+			want: []string{"mtkPreset"},
+		},
+		{
+			name:        "test25",
+			description: "check a preset for postgres is accepted",
+			build: func(b *Builder) {
+				b.DatabaseType = "postgres"
+				b.SourceImageName = "postgres:14-alpine"
+				b.CleanImageName = "uselagoon/postgres-14-drupal:latest"
+				b.MTKPreset = "drupal8+"
+			},
+			want: nil,
+		},
+		{
+			name:        "test26",
+			description: "check the docker host wait needs at least one attempt and a max delay that isn't less than the delay",
+			build: func(b *Builder) {
+				b.DockerReady = DockerReady{Attempts: "0", Delay: "10", MaxDelay: "5"}
+			},
+			want: []string{"dockerReady.attempts", "dockerReady.maxDelay"},
+		},
+		{
+			name:        "test27",
+			description: "check an empty image tag is rejected",
+			build: func(b *Builder) {
+				b.ResultImageTag = ""
+			},
+			want: []string{"resultImageTag"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := validBuilder()
+			tt.build(&b)
+			err := b.Validate()
+			var got []string
+			var verrs ValidationErrors
+			if errors.As(err, &verrs) {
+				for _, verr := range verrs {
+					got = append(got, verr.Field)
+				}
+			} else if err != nil {
+				t.Fatalf("Validate() returned an unexpected error type %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v\n%v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func Test_Builder_Validate_errorTypes(t *testing.T) {
+	b := validBuilder()
+	b.RegistryPassword = ""
+	b.PushTags = "bogus"
+	err := b.Validate()
+	if !errors.Is(err, ErrRequired) {
+		t.Errorf("Validate() = %v, want ErrRequired", err)
+	}
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Validate() = %v, want ErrUnsupported", err)
+	}
+	if errors.Is(err, ErrInvalidReference) {
+		t.Errorf("Validate() = %v, did not want ErrInvalidReference", err)
+	}
+}
+
+func Test_validateImageReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr bool
+	}{
+		{ref: "mariadb:10.6"},
+		{ref: "uselagoon/mariadb-10.6-drupal:latest"},
+		{ref: "localhost:5000/lagoon/mariadb"},
+		{ref: "registry.example.com:5000/lagoon/mariadb:10.6"},
+		{ref: "mysql@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{ref: "", wantErr: true},
+		{ref: "Uselagoon/mariadb", wantErr: true},
+		{ref: "uselagoon//mariadb", wantErr: true},
+		{ref: "uselagoon/mariadb:", wantErr: true},
+		{ref: "mysql@sha256:nothex", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if err := validateImageReference(tt.ref); (err != nil) != tt.wantErr {
+				t.Errorf("validateImageReference(%q) = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			}
+		})
+	}
+}
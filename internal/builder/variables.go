@@ -17,6 +17,12 @@ type EnvironmentVariable struct {
 
 // readVariables reads the lagoon environment and project variables and merges them, environment variables take precedence
 func readVariables() []variables.LagoonEnvironmentVariable {
+	projectVars, envVars := readVariableLayers()
+	return mergeVariables(projectVars, envVars)
+}
+
+// readVariableLayers reads the lagoon project and environment variables without merging them
+func readVariableLayers() ([]variables.LagoonEnvironmentVariable, []variables.LagoonEnvironmentVariable) {
 	projectVariables := variables.GetEnv("LAGOON_PROJECT_VARIABLES", "")
 	environmentVariables := variables.GetEnv("LAGOON_ENVIRONMENT_VARIABLES", "")
 
@@ -24,7 +30,7 @@ func readVariables() []variables.LagoonEnvironmentVariable {
 	envVars := []variables.LagoonEnvironmentVariable{}
 	json.Unmarshal([]byte(projectVariables), &projectVars)
 	json.Unmarshal([]byte(environmentVariables), &envVars)
-	return mergeVariables(projectVars, envVars)
+	return projectVars, envVars
 }
 
 // mergeVariables will be irrelevant once https://github.com/uselagoon/lagoon/pull/3856 is merged and relased, as it will consolidate variables into one payload
@@ -72,51 +78,214 @@ func mergeVariables(project, environment []variables.LagoonEnvironmentVariable)
 
 // checks the provided environment variables looking for feature flag based variables
 func checkFeatureFlag(key string, envVariables []variables.LagoonEnvironmentVariable) string {
+	value, _ := lookupFeatureFlag(key, envVariables)
+	return value
+}
+
+// lookupFeatureFlag is the same as checkFeatureFlag, but also returns where the flag was found
+func lookupFeatureFlag(key string, envVariables []variables.LagoonEnvironmentVariable) (string, Source) {
 	// check for force value
-	if value, ok := os.LookupEnv(fmt.Sprintf("LAGOON_FEATURE_FLAG_FORCE_%s", key)); ok {
-		return value
+	forceKey := fmt.Sprintf("LAGOON_FEATURE_FLAG_FORCE_%s", key)
+	if value, ok := os.LookupEnv(forceKey); ok {
+		return value, Source{Layer: layerFeatureFlagForce, Variable: forceKey}
 	}
 	// check lagoon environment variables
 	for _, lVar := range envVariables {
 		if strings.Contains(lVar.Name, fmt.Sprintf("LAGOON_FEATURE_FLAG_%s", key)) {
-			return lVar.Value
+			return lVar.Value, Source{Layer: layerFeatureFlag, Variable: lVar.Name, Scope: lVar.Scope}
 		}
 	}
 	// return default
-	if value, ok := os.LookupEnv(fmt.Sprintf("LAGOON_FEATURE_FLAG_DEFAULT_%s", key)); ok {
-		return value
+	defaultKey := fmt.Sprintf("LAGOON_FEATURE_FLAG_DEFAULT_%s", key)
+	if value, ok := os.LookupEnv(defaultKey); ok {
+		return value, Source{Layer: layerFeatureFlagDefault, Variable: defaultKey}
 	}
 	// otherwise nothing
-	return ""
+	return "", Source{}
 }
 
 // checkVariable will check the variables from the featureflags, json payload and finally the environment variables
 // if none found, falls back to the value provided as the default
 func checkVariable(name, defValue string, vars []variables.LagoonEnvironmentVariable) string {
+	if value, _, ok := lookupVariable(name, vars); ok {
+		return value
+	}
+	// fall back to default provided value
+	return defValue
+}
+
+// lookupVariable checks the same places as checkVariable in the same order, but returns where the value was found
+// the boolean is false if nothing was found and the caller should fall back to a default
+func lookupVariable(name string, vars []variables.LagoonEnvironmentVariable) (string, Source, bool) {
 	// check any featureflag variables first
-	fflag := checkFeatureFlag(name, vars)
+	fflag, source := lookupFeatureFlag(name, vars)
 	if fflag != "" {
-		return fflag
+		return fflag, source, true
 	}
 	// get the JSON_PAYLOAD variable and search it for variables
+	if value, source, ok := lookupJSONPayload(name); ok {
+		return value, source, true
+	}
+	// search for the variable in the lagoon env vars
+	for _, v := range vars {
+		if v.Name == name {
+			return v.Value, Source{Layer: layerLagoonVariable, Variable: v.Name, Scope: v.Scope}, true
+		}
+	}
+	return "", Source{}, false
+}
+
+// lookupJSONPayload searches the task JSON_PAYLOAD for a variable
+func lookupJSONPayload(name string) (string, Source, bool) {
 	jsonPayload := variables.GetEnv("JSON_PAYLOAD", "")
 	if jsonPayload != "" {
 		jsonBytes, _ := base64.StdEncoding.DecodeString(jsonPayload)
 		var payloadData map[string]interface{}
 		if err := json.Unmarshal(jsonBytes, &payloadData); err != nil {
 			os.Stderr.WriteString(fmt.Sprintf("failed to unsmarshal the supplied JSON payload data, error was: %v\n", err))
-			return ""
+			return "", Source{Layer: layerJSONPayload, Variable: "JSON_PAYLOAD", Detail: "payload could not be parsed"}, true
 		}
 		if v, ok := payloadData[name]; ok {
-			return v.(string)
+			return v.(string), Source{Layer: layerJSONPayload, Variable: name}, true
 		}
 	}
-	// search for the variable in the lagoon env vars
-	for _, v := range vars {
-		if v.Name == name {
-			return v.Value
+	return "", Source{}, false
+}
+
+const (
+	layerFeatureFlagForce   = "feature flag (force)"
+	layerFeatureFlag        = "feature flag"
+	layerFeatureFlagDefault = "feature flag (default)"
+	layerJSONPayload        = "task JSON_PAYLOAD"
+	layerLagoonVariable     = "lagoon variable"
+	layerProject            = "project variable"
+	layerEnvironment        = "environment variable"
+	layerProcess            = "process environment"
+	layerDefault            = "default"
+	layerDerived            = "derived"
+)
+
+// Source describes where a resolved value came from
+type Source struct {
+	Layer    string `json:"layer"`
+	Variable string `json:"variable,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (s Source) String() string {
+	str := s.Layer
+	if s.Variable != "" {
+		str = fmt.Sprintf("%s %s", str, s.Variable)
+	}
+	if s.Scope != "" {
+		str = fmt.Sprintf("%s (scope %s)", str, s.Scope)
+	}
+	if s.Detail != "" {
+		str = fmt.Sprintf("%s: %s", str, s.Detail)
+	}
+	return str
+}
+
+// resolver looks up variables in the same way as checkVariable, but records the source of every value it resolves
+// against the json path of the field in the Builder that the value is used for
+type resolver struct {
+	vars        []variables.LagoonEnvironmentVariable
+	project     []variables.LagoonEnvironmentVariable
+	environment []variables.LagoonEnvironmentVariable
+	layers      map[string]string
+	sources     map[string]Source
+}
+
+// newResolver merges the project and environment variables, remembering which of the two each merged variable came from
+func newResolver(project, environment []variables.LagoonEnvironmentVariable) *resolver {
+	r := &resolver{
+		vars:        mergeVariables(project, environment),
+		project:     project,
+		environment: environment,
+		layers:      map[string]string{},
+		sources:     map[string]Source{},
+	}
+	for _, v := range r.vars {
+		r.layers[v.Name] = layerProject
+		for _, eVar := range environment {
+			if eVar == v {
+				r.layers[v.Name] = layerEnvironment
+			}
 		}
 	}
-	// fall back to default provided value
+	return r
+}
+
+// lookup is lookupVariable, with lagoon variables attributed to either the project or the environment
+func (r *resolver) lookup(name string) (string, Source, bool) {
+	value, source, ok := lookupVariable(name, r.vars)
+	if layer, exists := r.layers[source.Variable]; exists {
+		switch source.Layer {
+		case layerLagoonVariable:
+			source.Layer = layer
+		case layerFeatureFlag:
+			source.Layer = fmt.Sprintf("%s (%s)", layerFeatureFlag, layer)
+		}
+	}
+	return value, source, ok
+}
+
+// variable resolves a variable, falling back to the process environment and then the provided default
+// this is the equivalent of checkVariable(name, variables.GetEnv(name, defValue), vars)
+func (r *resolver) variable(field, name, defValue string) string {
+	if value, source, ok := r.lookup(name); ok {
+		r.sources[field] = source
+		return value
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		r.sources[field] = Source{Layer: layerProcess, Variable: name}
+		return value
+	}
+	r.sources[field] = Source{Layer: layerDefault}
 	return defValue
 }
+
+// layered resolves a variable from every layer that sets it rather than only the one that takes precedence, in the
+// order project, environment and then the task JSON_PAYLOAD, so that the values can be merged instead of replaced
+// the process environment is only used if none of the layers set it
+func (r *resolver) layered(field, name string) []string {
+	var values []string
+	layers := []string{}
+	for _, layer := range []struct {
+		name string
+		vars []variables.LagoonEnvironmentVariable
+	}{
+		{name: layerProject, vars: r.project},
+		{name: layerEnvironment, vars: r.environment},
+	} {
+		for _, v := range layer.vars {
+			if v.Name == name && v.Scope != "internal_system" && v.Value != "" {
+				values = append(values, v.Value)
+				layers = append(layers, layer.name)
+			}
+		}
+	}
+	if value, _, ok := lookupJSONPayload(name); ok && value != "" {
+		values = append(values, value)
+		layers = append(layers, layerJSONPayload)
+	}
+	if value, ok := os.LookupEnv(name); ok && len(values) == 0 && value != "" {
+		values = append(values, value)
+		layers = append(layers, layerProcess)
+	}
+	switch len(layers) {
+	case 0:
+		r.sources[field] = Source{Layer: layerDefault}
+	case 1:
+		r.sources[field] = Source{Layer: layers[0], Variable: name}
+	default:
+		r.sources[field] = Source{Layer: strings.Join(layers, " + "), Variable: name, Detail: "merged in this order"}
+	}
+	return values
+}
+
+// record sets the source of a field that wasn't resolved through the resolver directly
+func (r *resolver) record(field string, source Source) {
+	r.sources[field] = source
+}
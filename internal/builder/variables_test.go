@@ -211,6 +211,75 @@ func Test_checkVariable(t *testing.T) {
 	}
 }
 
+func Test_resolver_layered(t *testing.T) {
+	type args struct {
+		project     []variables.LagoonEnvironmentVariable
+		environment []variables.LagoonEnvironmentVariable
+		setVars     []EnvironmentVariable
+	}
+	tests := []struct {
+		name        string
+		description string
+		args        args
+		want        []string
+		wantSource  Source
+	}{
+		{
+			name:        "test1",
+			description: "check that the project, environment and task values are all returned in order",
+			args: args{
+				project: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_MTK_YAML_EXTRA_BASE64", Value: "project", Scope: "global"},
+				},
+				environment: []variables.LagoonEnvironmentVariable{
+					{Name: "BUILDER_MTK_YAML_EXTRA_BASE64", Value: "environment", Scope: "global"},
+				},
+				setVars: []EnvironmentVariable{
+					{Name: "JSON_PAYLOAD", Value: genBase64JSONPayload(map[string]string{"BUILDER_MTK_YAML_EXTRA_BASE64": "task"})},
+				},
+			},
+			want: []string{"project", "environment", "task"},
+			wantSource: Source{
+				Layer:    "project variable + environment variable + task JSON_PAYLOAD",
+				Variable: "BUILDER_MTK_YAML_EXTRA_BASE64",
+				Detail:   "merged in this order",
+			},
+		},
+		{
+			name:        "test2",
+			description: "check that the process environment is only used when no layer sets the variable",
+			args: args{
+				setVars: []EnvironmentVariable{
+					{Name: "BUILDER_MTK_YAML_EXTRA_BASE64", Value: "process"},
+				},
+			},
+			want:       []string{"process"},
+			wantSource: Source{Layer: layerProcess, Variable: "BUILDER_MTK_YAML_EXTRA_BASE64"},
+		},
+		{
+			name:        "test3",
+			description: "check that nothing is returned when the variable isn't set",
+			want:        nil,
+			wantSource:  Source{Layer: layerDefault},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, envVar := range tt.args.setVars {
+				t.Setenv(envVar.Name, envVar.Value)
+			}
+			r := newResolver(tt.args.project, tt.args.environment)
+			got := r.layered("mtkYAMLExtra", "BUILDER_MTK_YAML_EXTRA_BASE64")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("layered() = %v, want %v", got, tt.want)
+			}
+			if r.sources["mtkYAMLExtra"] != tt.wantSource {
+				t.Errorf("layered() source = %v, want %v", r.sources["mtkYAMLExtra"], tt.wantSource)
+			}
+		})
+	}
+}
+
 func genBase64JSONPayload(p map[string]string) string {
 	b, _ := json.Marshal(p)
 	return base64.StdEncoding.EncodeToString(b)
@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// imagePair is a builder image and the Lagoon clean image that its data directory can be copied into
+type imagePair struct {
+	DatabaseType string
+	Version      string
+	SourceImage  string
+	CleanImage   string
+}
+
+// versionMatrix is every known version of each database type, with the upstream image used to build the data
+// directory and the Lagoon image it is copied into, the data directory of one version can't be used by another
+var versionMatrix = []imagePair{
+	{DatabaseType: "mariadb", Version: "10.4", SourceImage: "mariadb:10.4", CleanImage: "uselagoon/mariadb-10.4-drupal:latest"},
+	{DatabaseType: "mariadb", Version: "10.5", SourceImage: "mariadb:10.5", CleanImage: "uselagoon/mariadb-10.5-drupal:latest"},
+	{DatabaseType: "mariadb", Version: "10.6", SourceImage: "mariadb:10.6", CleanImage: "uselagoon/mariadb-10.6-drupal:latest"},
+	{DatabaseType: "mariadb", Version: "10.11", SourceImage: "mariadb:10.11", CleanImage: "uselagoon/mariadb-10.11-drupal:latest"},
+	{DatabaseType: "mariadb", Version: "11.4", SourceImage: "mariadb:11.4", CleanImage: "uselagoon/mariadb-11.4-drupal:latest"},
+	{DatabaseType: "mysql", Version: "8.0", SourceImage: "mysql:8.0.41-oracle", CleanImage: "uselagoon/mysql-8.0:latest"},
+	{DatabaseType: "mysql", Version: "8.4", SourceImage: "mysql:8.4-oracle", CleanImage: "uselagoon/mysql-8.4:latest"},
+	{DatabaseType: "postgres", Version: "11", SourceImage: "postgres:11-alpine", CleanImage: "uselagoon/postgres-11-drupal:latest"},
+	{DatabaseType: "postgres", Version: "12", SourceImage: "postgres:12-alpine", CleanImage: "uselagoon/postgres-12-drupal:latest"},
+	{DatabaseType: "postgres", Version: "13", SourceImage: "postgres:13-alpine", CleanImage: "uselagoon/postgres-13-drupal:latest"},
+	{DatabaseType: "postgres", Version: "14", SourceImage: "postgres:14-alpine", CleanImage: "uselagoon/postgres-14-drupal:latest"},
+	{DatabaseType: "postgres", Version: "15", SourceImage: "postgres:15-alpine", CleanImage: "uselagoon/postgres-15-drupal:latest"},
+	{DatabaseType: "postgres", Version: "16", SourceImage: "postgres:16-alpine", CleanImage: "uselagoon/postgres-16-drupal:latest"},
+	{DatabaseType: "postgres", Version: "17", SourceImage: "postgres:17-alpine", CleanImage: "uselagoon/postgres-17-drupal:latest"},
+}
+
+// imageVersion is the database type and version that an image runs
+type imageVersion struct {
+	DatabaseType string
+	Version      string
+}
+
+func (v imageVersion) String() string {
+	return fmt.Sprintf("%s %s", v.DatabaseType, v.Version)
+}
+
+var (
+	// lagoonImageRegexp matches the name of the Lagoon images, eg mariadb-10.6-drupal or postgres-14
+	lagoonImageRegexp = regexp.MustCompile(`^(mariadb|mysql|postgres)-(\d+(?:\.\d+)?)(?:-[a-z0-9-]+)?$`)
+	// versionTagRegexp matches the version at the start of an upstream image tag, eg 10.6, 8.0.41-oracle or 14-alpine
+	versionTagRegexp = regexp.MustCompile(`^(\d+)(?:\.(\d+))?`)
+)
+
+// parseImageVersion works out the database type and version of an image from its name and tag
+// the Lagoon images have the version in their name, and the upstream images have it in their tag
+// false is returned if the version can't be worked out, eg from a custom image or a `latest` tag
+func parseImageVersion(ref string) (imageVersion, bool) {
+	ref, _, _ = strings.Cut(ref, "@")
+	repository, tag := ref, ""
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repository, tag = ref[:i], ref[i+1:]
+	}
+	name := path.Base(repository)
+	if m := lagoonImageRegexp.FindStringSubmatch(name); m != nil {
+		return imageVersion{DatabaseType: m[1], Version: m[2]}, true
+	}
+	m := versionTagRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return imageVersion{}, false
+	}
+	switch name {
+	case "mariadb", "mysql":
+		// the data directory changes between minor versions, so a tag like `10` isn't enough to know which one it is
+		if m[2] == "" {
+			return imageVersion{}, false
+		}
+		return imageVersion{DatabaseType: name, Version: fmt.Sprintf("%s.%s", m[1], m[2])}, true
+	case "postgres":
+		return imageVersion{DatabaseType: name, Version: m[1]}, true
+	}
+	return imageVersion{}, false
+}
+
+// lookupImagePair finds the images for a version in the versionMatrix
+func lookupImagePair(v imageVersion) (imagePair, bool) {
+	for _, pair := range versionMatrix {
+		if pair.DatabaseType == v.DatabaseType && pair.Version == v.Version {
+			return pair, true
+		}
+	}
+	return imagePair{}, false
+}
+
+// pairImages infers the builder image from the clean image, or the clean image from the builder image, when only
+// one of them has been set so that they are always the same version
+func pairImages(build *Builder, r *resolver) {
+	sourceSet := r.sources["sourceImage"].Layer != layerDefault
+	cleanSet := r.sources["cleanImage"].Layer != layerDefault
+	switch {
+	case cleanSet && !sourceSet:
+		if v, ok := parseImageVersion(build.CleanImageName); ok && v.DatabaseType == build.DatabaseType {
+			if pair, ok := lookupImagePair(v); ok {
+				build.SourceImageName = pair.SourceImage
+				r.record("sourceImage", Source{Layer: layerDerived, Detail: fmt.Sprintf("paired with cleanImage %s", build.CleanImageName)})
+			}
+		}
+	case sourceSet && !cleanSet:
+		if v, ok := parseImageVersion(build.SourceImageName); ok && v.DatabaseType == build.DatabaseType {
+			if pair, ok := lookupImagePair(v); ok {
+				build.CleanImageName = pair.CleanImage
+				r.record("cleanImage", Source{Layer: layerDerived, Detail: fmt.Sprintf("paired with sourceImage %s", build.SourceImageName)})
+			}
+		}
+	}
+}
+
+// checkImagePair returns the reason that the builder and clean images can't be used together, or an empty string
+// if they can, images where the version can't be worked out are allowed as there is nothing to check them against
+func checkImagePair(databaseType, sourceImage, cleanImage string) string {
+	source, sourceOK := parseImageVersion(sourceImage)
+	clean, cleanOK := parseImageVersion(cleanImage)
+	for _, v := range []struct {
+		image   string
+		version imageVersion
+		ok      bool
+	}{
+		{image: sourceImage, version: source, ok: sourceOK},
+		{image: cleanImage, version: clean, ok: cleanOK},
+	} {
+		if v.ok && v.version.DatabaseType != databaseType {
+			return fmt.Sprintf("%s is a %s image but BUILDER_BACKUP_IMAGE_TYPE is %s", v.image, v.version.DatabaseType, databaseType)
+		}
+	}
+	if sourceOK && cleanOK && source != clean {
+		reason := fmt.Sprintf("the builder image %s is %s but the clean image %s is %s, they must be the same version",
+			sourceImage, source, cleanImage, clean)
+		if pair, ok := lookupImagePair(clean); ok {
+			reason = fmt.Sprintf("%s (use %s as the builder image)", reason, pair.SourceImage)
+		}
+		return reason
+	}
+	return ""
+}
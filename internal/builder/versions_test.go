@@ -0,0 +1,141 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/uselagoon/machinery/utils/variables"
+)
+
+func Test_parseImageVersion(t *testing.T) {
+	tests := []struct {
+		ref    string
+		want   imageVersion
+		wantOK bool
+	}{
+		{ref: "mariadb:10.6", want: imageVersion{DatabaseType: "mariadb", Version: "10.6"}, wantOK: true},
+		{ref: "docker.io/library/mariadb:10.11.5-jammy", want: imageVersion{DatabaseType: "mariadb", Version: "10.11"}, wantOK: true},
+		{ref: "mysql:8.0.41-oracle", want: imageVersion{DatabaseType: "mysql", Version: "8.0"}, wantOK: true},
+		{ref: "postgres:14-alpine", want: imageVersion{DatabaseType: "postgres", Version: "14"}, wantOK: true},
+		{ref: "uselagoon/mariadb-10.11-drupal:latest", want: imageVersion{DatabaseType: "mariadb", Version: "10.11"}, wantOK: true},
+		{ref: "registry.example.com:5000/uselagoon/mysql-8.4", want: imageVersion{DatabaseType: "mysql", Version: "8.4"}, wantOK: true},
+		{ref: "uselagoon/postgres-16-drupal@sha256:0123456789abcdef0123456789abcdef", want: imageVersion{DatabaseType: "postgres", Version: "16"}, wantOK: true},
+		{ref: "mariadb:latest", wantOK: false},
+		{ref: "mariadb:10", wantOK: false},
+		{ref: "example/custom-db:10.6", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, ok := parseImageVersion(tt.ref)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseImageVersion() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_checkImagePair(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		databaseType string
+		sourceImage  string
+		cleanImage   string
+		want         string
+	}{
+		{
+			name:         "test1",
+			description:  "check that the defaults are compatible",
+			databaseType: "mariadb",
+			sourceImage:  "mariadb:10.6",
+			cleanImage:   "uselagoon/mariadb-10.6-drupal:latest",
+		},
+		{
+			name:         "test2",
+			description:  "check that different versions are rejected, suggesting the matching builder image",
+			databaseType: "mariadb",
+			sourceImage:  "mariadb:10.6",
+			cleanImage:   "uselagoon/mariadb-10.11-drupal:latest",
+			want:         "the builder image mariadb:10.6 is mariadb 10.6 but the clean image uselagoon/mariadb-10.11-drupal:latest is mariadb 10.11, they must be the same version (use mariadb:10.11 as the builder image)",
+		},
+		{
+			name:         "test3",
+			description:  "check that images for a different database type are rejected",
+			databaseType: "mariadb",
+			sourceImage:  "mariadb:10.6",
+			cleanImage:   "uselagoon/mysql-8.0:latest",
+			want:         "uselagoon/mysql-8.0:latest is a mysql image but BUILDER_BACKUP_IMAGE_TYPE is mariadb",
+		},
+		{
+			name:         "test4",
+			description:  "check that images with an unknown version are allowed",
+			databaseType: "mariadb",
+			sourceImage:  "example/custom-mariadb:stable",
+			cleanImage:   "uselagoon/mariadb-10.11-drupal:latest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkImagePair(tt.databaseType, tt.sourceImage, tt.cleanImage); got != tt.want {
+				t.Errorf("checkImagePair() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pairImages(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		vars        []variables.LagoonEnvironmentVariable
+		wantSource  string
+		wantClean   string
+	}{
+		{
+			name:        "test1",
+			description: "check that the builder image is inferred from the clean image",
+			vars: []variables.LagoonEnvironmentVariable{
+				{Name: "BUILDER_CLEAN_IMAGE_NAME", Value: "uselagoon/mariadb-10.11-drupal", Scope: "global"},
+			},
+			wantSource: "mariadb:10.11",
+			wantClean:  "uselagoon/mariadb-10.11-drupal",
+		},
+		{
+			name:        "test2",
+			description: "check that the clean image is inferred from the builder image",
+			vars: []variables.LagoonEnvironmentVariable{
+				{Name: "BUILDER_BACKUP_IMAGE_TYPE", Value: "mysql", Scope: "global"},
+				{Name: "BUILDER_IMAGE_NAME", Value: "mysql:8.4.2-oracle", Scope: "global"},
+			},
+			wantSource: "mysql:8.4.2-oracle",
+			wantClean:  "uselagoon/mysql-8.4:latest",
+		},
+		{
+			name:        "test3",
+			description: "check that images that are both set are left alone",
+			vars: []variables.LagoonEnvironmentVariable{
+				{Name: "BUILDER_IMAGE_NAME", Value: "mariadb:10.6", Scope: "global"},
+				{Name: "BUILDER_CLEAN_IMAGE_NAME", Value: "uselagoon/mariadb-10.11-drupal", Scope: "global"},
+			},
+			wantSource: "mariadb:10.6",
+			wantClean:  "uselagoon/mariadb-10.11-drupal",
+		},
+		{
+			name:        "test4",
+			description: "check that an unknown clean image keeps the default builder image",
+			vars: []variables.LagoonEnvironmentVariable{
+				{Name: "BUILDER_CLEAN_IMAGE_NAME", Value: "example/custom-mariadb:stable", Scope: "global"},
+			},
+			wantSource: "mariadb:10.6",
+			wantClean:  "example/custom-mariadb:stable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build := generateBuildValues(newResolver(nil, tt.vars))
+			if build.SourceImageName != tt.wantSource || build.CleanImageName != tt.wantClean {
+				t.Errorf("generateBuildValues() images = %v, %v, want %v, %v",
+					build.SourceImageName, build.CleanImageName, tt.wantSource, tt.wantClean)
+			}
+		})
+	}
+}
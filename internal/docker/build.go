@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// BuildOptions are the options for building an image, the same as the flags given to `docker build`
+type BuildOptions struct {
+	// Dockerfile is the path of the Dockerfile in the build context
+	Dockerfile string
+	// Tags are the full image names the result is tagged with
+	Tags []string
+	// BuildArgs are the values of the ARGs in the Dockerfile
+	BuildArgs map[string]string
+	// NetworkMode is the network used by RUN instructions, eg host
+	NetworkMode string
+}
+
+// Build builds an image from a directory, streaming the build output to out
+func (c *Client) Build(ctx context.Context, contextDir string, opts BuildOptions, out io.Writer) error {
+	query := url.Values{}
+	query.Set("dockerfile", opts.Dockerfile)
+	for _, tag := range opts.Tags {
+		query.Add("t", tag)
+	}
+	if len(opts.BuildArgs) > 0 {
+		args, err := json.Marshal(opts.BuildArgs)
+		if err != nil {
+			return err
+		}
+		query.Set("buildargs", string(args))
+	}
+	if opts.NetworkMode != "" {
+		query.Set("networkmode", opts.NetworkMode)
+	}
+	// stream the build context to the docker host rather than holding the whole database dump in memory
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteContext(pw, contextDir))
+	}()
+	defer pr.Close()
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-tar")
+	resp, err := c.do(ctx, http.MethodPost, "/build", query, header, pr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readStream("build", resp.Body, out)
+}
+
+// WriteContext writes a directory as an uncompressed tar, which is the format the docker host expects the build
+// context in, paths are relative to the directory
+func WriteContext(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return writeContextEntry(tw, path, rel, info)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// WriteContextFiles writes only the named files of a directory as an uncompressed tar in the same way as WriteContext,
+// so that anything else left in the directory isn't part of the build context
+func WriteContextFiles(w io.Writer, dir string, files []string) error {
+	tw := tar.NewWriter(w)
+	for _, rel := range files {
+		path := filepath.Join(dir, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return fmt.Errorf("unable to add %s to the build context: %v", rel, err)
+		}
+		if err := writeContextEntry(tw, path, rel, info); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeContextEntry writes a file, directory or symlink to the build context, with the path relative to the context
+func writeContextEntry(tw *tar.Writer, path, rel string, info fs.FileInfo) error {
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("unable to add %s to the build context: %v", rel, err)
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,129 @@
+// Package docker is a small client for the parts of the Docker Engine API that are used to build and push the
+// resulting database image, it replaces running the docker cli against the docker host
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// APIVersion is the version of the Engine API that requests are made against, it is supported by docker 20.10 and later
+const APIVersion = "1.41"
+
+// defaultPort is the port used for tcp docker hosts that don't specify one, the same as the docker cli
+const defaultPort = "2375"
+
+// Client talks to the Engine API of a docker host
+type Client struct {
+	host    string
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client for a docker host, which can be given in any of the forms the docker cli accepts
+// (unix:///var/run/docker.sock, tcp://host:port) as well as http(s):// urls and a bare host or host:port
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		return nil, fmt.Errorf("no docker host provided")
+	}
+	c := &Client{host: host, http: &http.Client{}}
+	scheme, addr, found := strings.Cut(host, "://")
+	if !found {
+		scheme, addr = "tcp", host
+	}
+	switch scheme {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+			},
+		}
+		c.http.Transport = transport
+		// the host is ignored when dialing the socket, but is needed for a valid request
+		c.baseURL = fmt.Sprintf("http://docker/v%s", APIVersion)
+	case "tcp", "http", "https":
+		if scheme == "tcp" {
+			scheme = "http"
+		}
+		addr = strings.TrimSuffix(addr, "/")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, defaultPort)
+		}
+		c.baseURL = fmt.Sprintf("%s://%s/v%s", scheme, addr, APIVersion)
+	default:
+		return nil, fmt.Errorf("unsupported docker host %s, must be a unix, tcp, http or https address", host)
+	}
+	if _, err := url.Parse(c.baseURL); err != nil {
+		return nil, fmt.Errorf("invalid docker host %s: %v", host, err)
+	}
+	return c, nil
+}
+
+// Host returns the docker host that the client was created with
+func (c *Client) Host() string {
+	return c.host
+}
+
+// do sends a request to the Engine API, any response that isn't a success is returned as an *APIError
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u = fmt.Sprintf("%s?%s", u, query.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	b, _ := io.ReadAll(resp.Body)
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(b, &msg); err == nil && msg.Message != "" {
+		apiErr.Message = msg.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(b))
+	}
+	return nil, apiErr
+}
+
+// Ping checks that the Engine API is available
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RemoveImage removes an image from the docker host
+func (c *Client) RemoveImage(ctx context.Context, image string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+	resp, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/images/%s", image), query, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
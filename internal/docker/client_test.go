@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_NewClient(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "docker-host.lagoon-image-builder.svc", want: "http://docker-host.lagoon-image-builder.svc:2375/v1.41"},
+		{host: "tcp://dockerhost:2376", want: "http://dockerhost:2376/v1.41"},
+		{host: "http://127.0.0.1:8080/", want: "http://127.0.0.1:8080/v1.41"},
+		{host: "https://dockerhost", want: "https://dockerhost:2375/v1.41"},
+		{host: "unix:///var/run/docker.sock", want: "http://docker/v1.41"},
+		{host: "ssh://user@dockerhost", wantErr: true},
+		{host: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			c, err := NewClient(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.baseURL != tt.want {
+				t.Errorf("NewClient() = %v, want %v", c.baseURL, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Backoff_Delay(t *testing.T) {
+	b := Backoff{Attempts: 10, Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := b.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	fixed := Backoff{Attempts: 10, Initial: 5 * time.Second}
+	if got := fixed.Delay(5); got != 5*time.Second {
+		t.Errorf("Delay() without a multiplier = %v, want 5s", got)
+	}
+}
+
+func Test_splitTag(t *testing.T) {
+	tests := []struct {
+		image, name, tag string
+	}{
+		{image: "quay.io/org/image:latest", name: "quay.io/org/image", tag: "latest"},
+		{image: "localhost:5000/image", name: "localhost:5000/image", tag: ""},
+		{image: "localhost:5000/image:backup-2026-10-18", name: "localhost:5000/image", tag: "backup-2026-10-18"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			name, tag := splitTag(tt.image)
+			if name != tt.name || tag != tt.tag {
+				t.Errorf("splitTag() = %v, %v, want %v, %v", name, tag, tt.name, tt.tag)
+			}
+		})
+	}
+}
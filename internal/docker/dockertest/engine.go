@@ -0,0 +1,193 @@
+// Package dockertest provides a fake Docker Engine API server, so that builds and pushes can be tested without a
+// docker host
+package dockertest
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Engine is a fake Engine API server that records each request made to it
+type Engine struct {
+	*httptest.Server
+
+	// PingFailures is the number of pings that fail before the engine becomes available
+	PingFailures int
+	// Errors maps an operation (build, auth, push, remove or info) to the error message it fails with
+	Errors map[string]string
+	// DriverStatus is the storage driver status returned by the info endpoint
+	DriverStatus [][2]string
+
+	mu       sync.Mutex
+	requests []string
+	files    []string
+	auths    []map[string]string
+}
+
+// NewEngine starts a fake engine, it is closed when the test ends
+func NewEngine(t interface{ Cleanup(func()) }) *Engine {
+	e := &Engine{Errors: map[string]string{}}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.serve))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// Requests returns each request made to the engine as the method, the path without the api version, and the query
+// parameters sorted by name, eg `POST /images/example/push tag=latest`
+func (e *Engine) Requests() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.requests...)
+}
+
+// ContextFiles returns the names of the files in the build contexts that have been sent to the engine
+func (e *Engine) ContextFiles() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.files...)
+}
+
+// Auths returns the registry credentials sent to the engine, either to log in or with a push
+func (e *Engine) Auths() []map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]map[string]string{}, e.auths...)
+}
+
+func (e *Engine) record(r *http.Request) string {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/v") {
+		if i := strings.Index(path[1:], "/"); i >= 0 {
+			path = path[i+1:]
+		}
+	}
+	parts := []string{r.Method, path}
+	keys := []string{}
+	query := r.URL.Query()
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	e.mu.Lock()
+	e.requests = append(e.requests, strings.Join(parts, " "))
+	e.mu.Unlock()
+	return path
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (e *Engine) serve(w http.ResponseWriter, r *http.Request) {
+	path := e.record(r)
+	operation := ""
+	switch {
+	case path == "/_ping":
+		operation = "ping"
+	case path == "/build":
+		operation = "build"
+	case path == "/auth":
+		operation = "auth"
+	case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/push"):
+		operation = "push"
+	case strings.HasPrefix(path, "/images/") && r.Method == http.MethodDelete:
+		operation = "remove"
+	case path == "/info":
+		operation = "info"
+	}
+	e.mu.Lock()
+	failure := e.Errors[operation]
+	pingFailed := operation == "ping" && e.PingFailures > 0
+	if pingFailed {
+		e.PingFailures--
+	}
+	e.mu.Unlock()
+
+	switch operation {
+	case "ping":
+		if pingFailed {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"message": "engine is starting"})
+			return
+		}
+		w.Write([]byte("OK"))
+	case "build":
+		tr := tar.NewReader(r.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			e.mu.Lock()
+			e.files = append(e.files, hdr.Name)
+			e.mu.Unlock()
+		}
+		io.Copy(io.Discard, r.Body)
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]string{"stream": fmt.Sprintf("Step 1/1 : FROM %s\n", r.URL.Query().Get("dockerfile"))})
+		if failure != "" {
+			enc.Encode(map[string]any{"errorDetail": map[string]string{"message": failure}, "error": failure})
+			return
+		}
+		enc.Encode(map[string]string{"stream": "Successfully built 0123456789ab\n"})
+	case "auth":
+		auth := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&auth)
+		e.mu.Lock()
+		e.auths = append(e.auths, auth)
+		e.mu.Unlock()
+		if failure != "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": failure})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"Status": "Login Succeeded"})
+	case "push":
+		auth := map[string]string{}
+		if b, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth")); err == nil {
+			json.Unmarshal(b, &auth)
+		}
+		e.mu.Lock()
+		e.auths = append(e.auths, auth)
+		e.mu.Unlock()
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]string{"status": "Preparing", "id": "0123456789ab"})
+		enc.Encode(map[string]string{"status": "Pushing", "id": "0123456789ab", "progress": "[=====>    ]"})
+		if failure != "" {
+			enc.Encode(map[string]any{"errorDetail": map[string]string{"message": failure}, "error": failure})
+			return
+		}
+		enc.Encode(map[string]string{"status": "Pushed", "id": "0123456789ab"})
+		enc.Encode(map[string]string{"status": fmt.Sprintf("%s: digest: sha256:0123 size: 1234", r.URL.Query().Get("tag"))})
+	case "remove":
+		if failure != "" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": failure})
+			return
+		}
+		writeJSON(w, http.StatusOK, []map[string]string{{"Untagged": strings.TrimPrefix(path, "/images/")}})
+	case "info":
+		if failure != "" {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": failure})
+			return
+		}
+		e.mu.Lock()
+		status := e.DriverStatus
+		e.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{"Driver": "overlay2", "DockerRootDir": "/var/lib/docker", "DriverStatus": status})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "page not found"})
+	}
+}
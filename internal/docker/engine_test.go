@@ -0,0 +1,223 @@
+package docker_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uselagoon/database-image-task/internal/docker"
+	"github.com/uselagoon/database-image-task/internal/docker/dockertest"
+)
+
+func Test_Client_WaitReady(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		pingFailures int
+		wantRetries  int
+		wantErr      bool
+	}{
+		{
+			name:         "test1",
+			description:  "check that the client waits for the engine to become available",
+			pingFailures: 2,
+			wantRetries:  2,
+		},
+		{
+			name:         "test2",
+			description:  "check that a typed error is returned when the engine never becomes available",
+			pingFailures: 5,
+			wantRetries:  2,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := dockertest.NewEngine(t)
+			engine.PingFailures = tt.pingFailures
+			c, _ := docker.NewClient(engine.URL)
+			retries := 0
+			err := c.WaitReady(context.Background(), docker.Backoff{Attempts: 3, Initial: time.Millisecond}, func(err error, wait time.Duration) {
+				retries++
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var notReady *docker.NotReadyError
+			if tt.wantErr && !errors.As(err, &notReady) {
+				t.Errorf("WaitReady() error = %v, want a *NotReadyError", err)
+			}
+			if retries != tt.wantRetries {
+				t.Errorf("WaitReady() retries = %v, want %v", retries, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func Test_Client_Build(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "mariadb.Dockerfile"), []byte("FROM scratch\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "conf"), 0755)
+	os.WriteFile(filepath.Join(dir, "conf", "my.cnf"), []byte("[mysql]\n"), 0644)
+
+	tests := []struct {
+		name        string
+		description string
+		failure     string
+		wantOutput  string
+		wantErr     string
+	}{
+		{
+			name:        "test1",
+			description: "check that the build context and options are sent and the output is streamed",
+			wantOutput:  "Step 1/1 : FROM mariadb.Dockerfile\nSuccessfully built 0123456789ab\n",
+		},
+		{
+			name:        "test2",
+			description: "check that an error in the build output is returned",
+			failure:     "COPY failed: file not found",
+			wantOutput:  "Step 1/1 : FROM mariadb.Dockerfile\n",
+			wantErr:     "COPY failed: file not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := dockertest.NewEngine(t)
+			if tt.failure != "" {
+				engine.Errors["build"] = tt.failure
+			}
+			c, _ := docker.NewClient(engine.URL)
+			var out bytes.Buffer
+			err := c.Build(context.Background(), dir, docker.BuildOptions{
+				Dockerfile:  "mariadb.Dockerfile",
+				Tags:        []string{"example/image:backup", "example/image:latest"},
+				BuildArgs:   map[string]string{"BUILDER_IMAGE": "mariadb:10.6"},
+				NetworkMode: "host",
+			}, &out)
+			if tt.wantErr != "" {
+				var streamErr *docker.StreamError
+				if !errors.As(err, &streamErr) || streamErr.Operation != "build" || err.Error() != tt.wantErr {
+					t.Errorf("Build() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if out.String() != tt.wantOutput {
+				t.Errorf("Build() output = %q, want %q", out.String(), tt.wantOutput)
+			}
+			wantRequests := []string{
+				`POST /build buildargs={"BUILDER_IMAGE":"mariadb:10.6"} dockerfile=mariadb.Dockerfile networkmode=host t=example/image:backup t=example/image:latest`,
+			}
+			if !reflect.DeepEqual(engine.Requests(), wantRequests) {
+				t.Errorf("Build() requests = %v, want %v", engine.Requests(), wantRequests)
+			}
+			wantFiles := []string{"conf", "conf/my.cnf", "mariadb.Dockerfile"}
+			if !reflect.DeepEqual(engine.ContextFiles(), wantFiles) {
+				t.Errorf("Build() context = %v, want %v", engine.ContextFiles(), wantFiles)
+			}
+		})
+	}
+}
+
+func Test_Client_Push(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	c, _ := docker.NewClient(engine.URL)
+	auth := docker.AuthConfig{Username: "user", Password: "pass", ServerAddress: "quay.io"}
+	if err := c.Login(context.Background(), auth); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := c.Push(context.Background(), "quay.io/org/image:latest", auth, &out); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if err := c.RemoveImage(context.Background(), "quay.io/org/image:latest", true); err != nil {
+		t.Fatalf("RemoveImage() error = %v", err)
+	}
+	wantRequests := []string{
+		"POST /auth",
+		"POST /images/quay.io/org/image/push tag=latest",
+		"DELETE /images/quay.io/org/image:latest force=1",
+	}
+	if !reflect.DeepEqual(engine.Requests(), wantRequests) {
+		t.Errorf("requests = %v, want %v", engine.Requests(), wantRequests)
+	}
+	wantAuth := map[string]string{"username": "user", "password": "pass", "serveraddress": "quay.io"}
+	for _, got := range engine.Auths() {
+		if !reflect.DeepEqual(got, wantAuth) {
+			t.Errorf("auth = %v, want %v", got, wantAuth)
+		}
+	}
+	wantOutput := "0123456789ab: Preparing\n0123456789ab: Pushed\nlatest: digest: sha256:0123 size: 1234\n"
+	if out.String() != wantOutput {
+		t.Errorf("Push() output = %q, want %q", out.String(), wantOutput)
+	}
+}
+
+func Test_Client_errors(t *testing.T) {
+	engine := dockertest.NewEngine(t)
+	engine.Errors["auth"] = "incorrect username or password"
+	engine.Errors["remove"] = "No such image: example:latest"
+	c, _ := docker.NewClient(engine.URL)
+	err := c.Login(context.Background(), docker.AuthConfig{Username: "user", Password: "wrong"})
+	if !errors.Is(err, docker.ErrUnauthorized) || !strings.Contains(err.Error(), "incorrect username or password") {
+		t.Errorf("Login() error = %v, want ErrUnauthorized", err)
+	}
+	err = c.RemoveImage(context.Background(), "example:latest", false)
+	if !errors.Is(err, docker.ErrNotFound) {
+		t.Errorf("RemoveImage() error = %v, want ErrNotFound", err)
+	}
+	var apiErr *docker.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("RemoveImage() error = %v, want an *APIError with a 404", err)
+	}
+}
+
+func Test_Client_Info(t *testing.T) {
+	tests := []struct {
+		name         string
+		description  string
+		driverStatus [][2]string
+		want         uint64
+		wantOK       bool
+	}{
+		{
+			name:        "test1",
+			description: "check the space available isn't known for overlay2",
+			driverStatus: [][2]string{
+				{"Backing Filesystem", "extfs"},
+				{"Supports d_type", "true"},
+			},
+		},
+		{
+			name:        "test2",
+			description: "check the space available reported by devicemapper is read",
+			driverStatus: [][2]string{
+				{"Data Space Used", "1.2 GB"},
+				{"Data Space Available", "10.74 GB"},
+			},
+			want:   10740000000,
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := dockertest.NewEngine(t)
+			engine.DriverStatus = tt.driverStatus
+			c, _ := docker.NewClient(engine.URL)
+			info, err := c.Info(context.Background())
+			if err != nil {
+				t.Fatalf("Info() error = %v", err)
+			}
+			got, ok := info.SpaceAvailable()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("SpaceAvailable() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNotFound is matched by an *APIError for an image or other object that doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is matched by an *APIError when the registry credentials are rejected
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// APIError is an error response returned by the Engine API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker engine returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is allows errors.Is to be used to check for ErrNotFound and ErrUnauthorized
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// StreamError is an error reported part way through the output of a build or push, the request itself succeeds
+// so these are only found by reading the stream
+type StreamError struct {
+	Operation string
+	Message   string
+}
+
+func (e *StreamError) Error() string {
+	return e.Message
+}
+
+// NotReadyError is returned when the docker host doesn't become available before the backoff runs out of attempts
+type NotReadyError struct {
+	Host     string
+	Attempts int
+	Err      error
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("could not connect to %s after %d attempts: %v", e.Host, e.Attempts, e.Err)
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Err
+}
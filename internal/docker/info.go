@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Info is the part of the system information of the docker host that is used
+type Info struct {
+	Driver        string
+	DockerRootDir string
+	// DriverStatus are the key value pairs reported by the storage driver
+	DriverStatus [][2]string
+}
+
+// sizeUnits are the units the storage driver reports sizes in, they are decimal the same as the docker cli
+var sizeUnits = map[string]float64{
+	"B":  1,
+	"kB": 1e3,
+	"KB": 1e3,
+	"MB": 1e6,
+	"GB": 1e9,
+	"TB": 1e12,
+	"PB": 1e15,
+}
+
+// SpaceAvailable returns the free space reported by the storage driver, only some drivers (eg devicemapper) report
+// it, so ok is false if it isn't known
+func (i Info) SpaceAvailable() (uint64, bool) {
+	for _, status := range i.DriverStatus {
+		if status[0] != "Data Space Available" {
+			continue
+		}
+		value, unit, found := strings.Cut(strings.TrimSpace(status[1]), " ")
+		multiplier, known := sizeUnits[unit]
+		if !found || !known {
+			return 0, false
+		}
+		size, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false
+		}
+		return uint64(size * multiplier), true
+	}
+	return 0, false
+}
+
+// Info returns the system information of the docker host
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/info", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	info := &Info{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, fmt.Errorf("unable to read the docker host info: %v", err)
+	}
+	return info, nil
+}
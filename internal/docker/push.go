@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthConfig are the credentials for a registry, an empty ServerAddress is docker hub
+type AuthConfig struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// header encodes the credentials in the format used by the X-Registry-Auth header
+func (a AuthConfig) header() (string, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Login checks the credentials against the registry, the docker host doesn't keep them so they are also given to Push
+func (c *Client) Login(ctx context.Context, auth AuthConfig) error {
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	resp, err := c.do(ctx, http.MethodPost, "/auth", nil, header, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Push pushes an image to its registry, streaming the push output to out
+func (c *Client) Push(ctx context.Context, image string, auth AuthConfig, out io.Writer) error {
+	name, tag := splitTag(image)
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	encoded, err := auth.header()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Registry-Auth", encoded)
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/images/%s/push", name), query, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readStream(fmt.Sprintf("push of %s", image), resp.Body, out)
+}
+
+// splitTag splits an image into its name and tag, a colon before the last slash is a registry port rather than a tag
+func splitTag(image string) (string, string) {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
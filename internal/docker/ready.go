@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff controls how many times WaitReady checks the docker host, and how long it waits between each check
+// the wait starts at Initial and is multiplied by Multiplier after each attempt, up to Max
+type Backoff struct {
+	Attempts   int
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// Delay returns how long to wait after a failed attempt, attempts start at 1
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < attempt; i++ {
+		if b.Multiplier > 1 {
+			delay = time.Duration(float64(delay) * b.Multiplier)
+		}
+		if b.Max > 0 && delay >= b.Max {
+			return b.Max
+		}
+	}
+	return delay
+}
+
+// WaitReady pings the docker host until it responds, calling onRetry before waiting between each failed attempt
+// a *NotReadyError is returned if the docker host still isn't available after all of the attempts
+func (c *Client) WaitReady(ctx context.Context, b Backoff, onRetry func(err error, wait time.Duration)) error {
+	attempts := max(b.Attempts, 1)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = c.Ping(ctx); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		wait := b.Delay(attempt)
+		if onRetry != nil {
+			onRetry(err, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return &NotReadyError{Host: c.host, Attempts: attempts, Err: err}
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// message is a single line of the json stream returned by the build and push endpoints
+type message struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	ID          string `json:"id"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// readStream reads the json stream, writing the output as plain text and returning a *StreamError for any error
+// in the stream, progress bars are left out as they aren't useful in the task logs
+func readStream(operation string, r io.Reader, out io.Writer) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("unable to read the %s output: %v", operation, err)
+		}
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return &StreamError{Operation: operation, Message: msg.ErrorDetail.Message}
+		}
+		if msg.Error != "" {
+			return &StreamError{Operation: operation, Message: msg.Error}
+		}
+		switch {
+		case msg.Stream != "":
+			fmt.Fprint(out, msg.Stream)
+		case msg.Status != "" && msg.Progress == "":
+			status := msg.Status
+			if msg.ID != "" {
+				status = fmt.Sprintf("%s: %s", msg.ID, status)
+			}
+			fmt.Fprintln(out, strings.TrimSpace(status))
+		}
+	}
+}
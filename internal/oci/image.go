@@ -0,0 +1,82 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Credentials are the login for a single registry, every other registry is accessed anonymously
+type Credentials struct {
+	// Registry is the registry host, an empty value is docker hub
+	Registry string
+	Username string
+	Password string
+}
+
+// Resolve implements authn.Keychain
+func (c Credentials) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	registry := c.Registry
+	if registry == "" || registry == "docker.io" {
+		registry = name.DefaultRegistry
+	}
+	if c.Username == "" || r.RegistryStr() != registry {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: c.Username, Password: c.Password}), nil
+}
+
+// Append pulls the base image for the platform and appends the layer to it, the image is only read from the
+// registry as it is needed, so the base image layers are never downloaded
+func Append(ctx context.Context, base string, layer v1.Layer, keychain authn.Keychain, platform v1.Platform, created time.Time) (v1.Image, error) {
+	ref, err := name.ParseReference(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %v", base, err)
+	}
+	img, err := remote.Image(ref,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithPlatform(platform),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to pull %s: %w", base, err)
+	}
+	img, err = mutate.Append(img, mutate.Addendum{
+		Layer: layer,
+		History: v1.History{
+			Created:   v1.Time{Time: created},
+			CreatedBy: "database-image-task: add the sanitised database",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutate.CreatedAt(img, v1.Time{Time: created})
+}
+
+// Push writes the image to the first reference, and then tags it with each of the others, the layers are only
+// uploaded once
+func Push(ctx context.Context, img v1.Image, refs []string, keychain authn.Keychain) error {
+	for i, r := range refs {
+		tag, err := name.NewTag(r)
+		if err != nil {
+			return fmt.Errorf("invalid image %s: %v", r, err)
+		}
+		opts := []remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain)}
+		if i == 0 {
+			err = remote.Write(tag, img, opts...)
+		} else {
+			err = remote.Tag(tag, img, opts...)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to push %s: %w", r, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package oci
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ImageInfo is the config of an image, and the files that were asked for from its filesystem
+type ImageInfo struct {
+	Config v1.Config
+	// Files are keyed by their absolute path, a file is only here if it exists in the image, symlinks are included
+	// with their target as the content
+	Files map[string][]byte
+}
+
+// Inspect reads the config of the image, and looks for each of the files in its layers, starting from the top layer
+// and stopping as soon as every file has been found or removed, so that usually only the last few layers are read
+func Inspect(ctx context.Context, image string, files []string, keychain authn.Keychain, platform v1.Platform) (*ImageInfo, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %v", image, err)
+	}
+	img, err := remote.Image(ref,
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithPlatform(platform),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect %s: %w", image, err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the config of %s: %w", image, err)
+	}
+	info := &ImageInfo{Config: config.Config, Files: map[string][]byte{}}
+	remaining := map[string]bool{}
+	for _, f := range files {
+		remaining[strings.TrimPrefix(path.Clean(f), "/")] = true
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(layers) - 1; i >= 0 && len(remaining) > 0; i-- {
+		if err := findFiles(layers[i], remaining, info.Files); err != nil {
+			return nil, fmt.Errorf("unable to read the layers of %s: %w", image, err)
+		}
+	}
+	return info, nil
+}
+
+// findFiles looks for the remaining files in a layer, removing them from remaining once they are found or whited out
+func findFiles(layer v1.Layer, remaining map[string]bool, found map[string][]byte) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for len(remaining) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := strings.TrimPrefix(path.Clean(hdr.Name), "/")
+		dir, base := path.Split(entry)
+		if strings.HasPrefix(base, ".wh.") {
+			// a whiteout hides the file in the layers below this one
+			delete(remaining, dir+strings.TrimPrefix(base, ".wh."))
+			continue
+		}
+		if !remaining[entry] {
+			continue
+		}
+		delete(remaining, entry)
+		switch hdr.Typeflag {
+		case tar.TypeSymlink, tar.TypeLink:
+			found["/"+entry] = []byte(hdr.Linkname)
+		case tar.TypeReg:
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			found["/"+entry] = b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package oci assembles the resulting database image without a docker daemon, by appending the data directory to
+// the clean image as a new layer and pushing it straight to the registry over the OCI distribution API
+package oci
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// LayerOptions controls where the data directory is put in the image, and who owns it
+type LayerOptions struct {
+	// Target is the absolute path in the image that the contents of the data directory are copied to
+	Target string
+	// UID and GID own every file in the layer, this is the user that the database runs as in the clean image
+	UID int
+	GID int
+	// DirMode replaces the permissions of the target directory if it is set, eg postgres needs 0700
+	DirMode fs.FileMode
+	// Files are extra files added to the layer, keyed by their absolute path in the image
+	Files map[string][]byte
+	// ModTime is the modification time given to the extra files
+	ModTime time.Time
+}
+
+// WriteLayer writes the data directory as an uncompressed layer tar
+func WriteLayer(w io.Writer, dir string, opts LayerOptions) error {
+	tw := tar.NewWriter(w)
+	target := strings.TrimPrefix(path.Clean(opts.Target), "/")
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(target, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid = opts.UID, opts.GID
+		hdr.Uname, hdr.Gname = "", ""
+		if rel == "." && opts.DirMode != 0 {
+			hdr.Mode = int64(opts.DirMode.Perm())
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range opts.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := opts.Files[name]
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(path.Clean(name), "/"),
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Uid:      opts.UID,
+			Gid:      opts.GID,
+			ModTime:  opts.ModTime,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Layer writes the data directory to a tar file and returns it as a layer, the file is used rather than memory as
+// the data directory can be large, and it is read again when the layer is compressed and pushed
+func Layer(dir, tarFile string, opts LayerOptions) (v1.Layer, error) {
+	f, err := os.Create(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteLayer(f, dir, opts); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return tarball.LayerFromFile(tarFile)
+}
@@ -0,0 +1,300 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// listLayer returns each entry in a layer tar as `name uid:gid mode`, with the content of regular files appended
+func listLayer(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	entries := []string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		entry := fmt.Sprintf("%s %d:%d %o", hdr.Name, hdr.Uid, hdr.Gid, hdr.Mode&0777)
+		if hdr.Typeflag == tar.TypeReg {
+			b, _ := io.ReadAll(tr)
+			entry = fmt.Sprintf("%s %q", entry, string(b))
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func testDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "drupal"), 0750); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "drupal", "node.ibd"), []byte("node"), 0640); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ibdata1"), []byte("ibdata"), 0640); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return dir
+}
+
+func TestWriteLayer(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		opts        LayerOptions
+		want        []string
+	}{
+		{
+			name:        "test1",
+			description: "check the data directory is owned by the database user under the target",
+			opts: LayerOptions{
+				Target: "/var/lib/mysql",
+				UID:    100,
+				Files:  map[string][]byte{"/var/lib/mysql/.my.cnf": []byte("[client]\n"), "/etc/mysql/my.cnf": []byte("[client]\n")},
+			},
+			want: []string{
+				"var/lib/mysql/ 100:0 755",
+				"var/lib/mysql/drupal/ 100:0 750",
+				`var/lib/mysql/drupal/node.ibd 100:0 640 "node"`,
+				`var/lib/mysql/ibdata1 100:0 640 "ibdata"`,
+				`etc/mysql/my.cnf 100:0 644 "[client]\n"`,
+				`var/lib/mysql/.my.cnf 100:0 644 "[client]\n"`,
+			},
+		},
+		{
+			name:        "test2",
+			description: "check the permissions of the target directory can be replaced",
+			opts: LayerOptions{
+				Target:  "/var/lib/postgresql/data/",
+				UID:     70,
+				DirMode: 0700,
+			},
+			want: []string{
+				"var/lib/postgresql/data/ 70:0 700",
+				"var/lib/postgresql/data/drupal/ 70:0 750",
+				`var/lib/postgresql/data/drupal/node.ibd 70:0 640 "node"`,
+				`var/lib/postgresql/data/ibdata1 70:0 640 "ibdata"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteLayer(&buf, testDataDir(t), tt.opts); err != nil {
+				t.Fatalf("WriteLayer() error = %v", err)
+			}
+			if got := listLayer(t, &buf); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WriteLayer() = \n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCredentials_Resolve(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		creds       Credentials
+		image       string
+		wantAuth    bool
+	}{
+		{
+			name:        "test1",
+			description: "check the credentials are used for their registry",
+			creds:       Credentials{Registry: "quay.io", Username: "reguser", Password: "regpass"},
+			image:       "quay.io/lagpro/lagenv:latest",
+			wantAuth:    true,
+		},
+		{
+			name:        "test2",
+			description: "check other registries are anonymous",
+			creds:       Credentials{Registry: "quay.io", Username: "reguser", Password: "regpass"},
+			image:       "uselagoon/mariadb-10.6-drupal:latest",
+		},
+		{
+			name:        "test3",
+			description: "check an empty registry is docker hub",
+			creds:       Credentials{Username: "reguser", Password: "regpass"},
+			image:       "uselagoon/mariadb-10.6-drupal:latest",
+			wantAuth:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := name.ParseReference(tt.image)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			auth, err := tt.creds.Resolve(ref.Context())
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if got := auth != authn.Anonymous; got != tt.wantAuth {
+				t.Errorf("Resolve() authenticated = %v, want %v", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestAppendPush(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	base, err := random.Image(256, 2)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	baseRef, _ := name.ParseReference(host + "/uselagoon/mariadb-10.6-drupal:latest")
+	if err := remote.Write(baseRef, base); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	created := time.Date(2026, 10, 18, 1, 2, 3, 0, time.UTC)
+	layer, err := Layer(testDataDir(t), filepath.Join(t.TempDir(), "layer.tar"), LayerOptions{Target: "/var/lib/mysql", UID: 100})
+	if err != nil {
+		t.Fatalf("Layer() error = %v", err)
+	}
+	platform := v1.Platform{OS: "linux", Architecture: "amd64"}
+	creds := Credentials{Registry: host, Username: "reguser", Password: "regpass"}
+	img, err := Append(context.Background(), baseRef.String(), layer, creds, platform, created)
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	refs := []string{host + "/lagpro/lagenv:latest", host + "/lagpro/lagenv:backup-2026-10-18"}
+	if err := Push(context.Background(), img, refs, creds); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	for _, r := range refs {
+		ref, _ := name.ParseReference(r)
+		pushed, err := remote.Image(ref)
+		if err != nil {
+			t.Fatalf("remote.Image(%s) error = %v", r, err)
+		}
+		layers, _ := pushed.Layers()
+		if len(layers) != 3 {
+			t.Fatalf("%s has %d layers, want 3", r, len(layers))
+		}
+		rc, err := layers[2].Uncompressed()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got := listLayer(t, rc)
+		rc.Close()
+		if len(got) != 4 || got[0] != "var/lib/mysql/ 100:0 755" {
+			t.Errorf("%s data layer = %v", r, got)
+		}
+		config, _ := pushed.ConfigFile()
+		if !config.Created.Time.Equal(created) {
+			t.Errorf("%s created = %v, want %v", r, config.Created, created)
+		}
+	}
+
+	if _, err := Append(context.Background(), host+"/uselagoon/missing:latest", layer, creds, platform, created); err == nil ||
+		!strings.Contains(err.Error(), "unable to pull") {
+		t.Errorf("Append() of a missing image error = %v", err)
+	}
+}
+
+// tarLayer returns a layer with the files in order, whiteouts are files named .wh.<name>
+func tarLayer(t *testing.T, files [][2]string) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f[0], Mode: 0644, Size: int64(len(f[1])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("%v", err)
+		}
+		tw.Write([]byte(f[1]))
+	}
+	tw.Close()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return layer
+}
+
+func TestInspect(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := mutate.AppendLayers(empty.Image,
+		tarLayer(t, [][2]string{
+			{"etc/passwd", "root:x:0:0:root:/root:/bin/sh\n"},
+			{"etc/my.cnf", "[mysqld]\n"},
+			{"etc/mysql/my.cnf", "[client]\n"},
+		}),
+		tarLayer(t, [][2]string{
+			{"./etc/passwd", "root:x:0:0:root:/root:/bin/sh\nmysql:x:100:101::/var/lib/mysql:/sbin/nologin\n"},
+			{"etc/.wh.my.cnf", ""},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	img, err = mutate.Config(img, v1.Config{User: "mysql", Volumes: map[string]struct{}{"/var/lib/mysql": {}}})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	image := host + "/uselagoon/mariadb-10.6-drupal:latest"
+	ref, _ := name.ParseReference(image)
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	platform := v1.Platform{OS: "linux", Architecture: "amd64"}
+	info, err := Inspect(context.Background(), image, []string{"/etc/passwd", "/etc/my.cnf", "/etc/mysql/my.cnf", "/etc/missing"}, Credentials{}, platform)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if info.Config.User != "mysql" {
+		t.Errorf("Inspect() user = %v, want mysql", info.Config.User)
+	}
+	want := map[string][]byte{
+		"/etc/passwd":       []byte("root:x:0:0:root:/root:/bin/sh\nmysql:x:100:101::/var/lib/mysql:/sbin/nologin\n"),
+		"/etc/mysql/my.cnf": []byte("[client]\n"),
+	}
+	if !reflect.DeepEqual(info.Files, want) {
+		t.Errorf("Inspect() files = %q, want %q", info.Files, want)
+	}
+
+	if _, err := Inspect(context.Background(), host+"/uselagoon/missing:latest", nil, Credentials{}, platform); err == nil ||
+		!strings.Contains(err.Error(), "unable to inspect") {
+		t.Errorf("Inspect() of a missing image error = %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ListTags returns all of the tags in a repository
+func ListTags(ctx context.Context, repository string, keychain authn.Keychain) ([]string, error) {
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository %s: %v", repository, err)
+	}
+	tags, err := remote.List(repo, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the tags of %s: %w", repository, err)
+	}
+	return tags, nil
+}
+
+// Created returns when the image was created according to its config, for an index the first image in it is used
+func Created(ctx context.Context, image string, keychain authn.Keychain) (time.Time, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid image %s: %v", image, err)
+	}
+	desc, err := remote.Get(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to inspect %s: %w", image, err)
+	}
+	var img v1.Image
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to inspect %s: %w", image, err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to inspect %s: %w", image, err)
+		}
+		if len(manifest.Manifests) == 0 {
+			return time.Time{}, fmt.Errorf("unable to inspect %s: the index has no images", image)
+		}
+		img, err = index.Image(manifest.Manifests[0].Digest)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to inspect %s: %w", image, err)
+		}
+	} else if img, err = desc.Image(); err != nil {
+		return time.Time{}, fmt.Errorf("unable to inspect %s: %w", image, err)
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to read the config of %s: %w", image, err)
+	}
+	return config.Created.Time, nil
+}
+
+// Digest returns the digest of the manifest that a tag points to
+func Digest(ctx context.Context, image string, keychain authn.Keychain) (string, error) {
+	ref, err := name.NewTag(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid image %s: %v", image, err)
+	}
+	desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect %s: %w", image, err)
+	}
+	return desc.Digest.String(), nil
+}
+
+// DeleteManifest removes a manifest from the registry by its digest, which removes every tag that points to it, the
+// distribution API has no way to remove only a tag and most registries reject deleting a manifest by its tag
+func DeleteManifest(ctx context.Context, repository, digest string, keychain authn.Keychain) error {
+	ref, err := name.NewDigest(fmt.Sprintf("%s@%s", repository, digest))
+	if err != nil {
+		return fmt.Errorf("invalid image %s@%s: %v", repository, digest, err)
+	}
+	if err := remote.Delete(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain)); err != nil {
+		return fmt.Errorf("unable to delete %s: %w", ref, err)
+	}
+	return nil
+}